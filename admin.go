@@ -0,0 +1,323 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HISTORY_CACHE_TTL is how long the scan of past games' results is reused before the logs are read again
+const HISTORY_CACHE_TTL = 30 * time.Second
+
+// historyCache holds the most recent scan of the logs directory
+type historyCache struct {
+	sync.Mutex
+	scannedAt time.Time
+	stats     historyStats
+	problems  []ProblemStats
+}
+
+// historyStats summarise the games that have been played to completion
+type historyStats struct {
+	GamesPlayed int `json:"gamesPlayed"`
+	// AverageGameDuration is in seconds
+	AverageGameDuration float64 `json:"averageGameDuration"`
+}
+
+// ProblemStats are how players have fared on a problem across every past game, to inform its difficulty
+type ProblemStats struct {
+	Title     string `json:"title"`
+	Attempted int    `json:"attempted"`
+	Solved    int    `json:"solved"`
+	// CorrectRate is the fraction of attempts that were solved
+	CorrectRate float64 `json:"correctRate"`
+	// AverageSolveTime is in seconds, over the attempts that were solved
+	AverageSolveTime float64 `json:"averageSolveTime"`
+}
+
+// ServerStats is the overview shown on the admin dashboard
+type ServerStats struct {
+	LobbiesByState   map[GameState]int `json:"lobbiesByState"`
+	ConnectedClients int               `json:"connectedClients"`
+	historyStats
+}
+
+// isAdmin reports whether the request carries the configured admin token as a bearer token
+func isAdmin(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) == 1
+}
+
+// requireAdmin only lets requests through if they carry the configured admin token as a bearer token
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.AdminToken == "" {
+			// Admin endpoints are disabled
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !isAdmin(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// scanHistory reads every saved game result in the logs directory, summarising the games and
+// (by title) the problems played in them
+func scanHistory() (historyStats, []ProblemStats) {
+	var stats historyStats
+	paths, err := filepath.Glob(filepath.Join(logsDirectory, "*.result.json"))
+	if err != nil {
+		log.Println(err)
+		return stats, nil
+	}
+
+	totalDuration := 0
+	byTitle := make(map[string]*ProblemResult)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var result struct {
+			GameDuration int             `json:"gameDuration"`
+			Problems     []ProblemResult `json:"problems"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			log.Printf("Skipping malformed result %s: %v", path, err)
+			continue
+		}
+		stats.GamesPlayed++
+		totalDuration += result.GameDuration
+		for _, problem := range result.Problems {
+			total, ok := byTitle[problem.Title]
+			if !ok {
+				total = &ProblemResult{Title: problem.Title}
+				byTitle[problem.Title] = total
+			}
+			total.Attempted += problem.Attempted
+			total.Solved += problem.Solved
+			total.SolveTime += problem.SolveTime
+		}
+	}
+	if stats.GamesPlayed > 0 {
+		stats.AverageGameDuration = float64(totalDuration) / float64(stats.GamesPlayed)
+	}
+
+	problems := make([]ProblemStats, 0, len(byTitle))
+	for _, total := range byTitle {
+		problem := ProblemStats{Title: total.Title, Attempted: total.Attempted, Solved: total.Solved}
+		if total.Attempted > 0 {
+			problem.CorrectRate = float64(total.Solved) / float64(total.Attempted)
+		}
+		if total.Solved > 0 {
+			problem.AverageSolveTime = total.SolveTime / float64(total.Solved)
+		}
+		problems = append(problems, problem)
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Title < problems[j].Title })
+	return stats, problems
+}
+
+// refreshHistory rescans the logs directory if the cached scan is stale
+// @dev Pre-condition: the caller holds m.history's lock
+func (m *Manager) refreshHistory() {
+	if time.Since(m.history.scannedAt) > HISTORY_CACHE_TTL {
+		m.history.stats, m.history.problems = scanHistory()
+		m.history.scannedAt = time.Now()
+	}
+}
+
+// pastGameStats returns the past games' stats, rescanning the logs directory if the cached scan is stale
+func (m *Manager) pastGameStats() historyStats {
+	m.history.Lock()
+	defer m.history.Unlock()
+
+	m.refreshHistory()
+	return m.history.stats
+}
+
+// pastProblemStats returns the per-problem stats across past games, rescanning the logs directory if the
+// cached scan is stale
+func (m *Manager) pastProblemStats() []ProblemStats {
+	m.history.Lock()
+	defer m.history.Unlock()
+
+	m.refreshHistory()
+	return m.history.problems
+}
+
+// reloadProblemsHandler reloads the default problem set from disk, for new games
+func reloadProblemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := ReloadProblems(); err != nil {
+		log.Printf("Failed to reload problems: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Printf("Reloaded %d problems", len(GetProblems().Problems))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cleanupResultsHandler deletes results older than the olderThan query parameter (e.g. "720h"), or the
+// configured retention period if it isn't given
+func (m *Manager) cleanupResultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	retention := config.ResultRetention
+	if olderThan := r.URL.Query().Get("olderThan"); olderThan != "" {
+		var err error
+		retention, err = time.ParseDuration(olderThan)
+		if err != nil || retention <= 0 {
+			http.Error(w, "olderThan must be a positive duration", http.StatusBadRequest)
+			return
+		}
+	} else if retention == 0 {
+		http.Error(w, "results are kept forever; give olderThan to remove old ones", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := m.cleanupResults(retention)
+	if err != nil {
+		log.Printf("Failed to clean up results: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(struct {
+		Removed int `json:"removed"`
+	}{removed})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// resetLobby disconnects everyone from the lobby and puts it back to waiting for players, as if nobody had
+// played yet. Users keep their logins, but lose their progress; a game in play is abandoned without its
+// results being saved
+func (m *Manager) resetLobby(lobby *Lobby) {
+	lobby.Lock()
+	for _, timer := range []*time.Timer{lobby.endTimer, lobby.ceilingTimer, lobby.roundTimer, lobby.ownerHandover, lobby.startAckTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	lobby.endTimer, lobby.ceilingTimer, lobby.roundTimer, lobby.ownerHandover = nil, nil, nil, nil
+	lobby.startAckTimer, lobby.awaitingAcks = nil, nil
+
+	lobby.gameState = WaitingForPlayers
+	lobby.startTime = nil
+	lobby.round = 0
+	lobby.roundStartedAt = time.Time{}
+	lobby.roundBaselines = nil
+	lobby.served = make(map[int]int)
+	lobby.problemResults = make(map[int]ProblemResult)
+	lobby.anonymousLabels = nil
+	// The next game picks its own seed unless the owner gives one, rather than replaying this one
+	lobby.settings.Seed = 0
+	lobby.chatHistory = newChatHistory(config.ChatHistorySize)
+	for name, user := range lobby.userMapping {
+		lobby.userMapping[name] = User{password: user.password, spectator: user.spectator, guest: user.guest, identity: user.identity}
+	}
+	for client := range lobby.clients {
+		client.disconnect(CloseLobbyReset, "The lobby was reset by an admin")
+	}
+	lobby.Unlock()
+
+	lobby.eventLogLock.Lock()
+	lobby.eventLog = nil
+	lobby.eventLogLock.Unlock()
+}
+
+// resetLobbyHandler resets the lobby given by the l query parameter, for when it's stuck and can't be
+// recovered from inside the game. With delete=true, the lobby is removed altogether instead
+func (m *Manager) resetLobbyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	lobby, ok := m.getLobby(r.URL.Query().Get("l"))
+	if !ok {
+		http.Error(w, "lobby not found", http.StatusNotFound)
+		return
+	}
+
+	m.resetLobby(lobby)
+	if r.URL.Query().Get("delete") == "true" {
+		m.removeSnapshot(lobby)
+		m.removeLobby(lobby)
+		log.Printf("Deleted lobby %s", lobby.id)
+	} else {
+		m.saveSnapshot(lobby)
+		log.Printf("Reset lobby %s", lobby.id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// problemStatsHandler reports how players have fared on each problem across past games
+func (m *Manager) problemStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := json.Marshal(struct {
+		Problems []ProblemStats `json:"problems"`
+	}{m.pastProblemStats()})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// adminStatsHandler reports server-wide statistics: the current lobbies and clients, and past games
+func (m *Manager) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := ServerStats{
+		LobbiesByState: make(map[GameState]int),
+		historyStats:   m.pastGameStats(),
+	}
+	for _, lobby := range m.allLobbies() {
+		lobby.RLock()
+		stats.LobbiesByState[lobby.gameState]++
+		stats.ConnectedClients += len(lobby.clients)
+		lobby.RUnlock()
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
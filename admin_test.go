@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// useAdminToken sets the admin token for the rest of the test
+func useAdminToken(t *testing.T, token string) {
+	previous := config
+	config.AdminToken = token
+	t.Cleanup(func() { config = previous })
+}
+
+func getAdminStats(t *testing.T, manager *Manager, token string) (*httptest.ResponseRecorder, ServerStats) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/admin/stats", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	requireAdmin(manager.adminStatsHandler)(rec, req)
+
+	var stats ServerStats
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, stats
+}
+
+func TestAdminStatsHandler(t *testing.T) {
+	useAdminToken(t, "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	useTempLogsDirectory(t)
+
+	waiting := NewLobby(ctx, "waiting", "waiting")
+	playing := NewLobby(ctx, "playing", "playing")
+	playing.startGame(time.Now())
+	manager.lobbies[waiting.id] = waiting
+	manager.lobbies[playing.id] = playing
+	playing.clients[&Client{name: "alice"}] = true
+	playing.clients[&Client{name: "bob"}] = true
+
+	for name, result := range map[string]string{
+		"a.result.json": `{"name": "a", "gameDuration": 600}`,
+		"b.result.json": `{"name": "b", "gameDuration": 300}`,
+		"c.result.json": `not json`,
+		"notes.txt":     `{"gameDuration": 1000}`,
+	} {
+		if err := os.WriteFile(filepath.Join(logsDirectory, name), []byte(result), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec, stats := getAdminStats(t, manager, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if stats.LobbiesByState[WaitingForPlayers] != 1 || stats.LobbiesByState[InPlay] != 1 {
+		t.Errorf("expected one waiting and one playing lobby, got %v", stats.LobbiesByState)
+	}
+	if stats.ConnectedClients != 2 {
+		t.Errorf("expected 2 connected clients, got %d", stats.ConnectedClients)
+	}
+	if stats.GamesPlayed != 2 || stats.AverageGameDuration != 450 {
+		t.Errorf("expected 2 games averaging 450s, got %d averaging %v", stats.GamesPlayed, stats.AverageGameDuration)
+	}
+
+	// The history is cached, so new results aren't picked up straight away
+	os.WriteFile(filepath.Join(logsDirectory, "d.result.json"), []byte(`{"gameDuration": 0}`), 0644)
+	if _, stats := getAdminStats(t, manager, "secret"); stats.GamesPlayed != 2 {
+		t.Errorf("expected the cached history to be used, got %d games", stats.GamesPlayed)
+	}
+}
+
+func TestAdminStatsHandler_RequiresToken(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	useAdminToken(t, "")
+	if rec, _ := getAdminStats(t, manager, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the endpoint to be disabled without a token configured, got %d", rec.Code)
+	}
+
+	useAdminToken(t, "secret")
+	if rec, _ := getAdminStats(t, manager, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for the wrong token, got %d", rec.Code)
+	}
+}
+
+func TestProblemStatsHandler(t *testing.T) {
+	useAdminToken(t, "secret")
+	manager := NewManager(context.Background())
+	useTempLogsDirectory(t)
+
+	for name, result := range map[string]string{
+		"a.result.json": `{"name": "a", "problems": [
+			{"title": "Fractions", "attempted": 3, "solved": 2, "solveTime": 40},
+			{"title": "Matrices", "attempted": 2, "solved": 0, "solveTime": 0}
+		]}`,
+		"b.result.json": `{"name": "b", "problems": [
+			{"title": "Fractions", "attempted": 1, "solved": 1, "solveTime": 50}
+		]}`,
+		// Results saved before problems were tracked
+		"c.result.json": `{"name": "c", "gameDuration": 600}`,
+	} {
+		if err := os.WriteFile(filepath.Join(logsDirectory, name), []byte(result), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/problem-stats", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	requireAdmin(manager.problemStatsHandler)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Problems []ProblemStats `json:"problems"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ProblemStats{
+		{Title: "Fractions", Attempted: 4, Solved: 3, CorrectRate: 0.75, AverageSolveTime: 30},
+		{Title: "Matrices", Attempted: 2, Solved: 0, CorrectRate: 0, AverageSolveTime: 0},
+	}
+	if len(resp.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %+v", len(expected), resp.Problems)
+	}
+	for i, e := range expected {
+		if resp.Problems[i] != e {
+			t.Errorf("expected %+v, got %+v", e, resp.Problems[i])
+		}
+	}
+}
+
+func TestCleanupResultsHandler(t *testing.T) {
+	useAdminToken(t, "secret")
+	config.ResultRetention = 24 * time.Hour
+	manager := NewManager(context.Background())
+	useTempLogsDirectory(t)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"old.result.json", "old.problems.json", "new.result.json", "new.problems.json"} {
+		path := filepath.Join(logsDirectory, name)
+		if err := os.WriteFile(path, []byte(`{"name": "game"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(name, "old") {
+			if err := os.Chtimes(path, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if stats := manager.pastGameStats(); stats.GamesPlayed != 2 {
+		t.Fatalf("expected 2 past games before cleaning up, got %d", stats.GamesPlayed)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/cleanup-results", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	requireAdmin(manager.cleanupResultsHandler)(rec, req)
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"removed":1}` {
+		t.Fatalf("expected one game to be removed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for name, kept := range map[string]bool{
+		"old.result.json": false, "old.problems.json": false, "new.result.json": true, "new.problems.json": true,
+	} {
+		_, err := os.Stat(filepath.Join(logsDirectory, name))
+		if exists := err == nil; exists != kept {
+			t.Errorf("expected %s to be kept: %v, but it exists: %v", name, kept, exists)
+		}
+	}
+	// The cached history doesn't count the removed game
+	if stats := manager.pastGameStats(); stats.GamesPlayed != 1 {
+		t.Errorf("expected 1 past game after cleaning up, got %d", stats.GamesPlayed)
+	}
+
+	// Without a retention period, the age has to be given
+	config.ResultRetention = 0
+	rec = httptest.NewRecorder()
+	requireAdmin(manager.cleanupResultsHandler)(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a retention period, got %d", rec.Code)
+	}
+}
+
+// resetLobby asks the admin endpoint to reset (or with query delete=true, delete) the lobby
+func resetLobby(t *testing.T, manager *Manager, query string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/lobby/reset?"+query, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	requireAdmin(manager.resetLobbyHandler)(rec, req)
+	return rec.Code
+}
+
+func TestResetLobbyHandler(t *testing.T) {
+	useAdminToken(t, "secret")
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}, {Title: "Two", Latex: "b", Answer: "b"}})
+	manager := testManagers[lobby]
+	server := newTestServer(t, manager)
+	alice := connectTestClient(t, server, lobby, "alice")
+	bob := connectTestClient(t, server, lobby, "bob")
+	findClient(t, lobby, "bob")
+	lobby.startGame(time.Now())
+	manager.startGameTimers(lobby)
+	lobby.userMapping["alice"] = User{password: "hash", score: 5, questionNumber: 1, ready: true}
+	lobby.settings.Seed = 42
+
+	if code := resetLobby(t, manager, "l=missing"); code != http.StatusNotFound {
+		t.Errorf("expected a missing lobby to be a 404, got %d", code)
+	}
+	if code := resetLobby(t, manager, "l="+lobby.id); code != http.StatusNoContent {
+		t.Fatalf("expected the lobby to be reset, got %d", code)
+	}
+	for _, conn := range []*websocket.Conn{alice, bob} {
+		if closeErr := readUntilClose(t, conn); closeErr.Code != CloseLobbyReset {
+			t.Errorf("expected close %d, got %d (%s)", CloseLobbyReset, closeErr.Code, closeErr.Text)
+		}
+	}
+	waitUntilDisconnected(t, lobby, "alice")
+	waitUntilDisconnected(t, lobby, "bob")
+
+	lobby.RLock()
+	defer lobby.RUnlock()
+	if lobby.gameState != WaitingForPlayers || lobby.startTime != nil || lobby.endTimer != nil {
+		t.Errorf("expected the lobby to be waiting for players again, got %s", lobby.gameState)
+	}
+	if lobby.settings.Seed != 0 {
+		t.Errorf("expected the last game's seed to be forgotten, got %d", lobby.settings.Seed)
+	}
+	if user := lobby.userMapping["alice"]; !reflect.DeepEqual(user, User{password: "hash"}) {
+		t.Errorf("expected alice to keep only their login, got %+v", user)
+	}
+	if _, ok := manager.getLobby(lobby.id); !ok {
+		t.Error("expected the lobby to be kept")
+	}
+}
+
+func TestResetLobbyHandler_Delete(t *testing.T) {
+	useAdminToken(t, "secret")
+	lobby := newTestLobby(t, nil)
+	manager := testManagers[lobby]
+
+	if code := resetLobby(t, manager, "l="+lobby.id+"&delete=true"); code != http.StatusNoContent {
+		t.Fatalf("expected the lobby to be deleted, got %d", code)
+	}
+	if _, ok := manager.getLobby(lobby.id); ok {
+		t.Error("expected the lobby to be removed")
+	}
+}
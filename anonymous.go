@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// assignAnonymousLabels gives every player a label ("Player 1", "Player 2", ...) to be shown instead of
+// their name when the game hides names. Labels are shuffled so they don't give away anyone's name.
+// @dev Requires the lobby's lock to be held
+func (l *Lobby) assignAnonymousLabels() {
+	names := make([]string, 0, len(l.userMapping))
+	for name, user := range l.userMapping {
+		if !user.spectator {
+			names = append(names, name)
+		}
+	}
+	// Map iteration order isn't random enough to rely on
+	sort.Strings(names)
+	rand.Shuffle(len(names), func(i, j int) {
+		names[i], names[j] = names[j], names[i]
+	})
+
+	l.anonymousLabels = make(map[string]string, len(names))
+	for i, name := range names {
+		l.anonymousLabels[name] = fmt.Sprintf("Player %d", i+1)
+	}
+}
+
+// anonymousLabel returns the player's label, giving players who joined after the game started the next one.
+// @dev Requires the lobby's (write) lock to be held
+func (l *Lobby) anonymousLabel(name string) string {
+	if l.anonymousLabels == nil {
+		l.anonymousLabels = make(map[string]string)
+	}
+	label, ok := l.anonymousLabels[name]
+	if !ok {
+		label = fmt.Sprintf("Player %d", len(l.anonymousLabels)+1)
+		l.anonymousLabels[name] = label
+	}
+	return label
+}
+
+// broadcastAbout sends everyone (and the feeds) an event about the named player, built with the name to
+// show. When the game hides names, only the owner and the player themselves see the real name
+func (l *Lobby) broadcastAbout(name string, event func(shown string) (Event, error)) error {
+	named, err := event(name)
+	if err != nil {
+		return err
+	}
+	if !l.settings.AnonymousNames {
+		l.broadcast(named)
+		l.publishToFeeds(named)
+		return nil
+	}
+
+	l.Lock()
+	label := l.anonymousLabel(name)
+	l.Unlock()
+	anonymous, err := event(label)
+	if err != nil {
+		return err
+	}
+	fanOut(l.snapshotClients(), func(client *Client) {
+		l.RLock()
+		seesName := client.name == name || l.isOwner(client.name)
+		l.RUnlock()
+		if seesName {
+			client.trySend(named)
+		} else {
+			client.trySend(anonymous)
+		}
+	})
+	l.publishToFeeds(anonymous)
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// scoreUpdateNames returns the names in the score updates sent to the client
+func scoreUpdateNames(t *testing.T, c *Client) []string {
+	t.Helper()
+	names := make([]string, 0)
+	for _, event := range drainEvents(c) {
+		if event.Type != EventNewScoreUpdate {
+			continue
+		}
+		var update NewScoreUpdateEvent
+		if err := json.Unmarshal(event.Payload, &update); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, update.Name)
+	}
+	return names
+}
+
+func TestBroadcastAbout_AnonymousNames(t *testing.T) {
+	for _, anonymous := range []bool{false, true} {
+		lobby := newTestLobby(t, []Problem{
+			{Title: "One", Latex: "a", Answer: "a"},
+			{Title: "Two", Latex: "b", Answer: "b"},
+		})
+		owner := addTestClient(lobby, "owner")
+		lobby.owner = &owner.name
+		alice := addTestClient(lobby, "alice")
+		bob := addTestClient(lobby, "bob")
+		lobby.settings.AnonymousNames = anonymous
+		lobby.assignAnonymousLabels()
+		lobby.startGame(time.Now())
+
+		giveAnswer(t, alice, "a")
+		expected := "alice"
+		if anonymous {
+			expected = lobby.anonymousLabels["alice"]
+		}
+		if names := scoreUpdateNames(t, bob); len(names) != 1 || names[0] != expected {
+			t.Errorf("anonymous %v: expected bob to see a score update for %q, got %v", anonymous, expected, names)
+		}
+		// The owner and the player themselves always see the real name
+		if names := scoreUpdateNames(t, owner); len(names) != 1 || names[0] != "alice" {
+			t.Errorf("anonymous %v: expected the owner to see alice's name, got %v", anonymous, names)
+		}
+		if names := scoreUpdateNames(t, alice); len(names) != 1 || names[0] != "alice" {
+			t.Errorf("anonymous %v: expected alice to see their own name, got %v", anonymous, names)
+		}
+
+		// Labels stay the same for each player
+		giveAnswer(t, alice, "b")
+		if names := scoreUpdateNames(t, bob); len(names) != 1 || names[0] != expected {
+			t.Errorf("anonymous %v: expected bob to see the same name again, got %v", anonymous, names)
+		}
+	}
+}
+
+func TestAssignAnonymousLabels(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	lobby.userMapping["alice"] = User{}
+	lobby.userMapping["bob"] = User{}
+	lobby.userMapping["watcher"] = User{spectator: true}
+	lobby.assignAnonymousLabels()
+
+	labels := map[string]bool{lobby.anonymousLabels["alice"]: true, lobby.anonymousLabels["bob"]: true}
+	if !labels["Player 1"] || !labels["Player 2"] {
+		t.Errorf("expected alice and bob to be players 1 and 2, got %v", lobby.anonymousLabels)
+	}
+	if _, ok := lobby.anonymousLabels["watcher"]; ok {
+		t.Error("expected spectators not to get a label")
+	}
+	// Players joining later get the next label
+	if label := lobby.anonymousLabel("carol"); label != "Player 3" {
+		t.Errorf("expected a late joiner to be player 3, got %s", label)
+	}
+}
+
+func TestRoster_AnonymousNamesHideScores(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}, {Title: "Two", Latex: "b", Answer: "b"}})
+	owner := addTestClient(lobby, "owner")
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	lobby.settings.AnonymousNames = true
+	lobby.assignAnonymousLabels()
+	lobby.startGame(time.Now())
+
+	// Everyone's sent the same diff, so it can't pair alice's name with her score
+	giveAnswer(t, alice, "a")
+	for _, event := range drainEvents(bob) {
+		if event.Type != EventRosterDiff {
+			continue
+		}
+		var diff RosterDiffEvent
+		if err := json.Unmarshal(event.Payload, &diff); err != nil {
+			t.Fatal(err)
+		}
+		if diff.Player != nil && diff.Player.Score != nil {
+			t.Errorf("expected roster diffs to leave out scores while names are hidden, got %+v", *diff.Player)
+		}
+	}
+
+	scores := func(c *Client) map[string]*int {
+		t.Helper()
+		drainEvents(c)
+		if err := GetPlayersHandler(Event{EventGetPlayers, nil}, c); err != nil {
+			t.Fatal(err)
+		}
+		var players PlayersEvent
+		if events := drainEvents(c); len(events) != 1 {
+			t.Fatalf("expected the players to be sent, got %v", events)
+		} else if err := json.Unmarshal(events[0].Payload, &players); err != nil {
+			t.Fatal(err)
+		}
+		scores := make(map[string]*int)
+		for _, player := range players.Players {
+			scores[player.Name] = player.Score
+		}
+		return scores
+	}
+	if got := scores(bob); got["alice"] != nil || got["bob"] == nil {
+		t.Errorf("expected bob to only see his own score, got %v", got)
+	}
+	if got := scores(owner); got["alice"] == nil || *got["alice"] != 1 {
+		t.Errorf("expected the owner to see alice's score, got %v", got)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MAX_EVENT_LOG is how many events a lobby's event log keeps; later events are left out of it
+const MAX_EVENT_LOG = 10000
+
+// LoggedEvent is one of a lobby's public events, as sent to its spectator feeds
+type LoggedEvent struct {
+	At      time.Time       `json:"at"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// sessionRecord is what's saved about a finished game on top of its results and problems
+type sessionRecord struct {
+	Config GameConfigEvent `json:"config"`
+	Events []LoggedEvent   `json:"events"`
+}
+
+// SessionArchive is everything recorded about a finished game, so it can be audited or shared as one file
+type SessionArchive struct {
+	Id     string          `json:"id"`
+	Config GameConfigEvent `json:"config"`
+	// Events are the game's public events, in the order they happened
+	Events   []LoggedEvent `json:"events"`
+	Problems []Problem     `json:"problems"`
+	Result   GameResult    `json:"result"`
+}
+
+// logEvent adds the event to the lobby's event log
+func (l *Lobby) logEvent(event Event) {
+	l.eventLogLock.Lock()
+	defer l.eventLogLock.Unlock()
+
+	if len(l.eventLog) >= MAX_EVENT_LOG {
+		return
+	}
+	l.eventLog = append(l.eventLog, LoggedEvent{time.Now(), event.Type, event.Payload})
+}
+
+// saveSession saves the finished game's configuration and event log to the sessions directory
+func (l *Lobby) saveSession() error {
+	l.RLock()
+	record := sessionRecord{Config: l.gameConfig()}
+	l.RUnlock()
+	l.eventLogLock.Lock()
+	record.Events = append([]LoggedEvent{}, l.eventLog...)
+	l.eventLogLock.Unlock()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sessionsDirectory, os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionsDirectory, l.id+".session.json"), data, 0644)
+}
+
+// readSavedJSON decodes the file a finished game saved in the directory with the given suffix
+func readSavedJSON(dir string, id string, suffix string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(dir, id+suffix))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to read %s%s: %v", id, suffix, err)
+	}
+	return nil
+}
+
+// archiveHandler returns a finished game's configuration, event log, problems (answers and all) and results as
+// a single JSON download
+func archiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("l")
+	if id == "" || filepath.Base(id) != id {
+		http.Error(w, "invalid lobby id", http.StatusBadRequest)
+		return
+	}
+
+	archive := SessionArchive{Id: id}
+	var session sessionRecord
+	var problems Problems
+	err := readSavedJSON(logsDirectory, id, ".result.json", &archive.Result)
+	if err == nil {
+		err = readSavedJSON(logsDirectory, id, ".problems.json", &problems)
+	}
+	if err == nil {
+		err = readSavedJSON(sessionsDirectory, id, ".session.json", &session)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// The game hasn't finished, or was saved before sessions were
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	archive.Config, archive.Events, archive.Problems = session.Config, session.Events, problems.Problems
+
+	data, err := json.Marshal(archive)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+id+".archive.json\"")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
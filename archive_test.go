@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func getArchive(t *testing.T, id string) (*httptest.ResponseRecorder, SessionArchive) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	archiveHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/lobby/archive?l="+id, nil))
+	var archive SessionArchive
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &archive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, archive
+}
+
+func TestArchiveHandler(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	alice := addTestClient(lobby, "alice")
+	custom := Problems{Problems: []Problem{
+		{Title: "One", Description: "d", Latex: "a", Answer: "a"},
+		{Title: "Two", Description: "d", Latex: "b", Answer: "b"},
+	}}
+	err := requestStartGame(t, owner, RequestStartGameEvent{
+		Duration:          600,
+		UseCustomProblems: true,
+		CustomProblems:    custom,
+		GameSettings:      GameSettings{WrongAnswerPenalty: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The game isn't archived until it's over
+	if rec, _ := getArchive(t, lobby.id); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 while the game is in play, got %d", rec.Code)
+	}
+
+	if err := giveAnswer(t, alice, "a"); err != nil {
+		t.Fatal(err)
+	}
+	testManagers[lobby].finishGame(lobby, "Game over!")
+
+	// The session is only for admins, so it's kept out of the publicly served logs
+	if _, err := os.Stat(filepath.Join(logsDirectory, lobby.id+".session.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the session to be saved outside the logs directory, got %v", err)
+	}
+
+	rec, archive := getArchive(t, lobby.id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if archive.Id != lobby.id || archive.Config.Duration != 600 || archive.Config.WrongAnswerPenalty != 1 {
+		t.Errorf("expected the game's config to be archived, got %+v", archive.Config)
+	}
+	if len(archive.Problems) != 2 || archive.Problems[0].Answer != "a" {
+		t.Errorf("expected the problems to be archived with their answers, got %+v", archive.Problems)
+	}
+	if len(archive.Result.Players) != 2 || archive.Result.Players[0].Name != "alice" || archive.Result.Players[0].Score != 1 {
+		t.Errorf("expected the results to be archived, got %+v", archive.Result.Players)
+	}
+
+	// The events are in the order they happened, from the start of the game to its end
+	var types []string
+	for i, event := range archive.Events {
+		types = append(types, event.Type)
+		if i > 0 && event.At.Before(archive.Events[i-1].At) {
+			t.Errorf("expected the events in order, but %s came before %s", archive.Events[i-1].Type, event.Type)
+		}
+	}
+	if len(types) < 3 || types[0] != EventStartGame || types[len(types)-1] != EventEndGame ||
+		countEvents(archiveEvents(archive), EventNewScoreUpdate) != 1 {
+		t.Errorf("expected the start, alice's score and the end to be archived, got %v", types)
+	}
+
+	if rec, _ := getArchive(t, "../secrets"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a path, got %d", rec.Code)
+	}
+}
+
+// archiveEvents returns the archive's events as they were sent
+func archiveEvents(archive SessionArchive) []Event {
+	events := make([]Event, len(archive.Events))
+	for i, event := range archive.Events {
+		events[i] = Event{event.Type, event.Payload}
+	}
+	return events
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// FailedLoginRecord is the audit record of a failed login. It never includes the password that was tried
+type FailedLoginRecord struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	LobbyId  string    `json:"lobbyId"`
+	Username string    `json:"username"`
+	IP       string    `json:"ip"`
+	Reason   string    `json:"reason"`
+}
+
+// auditFileLock stops concurrent records from interleaving in the audit file
+var auditFileLock sync.Mutex
+
+// auditFailedLogin records a failed login, if auditing is enabled
+func auditFailedLogin(r *http.Request, lobbyId string, username string, reason string) {
+	if !config.AuditFailedLogins {
+		return
+	}
+
+	record := FailedLoginRecord{"failed_login", time.Now().UTC(), lobbyId, username, requestIP(r), reason}
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("audit: %s", data)
+
+	if config.AuditLogFile == "" {
+		return
+	}
+	auditFileLock.Lock()
+	defer auditFileLock.Unlock()
+
+	file, err := os.OpenFile(config.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("Failed to open audit log: %v", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write audit log: %v", err)
+	}
+}
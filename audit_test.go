@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// useAuditLog turns on failed login auditing for the rest of the test, returning the audit file and
+// a buffer capturing the server log
+func useAuditLog(t *testing.T, enabled bool) (string, *bytes.Buffer) {
+	previous := config
+	config.AuditFailedLogins = enabled
+	config.AuditLogFile = filepath.Join(t.TempDir(), "audit.log")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() {
+		config = previous
+		log.SetOutput(os.Stderr)
+	})
+	return config.AuditLogFile, &logs
+}
+
+func TestAuditFailedLogin(t *testing.T) {
+	auditFile, logs := useAuditLog(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	hash, _ := bcrypt.GenerateFromPassword([]byte("right-password"), bcrypt.MinCost)
+	lobby.userMapping["alice"] = User{password: string(hash)}
+
+	if rec := login(t, manager, lobby.id, "alice", "hunter2-wrong"); rec.Code != 401 {
+		t.Fatalf("expected the login to fail, got %d", rec.Code)
+	}
+
+	data, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record FailedLoginRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.LobbyId != lobby.id || record.Username != "alice" || record.IP != "192.0.2.1" || record.Time.IsZero() {
+		t.Errorf("unexpected audit record %+v", record)
+	}
+
+	for name, output := range map[string]string{"audit file": string(data), "server log": logs.String()} {
+		if strings.Contains(output, "hunter2-wrong") {
+			t.Errorf("expected the %s not to contain the password", name)
+		}
+	}
+	if !strings.Contains(logs.String(), "failed_login") {
+		t.Error("expected the failed login to be logged")
+	}
+}
+
+func TestAuditFailedLogin_Disabled(t *testing.T) {
+	auditFile, logs := useAuditLog(t, false)
+	manager := NewManager(context.Background())
+
+	login(t, manager, "no-such-lobby", "alice", "password")
+	if _, err := os.Stat(auditFile); !os.IsNotExist(err) {
+		t.Error("expected no audit file when auditing is disabled")
+	}
+	if strings.Contains(logs.String(), "failed_login") {
+		t.Error("expected nothing to be logged when auditing is disabled")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// banList temporarily bans IPs that fail to log in too often; it's safe for concurrent use
+type banList struct {
+	sync.Mutex
+	// failures are the times of each IP's recent failed logins, oldest first
+	failures map[string][]time.Time
+	// bannedUntil is when each banned IP's ban is lifted
+	bannedUntil map[string]time.Time
+}
+
+func newBanList() *banList {
+	return &banList{failures: make(map[string][]time.Time), bannedUntil: make(map[string]time.Time)}
+}
+
+// requestIP returns the IP the request came from
+func requestIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// recordFailure notes a failed login from the IP, banning it for config.LoginBanDuration once it's failed
+// config.LoginBanThreshold times within config.LoginBanWindow. It reports whether the IP was banned
+func (b *banList) recordFailure(ip string, now time.Time) bool {
+	if config.LoginBanThreshold <= 0 {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	b.prune(now)
+	recent := append(b.failures[ip], now)
+	if len(recent) < config.LoginBanThreshold {
+		b.failures[ip] = recent
+		return false
+	}
+	delete(b.failures, ip)
+	b.bannedUntil[ip] = now.Add(config.LoginBanDuration)
+	return true
+}
+
+// prune forgets failures that have fallen out of the window, and bans that have been lifted, so IPs that
+// stop failing don't stay in memory
+// @dev Requires the lock to be held
+func (b *banList) prune(now time.Time) {
+	cutoff := now.Add(-config.LoginBanWindow)
+	for ip, times := range b.failures {
+		i := 0
+		for i < len(times) && !times[i].After(cutoff) {
+			i++
+		}
+		if i == len(times) {
+			delete(b.failures, ip)
+		} else {
+			b.failures[ip] = times[i:]
+		}
+	}
+	for ip, until := range b.bannedUntil {
+		if !now.Before(until) {
+			delete(b.bannedUntil, ip)
+		}
+	}
+}
+
+// isBanned reports whether the IP is currently banned
+func (b *banList) isBanned(ip string, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	until, banned := b.bannedUntil[ip]
+	if banned && !now.Before(until) {
+		// The ban has run out
+		delete(b.bannedUntil, ip)
+		return false
+	}
+	return banned
+}
+
+// rejectBannedIPs turns away every request from IPs banned for failing to log in too often
+func (m *Manager) rejectBannedIPs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.bans.isBanned(requestIP(r), time.Now()) {
+			http.Error(w, "too many failed logins, try again later", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loginFailed audits a failed login, banning the IP it came from if it's failed too often
+func (m *Manager) loginFailed(r *http.Request, lobbyId string, username string, reason string) {
+	auditFailedLogin(r, lobbyId, username, reason)
+	ip := requestIP(r)
+	if m.bans.recordFailure(ip, time.Now()) {
+		log.Printf("Banned %s for %v after %d failed logins", ip, config.LoginBanDuration, config.LoginBanThreshold)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useLoginBans bans IPs with the given number of failed logins for the rest of the test
+func useLoginBans(t *testing.T, threshold int, duration time.Duration) {
+	previous := config
+	config.LoginBanThreshold = threshold
+	config.LoginBanWindow = time.Minute
+	config.LoginBanDuration = duration
+	t.Cleanup(func() { config = previous })
+}
+
+func TestLoginBans(t *testing.T) {
+	useLoginBans(t, 3, 300*time.Millisecond)
+	manager := NewManager(context.Background())
+	lobby := NewLobby(manager.ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	lobby.allowGuests = true
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", manager.loginHandler)
+	mux.HandleFunc("/lobby/preview", manager.lobbyPreviewHandler)
+	handler := manager.rejectBannedIPs(mux)
+	request := func(path string, lobbyId string, ip string) int {
+		body, err := json.Marshal(map[string]string{"username": "alice", "lobbyId": lobbyId})
+		if err != nil {
+			t.Fatal(err)
+		}
+		method := http.MethodPost
+		if path != "/login" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, path+"?l="+lobbyId, bytes.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// e.g. guessing at lobbies
+	for i := 0; i < 3; i++ {
+		if code := request("/login", "missing", "192.0.2.1"); code != http.StatusNotFound {
+			t.Fatalf("expected failed login %d to be a 404, got %d", i+1, code)
+		}
+	}
+	// Once banned, the IP is turned away everywhere, even with the right details
+	if code := request("/login", lobby.id, "192.0.2.1"); code != http.StatusForbidden {
+		t.Errorf("expected the banned IP's login to be a 403, got %d", code)
+	}
+	if code := request("/lobby/preview", lobby.id, "192.0.2.1"); code != http.StatusForbidden {
+		t.Errorf("expected the banned IP's other requests to be a 403, got %d", code)
+	}
+	if code := request("/login", lobby.id, "192.0.2.2"); code != http.StatusOK {
+		t.Errorf("expected other IPs to be unaffected, got %d", code)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if code := request("/login", lobby.id, "192.0.2.1"); code != http.StatusOK {
+		t.Errorf("expected the ban to have been lifted, got %d", code)
+	}
+}
+
+func TestBanList_Window(t *testing.T) {
+	useLoginBans(t, 2, time.Hour)
+	bans := newBanList()
+	start := time.Now()
+
+	// Failures further apart than the window don't add up
+	if bans.recordFailure("192.0.2.1", start) || bans.recordFailure("192.0.2.1", start.Add(2*time.Minute)) {
+		t.Error("expected failures outside the window not to get the IP banned")
+	}
+	if !bans.recordFailure("192.0.2.1", start.Add(2*time.Minute+time.Second)) {
+		t.Error("expected a second failure within the window to get the IP banned")
+	}
+	if !bans.isBanned("192.0.2.1", start.Add(time.Hour)) || bans.isBanned("192.0.2.1", start.Add(3*time.Hour)) {
+		t.Error("expected the ban to last an hour")
+	}
+
+	config.LoginBanThreshold = 0
+	if bans.recordFailure("192.0.2.2", start) || bans.recordFailure("192.0.2.2", start) {
+		t.Error("expected nobody to be banned when bans are disabled")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// BROADCAST_BATCH_SIZE is how many clients a broadcast worker delivers to at a time. Lobbies no bigger than
+// one batch are broadcast to serially, since starting workers would cost more than it saves
+const BROADCAST_BATCH_SIZE = 32
+
+// fanOut calls send for every client, spreading them across up to config.BroadcastWorkers workers. It returns
+// once every client has been sent to, so a client's events still arrive in the order they were broadcast
+func fanOut(clients []*Client, send func(client *Client)) {
+	workers := config.BroadcastWorkers
+	batches := (len(clients) + BROADCAST_BATCH_SIZE - 1) / BROADCAST_BATCH_SIZE
+	if workers <= 1 || batches <= 1 {
+		for _, client := range clients {
+			send(client)
+		}
+		return
+	}
+	if workers > batches {
+		workers = batches
+	}
+
+	// Workers take the next batch as they finish one, so a slow client only holds up the rest of its batch
+	var next int32
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				batch := int(atomic.AddInt32(&next, 1)) - 1
+				if batch >= batches {
+					return
+				}
+				end := (batch + 1) * BROADCAST_BATCH_SIZE
+				if end > len(clients) {
+					end = len(clients)
+				}
+				for _, client := range clients[batch*BROADCAST_BATCH_SIZE : end] {
+					send(client)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// useBroadcastWorkers fans broadcasts out across the given number of workers for the rest of the test
+func useBroadcastWorkers(tb testing.TB, workers int) {
+	previous := config
+	config.BroadcastWorkers = workers
+	tb.Cleanup(func() { config = previous })
+}
+
+func TestBroadcast_LargeLobby(t *testing.T) {
+	useBroadcastWorkers(t, 8)
+	lobby := newTestLobby(t, nil)
+	clients := make([]*Client, 500)
+	for i := range clients {
+		clients[i] = addTestClient(lobby, fmt.Sprintf("player%d", i))
+	}
+
+	// Fewer events than the egress buffer holds, so none are dropped
+	const broadcasts = 50
+	for i := 0; i < broadcasts; i++ {
+		lobby.broadcast(Event{Type: EventNewMessage, Payload: []byte(fmt.Sprint(i))})
+	}
+
+	for _, client := range clients {
+		events := drainEvents(client)
+		if len(events) != broadcasts {
+			t.Fatalf("expected %s to receive %d broadcasts, got %d", client.name, broadcasts, len(events))
+		}
+		for i, event := range events {
+			if string(event.Payload) != fmt.Sprint(i) {
+				t.Fatalf("expected %s's broadcast %d to arrive in order, got %s", client.name, i, event.Payload)
+			}
+		}
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			useBroadcastWorkers(b, workers)
+			ctx, cancel := context.WithCancel(context.Background())
+			b.Cleanup(cancel)
+			lobby := NewLobby(ctx, "bench", "bench-lobby")
+			clients := make([]*Client, 1000)
+			for i := range clients {
+				clients[i] = addTestClient(lobby, fmt.Sprintf("player%d", i))
+			}
+			event := Event{Type: EventNewMessage, Payload: []byte(`{"message":"hello"}`)}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				lobby.broadcast(event)
+				// Make room for the next broadcast
+				b.StopTimer()
+				for _, client := range clients {
+					<-client.egress
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Chat filter policies for messages containing banned words
+const (
+	// ChatFilterMask replaces banned words with asterisks
+	ChatFilterMask = "mask"
+	// ChatFilterReject refuses to send the message
+	ChatFilterReject = "reject"
+)
+
+// MAX_REPEATED_CHARACTERS is the longest run of one letter or punctuation mark kept in a filtered message
+const MAX_REPEATED_CHARACTERS = 3
+
+// squeezeWord lowercases the word and collapses runs of a letter into one, so stretched-out
+// spellings (e.g. `heeeck`) are matched the same as the word itself
+func squeezeWord(word string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range strings.ToLower(word) {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+// filterChatMessage collapses repeated-character spam and masks banned words (matched case-insensitively
+// as whole words), returning the filtered message and whether it contained any banned words
+func filterChatMessage(message string, bannedWords []string) (string, bool) {
+	banned := make(map[string]bool, len(bannedWords))
+	for _, word := range bannedWords {
+		if word = strings.TrimSpace(word); word != "" {
+			banned[squeezeWord(word)] = true
+		}
+	}
+
+	var b strings.Builder
+	var word []rune
+	flagged := false
+	flushWord := func() {
+		if banned[squeezeWord(string(word))] {
+			flagged = true
+			b.WriteString(strings.Repeat("*", len(word)))
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+
+	var last rune
+	run := 0
+	for _, r := range message {
+		if r == last {
+			run++
+		} else {
+			last, run = r, 1
+		}
+		// Only letters and punctuation are spam when repeated; numbers like 10000 are kept whole
+		if run > MAX_REPEATED_CHARACTERS && (unicode.IsLetter(r) || unicode.IsPunct(r)) {
+			continue
+		}
+
+		if unicode.IsLetter(r) {
+			word = append(word, r)
+			continue
+		}
+		flushWord()
+		b.WriteRune(r)
+	}
+	flushWord()
+	return b.String(), flagged
+}
+
+// chatHistory is a ring buffer of a lobby's most recent chat messages, so players joining later can catch up
+type chatHistory struct {
+	messages []NewMessageEvent
+	// next is where the next message goes once the buffer is full, overwriting the oldest
+	next int
+	size int
+}
+
+func newChatHistory(size int) *chatHistory {
+	return &chatHistory{messages: make([]NewMessageEvent, 0, size), size: size}
+}
+
+// add keeps the message, evicting the oldest if the history is full
+func (h *chatHistory) add(message NewMessageEvent) {
+	if h.size == 0 {
+		return
+	} else if len(h.messages) < h.size {
+		h.messages = append(h.messages, message)
+		return
+	}
+	h.messages[h.next] = message
+	h.next = (h.next + 1) % h.size
+}
+
+// recent returns the kept messages, oldest first
+func (h *chatHistory) recent() []NewMessageEvent {
+	recent := make([]NewMessageEvent, 0, len(h.messages))
+	recent = append(recent, h.messages[h.next:]...)
+	return append(recent, h.messages[:h.next]...)
+}
+
+// sendChatHistory sends the client the lobby's recent chat messages, if there are any
+func (client *Client) sendChatHistory() error {
+	client.lobby.RLock()
+	messages := client.lobby.chatHistory.recent()
+	client.lobby.RUnlock()
+	if len(messages) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(ChatHistoryEvent{messages})
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat history: %v", err)
+	}
+	client.egress <- Event{EventChatHistory, data}
+	return nil
+}
+
+// ChatHandler sends a user's chat message to everyone in the lobby, filtering it if the lobby has filtering on
+func ChatHandler(event Event, c *Client) error {
+	chatevent, err := decode[SendMessageEvent](event)
+	if err != nil {
+		return err
+	}
+	message := strings.TrimSpace(chatevent.Message)
+	if message == "" {
+		return fmt.Errorf("chat messages can't be empty")
+	}
+
+	c.lobby.RLock()
+	filter := c.lobby.chatFilter
+	c.lobby.RUnlock()
+	if filter {
+		filtered, flagged := filterChatMessage(message, strings.Split(config.ChatBannedWords, ","))
+		if flagged && config.ChatFilterPolicy == ChatFilterReject {
+			return c.sendError("your message wasn't sent as it contains inappropriate language")
+		}
+		message = filtered
+	}
+
+	newMessage := NewMessageEvent{c.name, message}
+	data, err := json.Marshal(newMessage)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message: %v", err)
+	}
+	c.lobby.Lock()
+	c.lobby.chatHistory.add(newMessage)
+	c.lobby.Unlock()
+	c.lobby.broadcast(Event{EventNewMessage, data})
+	return nil
+}
+
+// SetChatFilterHandler lets the owner turn chat filtering on or off for their lobby
+func SetChatFilterHandler(event Event, c *Client) error {
+	if !c.ownsLobby() {
+		return fmt.Errorf("only the owner can change the chat filter")
+	}
+	filterevent, err := decode[SetChatFilterEvent](event)
+	if err != nil {
+		return err
+	}
+	c.lobby.Lock()
+	c.lobby.chatFilter = filterevent.Enabled
+	c.lobby.Unlock()
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func sendChat(t *testing.T, c *Client, message string) error {
+	t.Helper()
+	payload, err := json.Marshal(SendMessageEvent{message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ChatHandler(Event{EventSendMessage, payload}, c)
+}
+
+// useChatFilterPolicy sets the chat filter policy for the rest of the test
+func useChatFilterPolicy(t *testing.T, policy string) {
+	previous := config
+	config.ChatFilterPolicy = policy
+	config.ChatBannedWords = "darn,heck"
+	t.Cleanup(func() { config = previous })
+}
+
+// lastChatMessage returns the last chat message queued for the client
+func lastChatMessage(t *testing.T, c *Client) (NewMessageEvent, bool) {
+	t.Helper()
+	var message NewMessageEvent
+	found := false
+	for _, e := range drainEvents(c) {
+		if e.Type == EventNewMessage {
+			if err := json.Unmarshal(e.Payload, &message); err != nil {
+				t.Fatal(err)
+			}
+			found = true
+		}
+	}
+	return message, found
+}
+
+func TestFilterChatMessage(t *testing.T) {
+	tests := []struct {
+		message  string
+		filtered string
+		flagged  bool
+	}{
+		{"nice work!", "nice work!", false},
+		{"oh DARN it", "oh **** it", true},
+		{"darning socks", "darning socks", false},
+		{"heeeeeeck", "******", true},
+		{"sooooo close!!!!!!", "sooo close!!!", false},
+		{"only 10000 points?", "only 10000 points?", false},
+	}
+	for _, test := range tests {
+		filtered, flagged := filterChatMessage(test.message, []string{"darn", " heck"})
+		if filtered != test.filtered || flagged != test.flagged {
+			t.Errorf("%q: expected (%q, %v), got (%q, %v)", test.message, test.filtered, test.flagged, filtered, flagged)
+		}
+	}
+}
+
+func TestChatHandler_MaskPolicy(t *testing.T) {
+	useChatFilterPolicy(t, ChatFilterMask)
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if err := sendChat(t, alice, "what the heck"); err != nil {
+		t.Fatal(err)
+	}
+	if message, _ := lastChatMessage(t, bob); message != (NewMessageEvent{"alice", "what the ****"}) {
+		t.Errorf("expected the message to be masked, got %+v", message)
+	}
+
+	if err := sendChat(t, alice, "good luck everyone"); err != nil {
+		t.Fatal(err)
+	}
+	if message, _ := lastChatMessage(t, bob); message.Message != "good luck everyone" {
+		t.Errorf("expected a clean message to pass unchanged, got %q", message.Message)
+	}
+}
+
+func TestChatHandler_RejectPolicy(t *testing.T) {
+	useChatFilterPolicy(t, ChatFilterReject)
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if err := sendChat(t, alice, "what the heck"); err == nil {
+		t.Error("expected the message to be rejected")
+	}
+	if _, sent := lastChatMessage(t, bob); sent {
+		t.Error("expected a rejected message not to be sent")
+	}
+	if countEvents(drainEvents(alice), EventError) != 1 {
+		t.Error("expected the sender to be told why their message was rejected")
+	}
+}
+
+func TestSetChatFilterHandler(t *testing.T) {
+	useChatFilterPolicy(t, ChatFilterReject)
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	bob := addTestClient(lobby, "bob")
+
+	payload, _ := json.Marshal(SetChatFilterEvent{false})
+	if err := SetChatFilterHandler(Event{EventSetChatFilter, payload}, bob); err == nil {
+		t.Error("expected only the owner to be able to change the filter")
+	}
+	if err := SetChatFilterHandler(Event{EventSetChatFilter, payload}, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendChat(t, bob, "what the heck"); err != nil {
+		t.Fatal(err)
+	}
+	if message, _ := lastChatMessage(t, owner); message.Message != "what the heck" {
+		t.Errorf("expected the message to be unfiltered, got %q", message.Message)
+	}
+}
+
+func TestChatHistory_Eviction(t *testing.T) {
+	history := newChatHistory(3)
+	for i := 1; i <= 7; i++ {
+		history.add(NewMessageEvent{"alice", fmt.Sprint(i)})
+		if i == 2 {
+			if recent := history.recent(); len(recent) != 2 || recent[0].Message != "1" {
+				t.Errorf("expected both messages to be kept before the history is full, got %v", recent)
+			}
+		}
+	}
+	recent := history.recent()
+	if len(recent) != 3 || recent[0].Message != "5" || recent[1].Message != "6" || recent[2].Message != "7" {
+		t.Errorf("expected only the 3 most recent messages, oldest first, got %v", recent)
+	}
+
+	disabled := newChatHistory(0)
+	disabled.add(NewMessageEvent{"alice", "hello"})
+	if recent := disabled.recent(); len(recent) != 0 {
+		t.Errorf("expected no messages to be kept, got %v", recent)
+	}
+}
+
+func TestChatHistory_SentOnJoin(t *testing.T) {
+	previous := config
+	config.ChatHistorySize = 2
+	t.Cleanup(func() { config = previous })
+
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	for _, message := range []string{"first", "second", "third"} {
+		if err := sendChat(t, alice, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bob := addTestClient(lobby, "bob")
+	bob.welcome()
+	events := drainEvents(bob)
+	if len(events) == 0 || events[0].Type != EventChatHistory {
+		t.Fatalf("expected bob to be sent the chat history first, got %v", events)
+	}
+	var history ChatHistoryEvent
+	if err := json.Unmarshal(events[0].Payload, &history); err != nil {
+		t.Fatal(err)
+	}
+	expected := []NewMessageEvent{{"alice", "second"}, {"alice", "third"}}
+	if !reflect.DeepEqual(history.Messages, expected) {
+		t.Errorf("expected the backlog to be %v, got %v", expected, history.Messages)
+	}
+
+	// Nothing is sent when there's no chat to catch up on
+	quiet := newTestLobby(t, nil)
+	carol := addTestClient(quiet, "carol")
+	carol.welcome()
+	if countEvents(drainEvents(carol), EventChatHistory) != 0 {
+		t.Error("expected no chat history for a lobby without any chat")
+	}
+}
@@ -12,6 +12,29 @@ import (
 const OWNER_MAX_MESSAGE_SIZE = 131072
 const PLAYER_MAX_MESSAGE_SIZE = 512
 
+// EGRESS_BUFFER_SIZE is how many events a client can fall behind by before broadcasts to it are dropped
+const EGRESS_BUFFER_SIZE = 64
+
+// Close codes sent when the server ends a connection, so the frontend knows why (4000-4999 are application-defined)
+const (
+	CloseGameOver       = 4000
+	CloseServerShutdown = 4002
+	CloseInactive       = 4003
+	CloseGameStarted    = 4004
+	CloseTooSlow        = 4005
+	CloseLobbyReset     = 4006
+	CloseLoginExpired   = 4007
+)
+
+// activityEvents are the events that show a client is actually playing, rather than just holding a slot
+var activityEvents = map[string]bool{
+	EventStartGameOwner: true,
+	EventGiveAnswer:     true,
+	EventSkipProblem:    true,
+	EventSendMessage:    true,
+	EventSetReady:       true,
+}
+
 // ClientList is a map used to help manage a map of clients
 type ClientList map[*Client]bool
 
@@ -26,6 +49,12 @@ type Client struct {
 	manager *Manager
 	// egress is used to avoid concurrent writes on the WebSocket
 	egress chan Event
+	// closing holds the close frame to send when the server ends the connection
+	closing chan []byte
+	// done is closed when the client is removed from its lobby, stopping writeMessages
+	done chan struct{}
+	// activity is signalled whenever the client sends one of the activityEvents
+	activity chan struct{}
 }
 
 var (
@@ -34,14 +63,104 @@ var (
 	pingInterval = (pongWait * 9) / 10
 )
 
-// NewClient is used to initialize a new Client with all required values initialized
-func NewClient(conn *websocket.Conn, manager *Manager, lobby *Lobby, otp string) *Client {
+// NewClient is used to initialize a new Client with all required values initialized, for the user the
+// connection's OTP was issued to
+func NewClient(conn *websocket.Conn, manager *Manager, lobby *Lobby, name string) *Client {
 	return &Client{
 		connection: conn,
 		manager:    manager,
 		lobby:      lobby,
-		name:       lobby.otpMapping[otp],
-		egress:     make(chan Event),
+		name:       name,
+		egress:     make(chan Event, EGRESS_BUFFER_SIZE),
+		closing:    make(chan []byte, 1),
+		done:       make(chan struct{}),
+		activity:   make(chan struct{}, 1),
+	}
+}
+
+// noteActivity records that the client has done something meaningful, resetting their inactivity timer
+func (c *Client) noteActivity() {
+	select {
+	case c.activity <- struct{}{}:
+	default:
+		// Activity is already waiting to be noticed
+	}
+}
+
+// watchInactivity disconnects the client if they go the timeout without any activity, warning them
+// beforehand. This is suppose to be ran as a goroutine; it stops once the client is removed
+func (c *Client) watchInactivity(timeout time.Duration, warning time.Duration) {
+	if warning > timeout {
+		warning = timeout
+	}
+	timer := time.NewTimer(timeout - warning)
+	defer timer.Stop()
+	warned := false
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout - warning)
+			warned = false
+		case <-timer.C:
+			if warned {
+				c.disconnect(CloseInactive, "Disconnected for inactivity")
+				return
+			}
+			data, err := json.Marshal(InactivityWarningEvent{int(warning.Seconds())})
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			c.trySend(Event{EventInactivityWarning, data})
+			timer.Reset(warning)
+			warned = true
+		}
+	}
+}
+
+// disconnect ends the client's connection, sending a close frame with the given code and reason.
+// Events already handed to the client are written first.
+func (c *Client) disconnect(code int, reason string) {
+	select {
+	case c.closing <- websocket.FormatCloseMessage(code, reason):
+	default:
+		// The client is already being disconnected
+	}
+}
+
+// trySend queues the event for the client without blocking, returning false if the event was dropped. If the
+// client is too far behind, the server's egress overflow policy decides what gives
+func (c *Client) trySend(event Event) bool {
+	for {
+		select {
+		case c.egress <- event:
+			return true
+		default:
+		}
+
+		switch config.EgressOverflowPolicy {
+		case EgressDropOldest:
+			// Make room by evicting the oldest queued event, then try again
+			select {
+			case dropped := <-c.egress:
+				log.Printf("Client %s is too far behind, dropping queued %s event", c.name, dropped.Type)
+			default:
+				// The writer has caught up in the meantime
+			}
+		case EgressDropClient:
+			log.Printf("Client %s is too far behind, disconnecting them", c.name)
+			c.disconnect(CloseTooSlow, "Disconnected for falling too far behind")
+			return false
+		default:
+			log.Printf("Client %s is too far behind, dropping %s event", c.name, event.Type)
+			return false
+		}
 	}
 }
 
@@ -54,14 +173,6 @@ func (c *Client) readMessages() {
 		c.lobby.removeClient(c)
 	}()
 
-	var maxMessageSize int64 = PLAYER_MAX_MESSAGE_SIZE
-	if *c.lobby.owner == c.name {
-		maxMessageSize = OWNER_MAX_MESSAGE_SIZE
-	}
-
-	// Set max size of messages in bytes
-	c.connection.SetReadLimit(maxMessageSize)
-
 	// Configure wait time for pong response, use `current time + pongWait`
 	// This has to be done here to set the first initial timer
 	if err := c.connection.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
@@ -74,6 +185,13 @@ func (c *Client) readMessages() {
 
 	// Loop Forever
 	for {
+		// Set max size of messages in bytes; this is checked each time as ownership can change
+		var maxMessageSize int64 = PLAYER_MAX_MESSAGE_SIZE
+		if c.ownsLobby() {
+			maxMessageSize = OWNER_MAX_MESSAGE_SIZE
+		}
+		c.connection.SetReadLimit(maxMessageSize)
+
 		// ReadMessage is used to read the next message in queue in the connection
 		_, payload, err := c.connection.ReadMessage()
 		if err != nil {
@@ -82,6 +200,9 @@ func (c *Client) readMessages() {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error reading message: %v", err)
 			}
+			if category, ok := readErrorCategory(err); ok {
+				websocketErrors.inc(category)
+			}
 			break // Break the loop to close connection & clean-up
 		}
 		// Marshal incoming data into a event struct
@@ -119,6 +240,7 @@ func (c *Client) writeMessages() {
 			// Ok will be false if the egress channel is closed
 			if !ok {
 				// Manager has closed this connection channel, so communicate that to frontend
+				c.connection.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
 				if err := c.connection.WriteMessage(websocket.CloseMessage, nil); err != nil {
 					// Log that the connection is closed and the reason
 					log.Println("connection closed: ", err)
@@ -127,22 +249,55 @@ func (c *Client) writeMessages() {
 				return
 			}
 
-			data, err := json.Marshal(message)
-			if err != nil {
-				log.Println(err)
-				return // TODO: do we need to close the connection?
+			if !c.writeEvent(message) {
+				return // return to break this goroutine triggering cleanup
 			}
-			// Write a regular text message to the connection
-			if err := c.connection.WriteMessage(websocket.TextMessage, data); err != nil {
-				log.Println(err)
+		case closeMessage := <-c.closing:
+			// Flush anything still queued so it arrives before the close frame
+			for flushed := false; !flushed; {
+				select {
+				case message := <-c.egress:
+					c.writeEvent(message)
+				default:
+					flushed = true
+				}
+			}
+			c.connection.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+			if err := c.connection.WriteMessage(websocket.CloseMessage, closeMessage); err != nil {
+				log.Println("connection closed: ", err)
 			}
+			// Return to close the goroutine, which removes the client
+			return
+		case <-c.done:
+			// The client has been removed (and its connection closed), e.g. because readMessages stopped
+			return
 		case <-ticker.C:
 			// Send the Ping
+			c.connection.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
 			if err := c.connection.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
 				log.Println("writemsg: ", err)
+				websocketErrors.inc(WebsocketWriteError)
 				return // return to break this goroutine triggering cleanup
 			}
 		}
 
 	}
 }
+
+// writeEvent writes a single event to the connection, returning false if it couldn't be written
+// (including when the peer stops reading and the write times out)
+func (c *Client) writeEvent(message Event) bool {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	// Write a regular text message to the connection
+	c.connection.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	if err := c.connection.WriteMessage(websocket.TextMessage, data); err != nil {
+		log.Println(err)
+		websocketErrors.inc(WebsocketWriteError)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves websocket connections for the manager's lobbies. Once the test's done, it waits for
+// the clients' goroutines and stops the lobbies' timers, so none of them outlive the config the test set
+func newTestServer(t *testing.T, manager *Manager) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(manager.serveWS))
+	t.Cleanup(func() {
+		server.Close()
+		manager.shutdown(time.Second)
+	})
+	return server
+}
+
+// connectTestClient logs a user into the lobby (skipping the password check) and opens a websocket for them
+func connectTestClient(t *testing.T, server *httptest.Server, lobby *Lobby, name string) *websocket.Conn {
+	t.Helper()
+	if _, ok := lobby.userMapping[name]; !ok {
+		lobby.userMapping[name] = User{}
+	}
+	if lobby.owner == nil {
+		owner := name
+		lobby.owner = &owner
+	}
+	otp := lobby.issueOTP(name)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?otp=" + otp.Key + "&l=" + lobby.id
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// findClient returns the server-side client for the given user
+func findClient(t *testing.T, lobby *Lobby, name string) *Client {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		lobby.RLock()
+		for c := range lobby.clients {
+			if c.name == name {
+				lobby.RUnlock()
+				return c
+			}
+		}
+		lobby.RUnlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("client %s never connected", name)
+	return nil
+}
+
+// readUntilClose reads (and discards) events until the connection is closed, returning the close error
+func readUntilClose(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("expected a close frame, got %v", err)
+		}
+		return closeErr
+	}
+}
+
+func TestServeWS_ExpiredLoginSendsCloseReason(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	server := newTestServer(t, testManagers[lobby])
+	lobby.userMapping["alice"] = User{}
+	otp := lobby.issueOTP("alice")
+	// The OTP runs out before it's used
+	lobby.otps.revoke(otp.Key)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?otp=" + otp.Key + "&l=" + lobby.id
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if closeErr := readUntilClose(t, conn); closeErr.Code != CloseLoginExpired {
+		t.Errorf("expected close %d, got %d (%s)", CloseLoginExpired, closeErr.Code, closeErr.Text)
+	}
+	if lobby.isPresent("alice") {
+		t.Error("expected alice not to be admitted")
+	}
+}
+
+func TestManagerShutdown_SendsCloseReason(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	conn := connectTestClient(t, server, lobby, "owner")
+	findClient(t, lobby, "owner")
+
+	go manager.shutdown(time.Second)
+
+	closeErr := readUntilClose(t, conn)
+	if closeErr.Code != CloseServerShutdown {
+		t.Errorf("expected close %d, got %d (%s)", CloseServerShutdown, closeErr.Code, closeErr.Text)
+	}
+}
+
+func TestWriteMessages_WriteTimeoutRemovesClient(t *testing.T) {
+	previous := config.WriteTimeout
+	config.WriteTimeout = 100 * time.Millisecond
+	defer func() { config.WriteTimeout = previous }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	// A peer that never reads, with a small receive buffer so writes to it block quickly
+	otp := lobby.issueOTP("stalled")
+	lobby.userMapping["stalled"] = User{}
+	owner := "stalled"
+	lobby.owner = &owner
+	dialer := websocket.Dialer{
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			if err == nil {
+				conn.(*net.TCPConn).SetReadBuffer(1024)
+			}
+			return conn, err
+		},
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?otp=" + otp.Key + "&l=" + lobby.id
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := findClient(t, lobby, "stalled")
+
+	// Keep sending large events until the writer gives up on the client
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		filler := Event{"filler", json.RawMessage(`"` + strings.Repeat("x", 64*1024) + `"`)}
+		for {
+			select {
+			case client.egress <- filler:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		lobby.RLock()
+		_, connected := lobby.clients[client]
+		lobby.RUnlock()
+		if !connected {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Error("expected the stalled client to be removed after the write deadline")
+}
+
+// newServerConn returns the server side of a new websocket connection, for clients that are added to lobbies directly
+func newServerConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+	serverConn, _ := newConnPair(t)
+	return serverConn
+}
+
+// newConnPair returns both sides of a new websocket connection
+func newConnPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	clientConn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { clientConn.Close() })
+	return <-conns, clientConn
+}
+
+func TestLobbyBroadcast_ConcurrentJoinAndLeave(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	clients := make([]*Client, 20)
+	for i := range clients {
+		clients[i] = &Client{
+			connection: newServerConn(t),
+			name:       fmt.Sprintf("player%d", i),
+			lobby:      lobby,
+			egress:     make(chan Event, EGRESS_BUFFER_SIZE),
+			closing:    make(chan []byte, 1),
+			done:       make(chan struct{}),
+		}
+	}
+
+	// Players come and go while events are broadcast; run with -race to check for unsafe access
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			lobby.addClient(c)
+			lobby.broadcast(Event{EventNewScoreUpdate, nil})
+			lobby.removeClient(c)
+		}(c)
+	}
+	for i := 0; i < 100; i++ {
+		lobby.broadcast(Event{EventNewScoreUpdate, nil})
+	}
+	wg.Wait()
+
+	// A client that isn't reading can't hold up broadcasts to everyone else
+	lobby.addClient(clients[0])
+	for i := 0; i < 2*EGRESS_BUFFER_SIZE; i++ {
+		lobby.broadcast(Event{EventNewScoreUpdate, nil})
+	}
+	if len(clients[0].egress) != EGRESS_BUFFER_SIZE {
+		t.Errorf("expected the slow client's buffer to be full, got %d events", len(clients[0].egress))
+	}
+}
+
+func TestRemoveClient_StopsGoroutines(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	serverConn, _ := newConnPair(t)
+	client := &Client{
+		connection: serverConn,
+		name:       "alice",
+		lobby:      lobby,
+		manager:    testManagers[lobby],
+		egress:     make(chan Event, EGRESS_BUFFER_SIZE),
+		closing:    make(chan []byte, 1),
+		done:       make(chan struct{}),
+	}
+	lobby.userMapping["alice"] = User{}
+	lobby.addClient(client)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		client.readMessages()
+	}()
+	go func() {
+		defer wg.Done()
+		client.writeMessages()
+	}()
+
+	lobby.removeClient(client)
+
+	// Both should stop well before the writer's next ping would notice the closed connection
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected both of the client's goroutines to stop once it was removed")
+	}
+}
+
+// useInactivityTimeout shortens the inactivity timeout for the duration of the test
+func useInactivityTimeout(t *testing.T, timeout time.Duration, warning time.Duration) {
+	t.Helper()
+	previous := config
+	config.InactivityTimeout = timeout
+	config.InactivityWarning = warning
+	t.Cleanup(func() { config = previous })
+}
+
+func TestWatchInactivity_WarnsThenDisconnects(t *testing.T) {
+	useInactivityTimeout(t, 200*time.Millisecond, 100*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	conn := connectTestClient(t, server, lobby, "idle")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	warned := false
+	for {
+		var event Event
+		err := conn.ReadJSON(&event)
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			if closeErr.Code != CloseInactive {
+				t.Errorf("expected close %d, got %d (%s)", CloseInactive, closeErr.Code, closeErr.Text)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected a close frame, got %v", err)
+		}
+		if event.Type == EventInactivityWarning {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Error("expected a warning before the inactivity disconnect")
+	}
+}
+
+func TestWatchInactivity_ActivityResetsTimer(t *testing.T) {
+	useInactivityTimeout(t, 200*time.Millisecond, 100*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	conn := connectTestClient(t, server, lobby, "active")
+	findClient(t, lobby, "active")
+	go func() {
+		for i := 0; i < 10; i++ {
+			payload, _ := json.Marshal(SetReadyEvent{i%2 == 0})
+			if err := conn.WriteJSON(Event{EventSetReady, payload}); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(400 * time.Millisecond))
+	for {
+		var event Event
+		err := conn.ReadJSON(&event)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected the active client to stay connected, got %v", err)
+		}
+		if event.Type == EventInactivityWarning {
+			t.Fatal("expected no inactivity warning for an active client")
+		}
+	}
+}
+
+func TestTrySend_OverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		queued []string
+		closed bool
+	}{
+		{EgressDropNewest, []string{"first", "second"}, false},
+		{EgressDropOldest, []string{"second", "third"}, false},
+		{EgressDropClient, []string{"first", "second"}, true},
+	}
+	for _, test := range tests {
+		previous := config
+		config.EgressOverflowPolicy = test.policy
+		// Nothing consumes the client's events, as if its writer had stalled
+		c := &Client{
+			name:    "slow",
+			egress:  make(chan Event, 2),
+			closing: make(chan []byte, 1),
+		}
+		sent := []bool{c.trySend(Event{Type: "first"}), c.trySend(Event{Type: "second"}), c.trySend(Event{Type: "third"})}
+		config = previous
+
+		if !sent[0] || !sent[1] || sent[2] != (test.policy == EgressDropOldest) {
+			t.Errorf("%s: unexpected results from sending %v", test.policy, sent)
+		}
+		queued := make([]string, 0)
+		for len(c.egress) > 0 {
+			queued = append(queued, (<-c.egress).Type)
+		}
+		if !reflect.DeepEqual(queued, test.queued) {
+			t.Errorf("%s: expected %v to be queued, got %v", test.policy, test.queued, queued)
+		}
+		if closed := len(c.closing) > 0; closed != test.closed {
+			t.Errorf("%s: expected the client to be disconnected: %v, but it was: %v", test.policy, test.closed, closed)
+		}
+	}
+}
+
+// waitUntilDisconnected waits for the server to notice the user's client has gone
+func waitUntilDisconnected(t *testing.T, lobby *Lobby, name string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for lobby.isConnected(name) {
+		if time.Now().After(deadline) {
+			t.Fatalf("client %s never disconnected", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOwnerReconnect_KeepsOwnership(t *testing.T) {
+	previous := config
+	config.OwnerReconnectGrace = 300 * time.Millisecond
+	t.Cleanup(func() { config = previous })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	ownerConn := connectTestClient(t, server, lobby, "owner")
+	findClient(t, lobby, "owner")
+	connectTestClient(t, server, lobby, "bob")
+	findClient(t, lobby, "bob")
+
+	// The owner refreshes the page, coming back within the grace window
+	ownerConn.Close()
+	waitUntilDisconnected(t, lobby, "owner")
+	ownerConn = connectTestClient(t, server, lobby, "owner")
+	findClient(t, lobby, "owner")
+	time.Sleep(2 * config.OwnerReconnectGrace)
+	if !lobby.isOwner("owner") {
+		t.Fatalf("expected the owner to keep the lobby after reconnecting, owner is %s", *lobby.owner)
+	}
+
+	// This time they don't come back, so bob takes over
+	ownerConn.Close()
+	waitUntilDisconnected(t, lobby, "owner")
+	time.Sleep(2 * config.OwnerReconnectGrace)
+	lobby.RLock()
+	defer lobby.RUnlock()
+	if !lobby.isOwner("bob") {
+		t.Errorf("expected bob to own the lobby once the owner's grace ran out, owner is %s", *lobby.owner)
+	}
+}
+
+// leaveEvents returns the names the client was told have left, by remove_member events and roster diffs
+func leaveEvents(t *testing.T, c *Client) []string {
+	t.Helper()
+	left := make([]string, 0)
+	for _, event := range drainEvents(c) {
+		switch event.Type {
+		case EventRemoveMember:
+			var removed RemoveMemberEvent
+			if err := json.Unmarshal(event.Payload, &removed); err != nil {
+				t.Fatal(err)
+			}
+			left = append(left, removed.Name)
+		case EventRosterDiff:
+			var diff RosterDiffEvent
+			if err := json.Unmarshal(event.Payload, &diff); err != nil {
+				t.Fatal(err)
+			}
+			if diff.Change == RosterLeave {
+				left = append(left, diff.Name)
+			}
+		}
+	}
+	return left
+}
+
+func TestDisconnectGrace(t *testing.T) {
+	previous := config
+	config.DisconnectGrace = 300 * time.Millisecond
+	t.Cleanup(func() { config = previous })
+
+	lobby := newTestLobby(t, nil)
+	lobby.maxPlayers = 2
+	alice := addTestClient(lobby, "alice")
+	server := newTestServer(t, testManagers[lobby])
+	bobConn := connectTestClient(t, server, lobby, "bob")
+	findClient(t, lobby, "bob")
+
+	// Bob's connection blips, but they're back within the grace period: their slot is kept, and nobody's told
+	bobConn.Close()
+	waitUntilDisconnected(t, lobby, "bob")
+	if players := lobby.playerCount(); players != 2 {
+		t.Errorf("expected bob's slot to be kept while they're gone, got %d players", players)
+	}
+	bobConn = connectTestClient(t, server, lobby, "bob")
+	findClient(t, lobby, "bob")
+	time.Sleep(2 * config.DisconnectGrace)
+	if left := leaveEvents(t, alice); len(left) != 0 {
+		t.Errorf("expected nobody to be told bob left, got %v", left)
+	}
+
+	// This time they don't come back
+	bobConn.Close()
+	waitUntilDisconnected(t, lobby, "bob")
+	time.Sleep(2 * config.DisconnectGrace)
+	if left := leaveEvents(t, alice); !reflect.DeepEqual(left, []string{"bob", "bob"}) {
+		t.Errorf("expected everyone to be told bob left once their grace ran out, got %v", left)
+	}
+	if players := lobby.playerCount(); players != 1 {
+		t.Errorf("expected bob's slot to be freed, got %d players", players)
+	}
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server-wide settings, which can be overridden by command-line flags
+type Config struct {
+	// WriteTimeout is how long a write to a client may block before the client is dropped
+	WriteTimeout time.Duration
+	// ChatFilterPolicy is what happens to chat messages containing banned words: ChatFilterMask or ChatFilterReject
+	ChatFilterPolicy string
+	// ChatBannedWords is a comma-separated list of words the chat filter looks for
+	ChatBannedWords string
+	// AdminToken grants access to the admin endpoints; if it's empty they're disabled
+	AdminToken string
+	// AuditFailedLogins records every failed login, for security review
+	AuditFailedLogins bool
+	// AuditLogFile is where audit records are appended, as JSON lines, in addition to the server log (optional)
+	AuditLogFile string
+	// MaxAnswerLength is the longest answer (in bytes) that will be judged
+	MaxAnswerLength int
+	// SnapshotsDirectory is where lobbies are saved so games survive a restart; snapshots are disabled if it's empty
+	// (the default). Snapshots hold players' and lobbies' password hashes, so the directory should be kept private
+	SnapshotsDirectory string
+	// SnapshotInterval is how often every lobby is saved, on top of saves on key events (0 = only on key events)
+	SnapshotInterval time.Duration
+	// MaxGameDuration is a hard ceiling on how long any game runs, whatever its time limit (0 = no ceiling)
+	MaxGameDuration time.Duration
+	// InactivityTimeout disconnects clients that go this long without playing, chatting or readying up,
+	// freeing their slot (0 = never)
+	InactivityTimeout time.Duration
+	// InactivityWarning is how long before an inactivity disconnect the client is warned
+	InactivityWarning time.Duration
+	// LeaderboardFile is where players' scores are accumulated across games; the leaderboard is disabled if it's empty
+	LeaderboardFile string
+	// ResultRetention is how long finished games' results are kept before they're deleted (0 = forever)
+	ResultRetention time.Duration
+	// MaxOTPsPerUser is how many unused OTPs a user can hold at once; logging in again revokes their oldest
+	MaxOTPsPerUser int
+	// EgressOverflowPolicy is what happens when a client falls too far behind to queue another event:
+	// EgressDropNewest, EgressDropOldest or EgressDropClient
+	EgressOverflowPolicy string
+	// BroadcastWorkers is how many clients in a lobby can be sent a broadcast at once (1 sends to them in turn)
+	BroadcastWorkers int
+	// ChatHistorySize is how many of a lobby's most recent chat messages are kept to show players as they join
+	// (0 keeps none)
+	ChatHistorySize int
+	// OwnerReconnectGrace is how long an owner can be disconnected (e.g. refreshing the page) before the lobby
+	// is handed to another player (0 = the owner keeps it however long they're gone)
+	OwnerReconnectGrace time.Duration
+	// MaxRequestBodyBytes is the largest request body any HTTP endpoint will read
+	MaxRequestBodyBytes int64
+	// MaxCustomProblems is the most problems a custom problem set can have, and MaxCustomProblemsBytes the
+	// largest it can be once serialized
+	MaxCustomProblems      int
+	MaxCustomProblemsBytes int
+	// LoginBanThreshold is how many failed logins within LoginBanWindow get an IP banned for LoginBanDuration
+	// (0 never bans)
+	LoginBanThreshold int
+	LoginBanWindow    time.Duration
+	LoginBanDuration  time.Duration
+	// RenderHookURL is an HTTP endpoint that renders LaTeX to an image, for previews of players' answers (empty
+	// leaves previews out). Calls give up after RenderHookTimeout
+	RenderHookURL     string
+	RenderHookTimeout time.Duration
+	// DisconnectGrace is how long a player who loses their connection has to come back before everyone's told
+	// they left and their slot is freed
+	DisconnectGrace time.Duration
+	// StartAckTimeout is the longest a synchronized start waits for players to acknowledge it before the game
+	// starts without them
+	StartAckTimeout time.Duration
+	// ListenAddr is the address the server listens on
+	ListenAddr string
+	// ProblemsFile is where the default problem set is loaded from
+	ProblemsFile string
+	// PracticeProblemsFile is where the problems players practice on before a game are loaded from. It's kept
+	// apart from the games' problems, so practice can't give them away (empty disables practice)
+	PracticeProblemsFile string
+	// LogsDirectory is where finished games' results are saved. Everything in it is served under /logs/
+	LogsDirectory string
+	// SessionsDirectory is where finished games' event logs are saved, for admins to archive. It mustn't be inside
+	// LogsDirectory, or they'd be served to anyone along with the results
+	SessionsDirectory string
+	// AllowedOrigins is a comma-separated list of the other origins (e.g. https://example.com) whose pages can
+	// open websockets. Pages served by this server can always open them
+	AllowedOrigins string
+	// AllowAllOrigins lets pages from any origin open websockets, e.g. for a frontend served separately in
+	// development
+	AllowAllOrigins bool
+}
+
+// Values for Config.EgressOverflowPolicy
+const (
+	// EgressDropNewest drops the event that doesn't fit
+	EgressDropNewest = "drop-newest"
+	// EgressDropOldest evicts the oldest queued event to make room, keeping the client current
+	EgressDropOldest = "drop-oldest"
+	// EgressDropClient disconnects the client
+	EgressDropClient = "drop-client"
+)
+
+// DefaultConfig returns the settings used when no flags are given
+func DefaultConfig() Config {
+	return Config{
+		WriteTimeout:           10 * time.Second,
+		ChatFilterPolicy:       ChatFilterMask,
+		ChatBannedWords:        "damn,crap,shit,fuck,bitch,bastard",
+		AdminToken:             os.Getenv("FORKTEXNIQUE_ADMIN_TOKEN"),
+		AuditFailedLogins:      true,
+		AuditLogFile:           "",
+		MaxAnswerLength:        MAX_ANSWER_LENGTH,
+		SnapshotsDirectory:     "",
+		SnapshotInterval:       10 * time.Second,
+		MaxGameDuration:        3 * time.Hour,
+		InactivityTimeout:      0,
+		InactivityWarning:      time.Minute,
+		LeaderboardFile:        "",
+		ResultRetention:        0,
+		MaxOTPsPerUser:         5,
+		EgressOverflowPolicy:   EgressDropNewest,
+		BroadcastWorkers:       8,
+		ChatHistorySize:        50,
+		OwnerReconnectGrace:    0,
+		MaxRequestBodyBytes:    1 << 20,
+		MaxCustomProblems:      500,
+		MaxCustomProblemsBytes: 128 << 10,
+		LoginBanThreshold:      0,
+		LoginBanWindow:         10 * time.Minute,
+		LoginBanDuration:       15 * time.Minute,
+		RenderHookURL:          "",
+		RenderHookTimeout:      2 * time.Second,
+		DisconnectGrace:        5 * time.Second,
+		StartAckTimeout:        10 * time.Second,
+		ListenAddr:             ":8080",
+		ProblemsFile:           "problems.json",
+		PracticeProblemsFile:   "practice.json",
+		LogsDirectory:          filepath.Join(".", "logs"),
+		SessionsDirectory:      filepath.Join(".", "sessions"),
+	}
+}
+
+// config is the active configuration
+var config = DefaultConfig()
+
+// LoadConfig builds a Config from the defaults and the given command-line arguments
+func LoadConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+
+	flags := flag.NewFlagSet("forktexnique", flag.ContinueOnError)
+	flags.DurationVar(&cfg.WriteTimeout, "write-timeout", cfg.WriteTimeout, "how long a write to a client may block before it's disconnected")
+	flags.StringVar(&cfg.ChatFilterPolicy, "chat-filter-policy", cfg.ChatFilterPolicy, "what to do with chat messages containing banned words (mask or reject)")
+	flags.StringVar(&cfg.ChatBannedWords, "chat-banned-words", cfg.ChatBannedWords, "comma-separated list of words to filter from chat")
+	flags.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "token granting access to the admin endpoints (defaults to $FORKTEXNIQUE_ADMIN_TOKEN)")
+	flags.BoolVar(&cfg.AuditFailedLogins, "audit-failed-logins", cfg.AuditFailedLogins, "record failed logins for security review")
+	flags.StringVar(&cfg.AuditLogFile, "audit-log-file", cfg.AuditLogFile, "file to append audit records to (optional)")
+	flags.IntVar(&cfg.MaxAnswerLength, "max-answer-length", cfg.MaxAnswerLength, "longest answer (in bytes) that will be judged")
+	flags.StringVar(&cfg.SnapshotsDirectory, "snapshots-dir", cfg.SnapshotsDirectory, "where lobbies are saved so games survive a restart (empty, the default, disables snapshots; they include password hashes)")
+	flags.DurationVar(&cfg.SnapshotInterval, "snapshot-interval", cfg.SnapshotInterval, "how often every lobby is saved (0 saves only on key events)")
+	flags.DurationVar(&cfg.InactivityTimeout, "inactivity-timeout", cfg.InactivityTimeout, "how long a client can go without playing before it's disconnected (0 never disconnects)")
+	flags.DurationVar(&cfg.InactivityWarning, "inactivity-warning", cfg.InactivityWarning, "how long before an inactivity disconnect the client is warned")
+	flags.DurationVar(&cfg.MaxGameDuration, "max-game-duration", cfg.MaxGameDuration, "longest any game may run, whatever its time limit (0 for no ceiling)")
+	flags.DurationVar(&cfg.ResultRetention, "result-retention", cfg.ResultRetention, "how long finished games' results are kept (0 keeps them forever)")
+	flags.IntVar(&cfg.MaxOTPsPerUser, "max-otps-per-user", cfg.MaxOTPsPerUser, "how many unused OTPs a user can hold at once")
+	flags.StringVar(&cfg.EgressOverflowPolicy, "egress-overflow-policy", cfg.EgressOverflowPolicy, "what to do when a client falls too far behind (drop-newest, drop-oldest or drop-client)")
+	flags.IntVar(&cfg.BroadcastWorkers, "broadcast-workers", cfg.BroadcastWorkers, "how many clients in a lobby can be sent a broadcast at once")
+	flags.IntVar(&cfg.ChatHistorySize, "chat-history-size", cfg.ChatHistorySize, "how many recent chat messages are shown to players as they join (0 shows none)")
+	flags.DurationVar(&cfg.OwnerReconnectGrace, "owner-reconnect-grace", cfg.OwnerReconnectGrace, "how long an owner can be disconnected before the lobby is handed to another player (0 never hands it over)")
+	flags.Int64Var(&cfg.MaxRequestBodyBytes, "max-request-body-bytes", cfg.MaxRequestBodyBytes, "largest request body (in bytes) any HTTP endpoint will read")
+	flags.IntVar(&cfg.MaxCustomProblems, "max-custom-problems", cfg.MaxCustomProblems, "most problems a custom problem set can have")
+	flags.IntVar(&cfg.MaxCustomProblemsBytes, "max-custom-problems-bytes", cfg.MaxCustomProblemsBytes, "largest (serialized) size in bytes of a custom problem set")
+	flags.IntVar(&cfg.LoginBanThreshold, "login-ban-threshold", cfg.LoginBanThreshold, "failed logins within the ban window that get an IP banned (0 never bans)")
+	flags.DurationVar(&cfg.LoginBanWindow, "login-ban-window", cfg.LoginBanWindow, "window failed logins are counted over")
+	flags.DurationVar(&cfg.LoginBanDuration, "login-ban-duration", cfg.LoginBanDuration, "how long an IP is banned for after too many failed logins")
+	flags.StringVar(&cfg.RenderHookURL, "render-hook-url", cfg.RenderHookURL, "HTTP endpoint rendering LaTeX to an image for answer previews (empty disables previews)")
+	flags.DurationVar(&cfg.RenderHookTimeout, "render-hook-timeout", cfg.RenderHookTimeout, "how long to wait for the render hook")
+	flags.DurationVar(&cfg.DisconnectGrace, "disconnect-grace", cfg.DisconnectGrace, "how long a disconnected player has to come back before they're treated as having left (0 treats them as leaving straight away)")
+	flags.DurationVar(&cfg.StartAckTimeout, "start-ack-timeout", cfg.StartAckTimeout, "longest a synchronized start waits for every player to be ready")
+	flags.StringVar(&cfg.ListenAddr, "listen-addr", cfg.ListenAddr, "address the server listens on")
+	flags.StringVar(&cfg.ProblemsFile, "problems-file", cfg.ProblemsFile, "file the default problem set is loaded from")
+	flags.StringVar(&cfg.PracticeProblemsFile, "practice-problems-file", cfg.PracticeProblemsFile, "file the problems players practice on before a game are loaded from (empty disables practice)")
+	flags.StringVar(&cfg.LogsDirectory, "logs-dir", cfg.LogsDirectory, "directory finished games' results are saved in")
+	flags.StringVar(&cfg.SessionsDirectory, "sessions-dir", cfg.SessionsDirectory, "directory finished games' event logs are saved in (not served, unlike -logs-dir)")
+	flags.StringVar(&cfg.AllowedOrigins, "allowed-origins", cfg.AllowedOrigins, "comma-separated origins, besides this server's own, whose pages can open websockets")
+	flags.BoolVar(&cfg.AllowAllOrigins, "allow-all-origins", cfg.AllowAllOrigins, "let pages from any origin open websockets (for development only)")
+	flags.StringVar(&cfg.LeaderboardFile, "leaderboard-file", cfg.LeaderboardFile, "file to accumulate players' scores across games in (empty disables the leaderboard)")
+
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// ConfigError lists everything wrong with a configuration, so it can all be fixed at once
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the configuration is usable before the server starts: its settings are in bounds, it can
+// listen on its address, and its files and directories are there. Everything wrong is reported together
+func (c Config) Validate() error {
+	var problems []string
+	if c.ChatFilterPolicy != ChatFilterMask && c.ChatFilterPolicy != ChatFilterReject {
+		problems = append(problems, fmt.Sprintf("unknown chat filter policy %q", c.ChatFilterPolicy))
+	}
+	if c.WriteTimeout <= 0 {
+		problems = append(problems, "write timeout must be positive")
+	}
+	if c.MaxAnswerLength <= 0 {
+		problems = append(problems, "max answer length must be positive")
+	}
+	if c.MaxGameDuration < 0 {
+		problems = append(problems, "max game duration can't be negative")
+	}
+	switch c.EgressOverflowPolicy {
+	case EgressDropNewest, EgressDropOldest, EgressDropClient:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown egress overflow policy %q", c.EgressOverflowPolicy))
+	}
+	if c.BroadcastWorkers <= 0 {
+		problems = append(problems, "broadcast workers must be positive")
+	}
+	if c.MaxRequestBodyBytes <= 0 {
+		problems = append(problems, "max request body bytes must be positive")
+	}
+	if c.MaxCustomProblems <= 0 || c.MaxCustomProblemsBytes <= 0 {
+		problems = append(problems, "max custom problems (and bytes) must be positive")
+	}
+	if c.LoginBanThreshold < 0 {
+		problems = append(problems, "login ban threshold can't be negative")
+	}
+	if c.LoginBanWindow <= 0 || c.LoginBanDuration <= 0 {
+		problems = append(problems, "login ban window and duration must be positive")
+	}
+	if c.RenderHookURL != "" {
+		if hook, err := url.Parse(c.RenderHookURL); err != nil || (hook.Scheme != "http" && hook.Scheme != "https") || hook.Host == "" {
+			problems = append(problems, fmt.Sprintf("render hook URL %q isn't an http(s) URL", c.RenderHookURL))
+		}
+	}
+	if c.RenderHookTimeout <= 0 {
+		problems = append(problems, "render hook timeout must be positive")
+	}
+	if c.DisconnectGrace < 0 {
+		problems = append(problems, "disconnect grace can't be negative")
+	}
+	if c.StartAckTimeout <= 0 {
+		problems = append(problems, "start ack timeout must be positive")
+	}
+	if c.OwnerReconnectGrace < 0 {
+		problems = append(problems, "owner reconnect grace can't be negative")
+	}
+	if c.ChatHistorySize < 0 {
+		problems = append(problems, "chat history size can't be negative")
+	}
+	if c.MaxOTPsPerUser <= 0 {
+		problems = append(problems, "max OTPs per user must be positive")
+	}
+	if c.SnapshotInterval < 0 {
+		problems = append(problems, "snapshot interval can't be negative")
+	}
+	if c.ResultRetention < 0 {
+		problems = append(problems, "result retention can't be negative")
+	}
+	if c.InactivityTimeout < 0 || c.InactivityWarning < 0 {
+		problems = append(problems, "inactivity timeout and warning can't be negative")
+	}
+	for _, origin := range c.allowedOrigins() {
+		if parsed, err := url.Parse(origin); err != nil || parsed.Scheme == "" || parsed.Host == "" || strings.TrimSuffix(parsed.Path, "/") != "" {
+			problems = append(problems, fmt.Sprintf("allowed origin %q isn't a scheme://host origin", origin))
+		}
+	}
+	if _, port, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		problems = append(problems, fmt.Sprintf("listen address %q isn't a host:port", c.ListenAddr))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		problems = append(problems, fmt.Sprintf("listen address %q doesn't have a valid port", c.ListenAddr))
+	}
+	if err := checkFile(c.ProblemsFile); err != nil {
+		problems = append(problems, fmt.Sprintf("can't read the problems file: %v", err))
+	}
+	if c.PracticeProblemsFile != "" {
+		if err := checkFile(c.PracticeProblemsFile); err != nil {
+			problems = append(problems, fmt.Sprintf("can't read the practice problems file: %v", err))
+		}
+	}
+	if err := checkWritableDirectory(c.LogsDirectory); err != nil {
+		problems = append(problems, fmt.Sprintf("can't save results to the logs directory: %v", err))
+	}
+	if err := checkWritableDirectory(c.SessionsDirectory); err != nil {
+		problems = append(problems, fmt.Sprintf("can't save sessions to the sessions directory: %v", err))
+	} else if within(c.SessionsDirectory, c.LogsDirectory) {
+		problems = append(problems, "the sessions directory can't be inside the logs directory, which is served publicly")
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{problems}
+	}
+	return nil
+}
+
+// allowedOrigins returns the configured origins that can open websockets, besides the server's own
+func (c Config) allowedOrigins() []string {
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(c.AllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// checkFile checks the path is a file that exists
+func checkFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	} else if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	return nil
+}
+
+// checkWritableDirectory checks files can be created in the directory, or (if it doesn't exist yet) that it can
+// be created
+func checkWritableDirectory(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if errors.Is(err, os.ErrNotExist) && filepath.Dir(dir) != dir {
+			// It's created when it's first needed, which needs its parent to be writable
+			dir = filepath.Dir(dir)
+			continue
+		} else if err != nil {
+			return err
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s isn't a directory", dir)
+		}
+		break
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// within reports whether the path is the directory or somewhere inside it
+func within(path string, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
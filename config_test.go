@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg != DefaultConfig() {
+		t.Errorf("expected the defaults without flags, got %+v", cfg)
+	}
+
+	cfg, err = LoadConfig([]string{"-write-timeout", "2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.WriteTimeout != 2*time.Second {
+		t.Errorf("expected a 2s write timeout, got %v", cfg.WriteTimeout)
+	}
+}
+
+func TestLoadConfig_Flags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		applied func(cfg Config) bool
+		// invalid are flags that must be rejected
+		invalid [][]string
+	}{
+		{
+			"write timeout", []string{"-write-timeout", "2s"},
+			func(cfg Config) bool { return cfg.WriteTimeout == 2*time.Second },
+			[][]string{{"-write-timeout", "0s"}, {"-write-timeout", "-1s"}},
+		},
+		{
+			"snapshot interval", []string{"-snapshot-interval", "0s"},
+			func(cfg Config) bool { return cfg.SnapshotInterval == 0 },
+			[][]string{{"-snapshot-interval", "-1s"}},
+		},
+		{
+			"chat filter policy", []string{"-chat-filter-policy", ChatFilterReject},
+			func(cfg Config) bool { return cfg.ChatFilterPolicy == ChatFilterReject },
+			[][]string{{"-chat-filter-policy", "ignore"}},
+		},
+		{
+			"max answer length", []string{"-max-answer-length", "64"},
+			func(cfg Config) bool { return cfg.MaxAnswerLength == 64 },
+			[][]string{{"-max-answer-length", "0"}},
+		},
+		{
+			"max game duration", []string{"-max-game-duration", "1h"},
+			func(cfg Config) bool { return cfg.MaxGameDuration == time.Hour },
+			[][]string{{"-max-game-duration", "-1m"}},
+		},
+		{
+			"inactivity timeout", []string{"-inactivity-timeout", "10m", "-inactivity-warning", "30s"},
+			func(cfg Config) bool {
+				return cfg.InactivityTimeout == 10*time.Minute && cfg.InactivityWarning == 30*time.Second
+			},
+			[][]string{{"-inactivity-timeout", "-1m"}},
+		},
+		{
+			"egress overflow policy", []string{"-egress-overflow-policy", "drop-oldest"},
+			func(cfg Config) bool { return cfg.EgressOverflowPolicy == EgressDropOldest },
+			[][]string{{"-egress-overflow-policy", "drop-everything"}},
+		},
+		{
+			"broadcast workers", []string{"-broadcast-workers", "2"},
+			func(cfg Config) bool { return cfg.BroadcastWorkers == 2 },
+			[][]string{{"-broadcast-workers", "0"}},
+		},
+		{
+			"chat history size", []string{"-chat-history-size", "0"},
+			func(cfg Config) bool { return cfg.ChatHistorySize == 0 },
+			[][]string{{"-chat-history-size", "-1"}},
+		},
+		{
+			"owner reconnect grace", []string{"-owner-reconnect-grace", "30s"},
+			func(cfg Config) bool { return cfg.OwnerReconnectGrace == 30*time.Second },
+			[][]string{{"-owner-reconnect-grace", "-1s"}},
+		},
+		{
+			"max request body bytes", []string{"-max-request-body-bytes", "4096"},
+			func(cfg Config) bool { return cfg.MaxRequestBodyBytes == 4096 },
+			[][]string{{"-max-request-body-bytes", "0"}},
+		},
+		{
+			"max custom problems", []string{"-max-custom-problems", "20", "-max-custom-problems-bytes", "4096"},
+			func(cfg Config) bool { return cfg.MaxCustomProblems == 20 && cfg.MaxCustomProblemsBytes == 4096 },
+			[][]string{{"-max-custom-problems", "0"}, {"-max-custom-problems-bytes", "-1"}},
+		},
+		{
+			"login bans", []string{"-login-ban-threshold", "5", "-login-ban-window", "1m", "-login-ban-duration", "1h"},
+			func(cfg Config) bool {
+				return cfg.LoginBanThreshold == 5 && cfg.LoginBanWindow == time.Minute && cfg.LoginBanDuration == time.Hour
+			},
+			[][]string{{"-login-ban-threshold", "-1"}, {"-login-ban-window", "0s"}, {"-login-ban-duration", "-1m"}},
+		},
+		{
+			"render hook", []string{"-render-hook-url", "http://localhost:9000/render", "-render-hook-timeout", "500ms"},
+			func(cfg Config) bool {
+				return cfg.RenderHookURL == "http://localhost:9000/render" && cfg.RenderHookTimeout == 500*time.Millisecond
+			},
+			[][]string{{"-render-hook-url", "localhost:9000"}, {"-render-hook-url", "ftp://example.com"}, {"-render-hook-timeout", "0s"}},
+		},
+		{
+			"disconnect grace", []string{"-disconnect-grace", "10s"},
+			func(cfg Config) bool { return cfg.DisconnectGrace == 10*time.Second },
+			[][]string{{"-disconnect-grace", "-1s"}},
+		},
+		{
+			"start ack timeout", []string{"-start-ack-timeout", "3s"},
+			func(cfg Config) bool { return cfg.StartAckTimeout == 3*time.Second },
+			[][]string{{"-start-ack-timeout", "0s"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := LoadConfig(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.applied(cfg) {
+				t.Errorf("expected %v to be applied, got %+v", test.args, cfg)
+			}
+			for _, args := range test.invalid {
+				if _, err := LoadConfig(args); err == nil {
+					t.Errorf("expected %v to be rejected", args)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfig_ReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	notADirectory := filepath.Join(dir, "file")
+	if err := os.WriteFile(notADirectory, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A logs directory that doesn't exist yet is fine, as long as it can be created
+	cfg, err := LoadConfig([]string{"-logs-dir", filepath.Join(dir, "logs", "nested"), "-listen-addr", "127.0.0.1:9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != "127.0.0.1:9090" {
+		t.Errorf("expected the listen address to be set, got %q", cfg.ListenAddr)
+	}
+
+	_, err = LoadConfig([]string{
+		"-logs-dir", filepath.Join(notADirectory, "logs"),
+		"-problems-file", filepath.Join(dir, "missing.json"),
+		"-max-game-duration", "-1s",
+		"-listen-addr", "localhost:99999",
+	})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+	expected := []string{"max game duration", "listen address", "problems file", "logs directory"}
+	if len(configErr.Problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), configErr.Problems)
+	}
+	for i, problem := range expected {
+		if !strings.Contains(configErr.Problems[i], problem) {
+			t.Errorf("expected the %s to be reported, got %q", problem, configErr.Problems[i])
+		}
+	}
+}
+
+func TestLoadConfig_SessionsDirectory(t *testing.T) {
+	logs := filepath.Join(t.TempDir(), "logs")
+	if _, err := LoadConfig([]string{"-logs-dir", logs, "-sessions-dir", filepath.Join(t.TempDir(), "sessions")}); err != nil {
+		t.Fatal(err)
+	}
+	// Anything in the logs directory is served, so the sessions can't be saved there
+	for _, sessions := range []string{logs, filepath.Join(logs, "sessions")} {
+		if _, err := LoadConfig([]string{"-logs-dir", logs, "-sessions-dir", sessions}); err == nil {
+			t.Errorf("expected a sessions directory of %s to be rejected", sessions)
+		}
+	}
+}
+
+func TestLoadConfig_AllowedOrigins(t *testing.T) {
+	cfg, err := LoadConfig([]string{"-allowed-origins", "https://frontend.example, http://localhost:3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origins := cfg.allowedOrigins(); len(origins) != 2 || origins[1] != "http://localhost:3000" {
+		t.Errorf("expected the two origins, got %v", origins)
+	}
+	if _, err := LoadConfig([]string{"-allowed-origins", "frontend.example"}); err == nil {
+		t.Error("expected an origin without a scheme to be rejected")
+	}
+	if _, err := LoadConfig([]string{"-allowed-origins", "https://frontend.example/app"}); err == nil {
+		t.Error("expected an origin with a path to be rejected")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// GameEstimate is roughly how long a game with the given settings will run
+type GameEstimate struct {
+	// Problems is how many problems each player gets
+	Problems int `json:"problems"`
+	// MinSeconds is how soon the game can end, if everyone answers every problem as soon as it's answerable
+	MinSeconds int `json:"minSeconds"`
+	// MaxSeconds is the longest the game can run before its time limit (or the rounds' or server's) ends it
+	MaxSeconds int `json:"maxSeconds"`
+}
+
+// estimateGameLength estimates how long a game of duration seconds, drawing from a pool of problems, runs for
+func estimateGameLength(duration int, settings GameSettings, pool int) GameEstimate {
+	estimate := GameEstimate{Problems: pool}
+	if settings.NumProblems > 0 && settings.NumProblems < pool {
+		estimate.Problems = settings.NumProblems
+	}
+
+	// The clock stops when it runs out, when every timed round has, or at the server's ceiling
+	limit := time.Duration(duration) * time.Second
+	if settings.Rounds > 1 && settings.RoundSeconds > 0 {
+		rounds := time.Duration(settings.Rounds) * time.Duration(settings.RoundSeconds) * time.Second
+		if rounds < limit {
+			limit = rounds
+		}
+	}
+	if config.MaxGameDuration > 0 && config.MaxGameDuration < limit {
+		limit = config.MaxGameDuration
+	}
+	// Otherwise the game ends once everyone's through their problems, none of which can be answered before
+	// their preview is over
+	fastest := time.Duration(estimate.Problems) * time.Duration(settings.PreviewSeconds) * time.Second
+	if fastest > limit {
+		fastest = limit
+	}
+	// A synchronized start can wait on slow players before the clock starts
+	if settings.SynchronizedStart {
+		limit += config.StartAckTimeout
+	}
+
+	estimate.MinSeconds = int(fastest.Seconds())
+	estimate.MaxSeconds = int(limit.Seconds())
+	return estimate
+}
+
+// estimateHandler estimates how long a game would run with the given time limit and settings, so owners know
+// what to expect while setting one up. Games using the default problems draw from those (matching the tags);
+// for custom problems, poolSize is how many there are
+func estimateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	type estimateRequest struct {
+		Duration int `json:"durationTime"`
+		PoolSize int `json:"poolSize"`
+		GameSettings
+	}
+	var req estimateRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if req.Duration <= 0 {
+		http.Error(w, "durationTime must be positive", http.StatusBadRequest)
+		return
+	} else if req.PoolSize < 0 {
+		http.Error(w, "poolSize can't be negative", http.StatusBadRequest)
+		return
+	}
+	if err := req.GameSettings.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pool := req.PoolSize
+	if pool == 0 {
+		problems := GetProblems().Problems
+		pool = len(filterProblemsByTags(problems, identityOrder(len(problems)), req.Tags))
+	}
+
+	data, err := json.Marshal(estimateGameLength(req.Duration, req.GameSettings, pool))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateGameLength(t *testing.T) {
+	previous := config
+	config.MaxGameDuration = time.Hour
+	config.StartAckTimeout = 10 * time.Second
+	t.Cleanup(func() { config = previous })
+
+	for _, test := range []struct {
+		name     string
+		duration int
+		settings GameSettings
+		pool     int
+		expected GameEstimate
+	}{
+		{"every problem, no previews", 600, GameSettings{}, 20, GameEstimate{20, 0, 600}},
+		{"fewer problems than the pool", 600, GameSettings{NumProblems: 5, PreviewSeconds: 10}, 20, GameEstimate{5, 50, 600}},
+		{"more problems than the pool", 600, GameSettings{NumProblems: 50, PreviewSeconds: 10}, 20, GameEstimate{20, 200, 600}},
+		{"previews longer than the game", 60, GameSettings{PreviewSeconds: 10}, 20, GameEstimate{20, 60, 60}},
+		{"timed rounds", 600, GameSettings{Rounds: 3, RoundSeconds: 120}, 20, GameEstimate{20, 0, 360}},
+		{"untimed rounds", 600, GameSettings{Rounds: 3}, 20, GameEstimate{20, 0, 600}},
+		{"the server's ceiling", 7200, GameSettings{}, 20, GameEstimate{20, 0, 3600}},
+		{"synchronized start", 600, GameSettings{SynchronizedStart: true}, 20, GameEstimate{20, 0, 610}},
+	} {
+		if estimate := estimateGameLength(test.duration, test.settings, test.pool); estimate != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, estimate)
+		}
+	}
+}
+
+func TestEstimateHandler(t *testing.T) {
+	useProblemsFile(t, `{"problems": [
+		{"title": "One", "description": "d", "latex": "a", "answer": "a", "tags": ["algebra"]},
+		{"title": "Two", "description": "d", "latex": "b", "answer": "b", "tags": ["calculus"]},
+		{"title": "Three", "description": "d", "latex": "c", "answer": "c", "tags": ["algebra"]}
+	]}`)
+
+	estimate := func(body string) (*httptest.ResponseRecorder, GameEstimate) {
+		rec := httptest.NewRecorder()
+		estimateHandler(rec, httptest.NewRequest(http.MethodPost, "/lobby/estimate", strings.NewReader(body)))
+		var estimate GameEstimate
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &estimate); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, estimate
+	}
+
+	// The default problems matching the tags are the pool
+	if rec, got := estimate(`{"durationTime": 300, "tags": ["algebra"], "previewSeconds": 5}`); rec.Code != http.StatusOK || got != (GameEstimate{2, 10, 300}) {
+		t.Errorf("expected 2 problems taking 10-300s, got %d %+v", rec.Code, got)
+	}
+	// Unless the custom problems' pool is given
+	if rec, got := estimate(`{"durationTime": 300, "poolSize": 8, "previewSeconds": 5}`); rec.Code != http.StatusOK || got != (GameEstimate{8, 40, 300}) {
+		t.Errorf("expected 8 problems taking 40-300s, got %d %+v", rec.Code, got)
+	}
+
+	for _, body := range []string{`{"durationTime": 0}`, `{"durationTime": 300, "previewSeconds": -1}`, `{"durationTime": 300, "poolSize": -1}`} {
+		if rec, _ := estimate(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -42,20 +46,125 @@ const (
 	EventWrongAnswer = "wrong_answer"
 	// EventEndGame is sent when the game is over
 	EventEndGame = "end_game"
+	// EventAttemptsExhausted is sent when a user runs out of attempts on a problem
+	EventAttemptsExhausted = "attempts_exhausted"
+	// EventError is sent when a user's request can't be carried out
+	EventError = "error"
+	// EventOwnerChanged is sent when the lobby gets a new owner
+	EventOwnerChanged = "owner_changed"
+	// EventPlayerFinished is sent when a player has gone through every problem
+	EventPlayerFinished = "player_finished"
+	// EventNewMessage is sent when someone in the lobby sends a chat message
+	EventNewMessage = "new_message"
+	// EventHint is sent when a user is given a hint for their current problem
+	EventHint = "hint"
+	// EventHintCount is sent when a user asks how many hints their current problem has
+	EventHintCount = "hint_count"
+	// EventPlayerReady is sent when a player marks themselves as ready (or not)
+	EventPlayerReady = "player_ready"
+	// EventPlayers is sent when a user asks for the lobby's roster
+	EventPlayers = "players"
+	// EventNameChanged is sent when a player changes their name
+	EventNameChanged = "name_changed"
+	// EventInactivityWarning is sent when a client is about to be disconnected for inactivity
+	EventInactivityWarning = "inactivity_warning"
+	// EventTimeRemaining is sent when a user asks how long the game has left
+	EventTimeRemaining = "time_remaining"
+	// EventScoreboard is sent when a user asks for the current standings
+	EventScoreboard = "scoreboard"
+	// EventPlayerRank is sent when a player asks for their current rank
+	EventPlayerRank = "player_rank"
+	// EventOwnerStatus is sent when a user asks whether they own the lobby
+	EventOwnerStatus = "owner_status"
+	// EventChatHistory is sent to clients as they join, with the lobby's recent chat messages
+	EventChatHistory = "chat_history"
+	// EventAnswerResult is sent, when a render hook is configured, with how an answer was judged and a preview of it
+	EventAnswerResult = "answer_result"
+	// EventCorrectCount is sent when a player asks how many problems they've answered correctly
+	EventCorrectCount = "correct_count"
+	// EventElapsedBreakdown is sent when a player asks how long they've spent on each problem
+	EventElapsedBreakdown = "elapsed_breakdown"
+	// EventRoster is sent when a client joins, or asks for a resync, with the full roster
+	EventRoster = "roster"
+	// EventRosterDiff is sent when someone in the roster joins, leaves, is renamed or changes
+	EventRosterDiff = "roster_diff"
+	// EventRoundComplete is sent when a round of a multi-round game ends
+	EventRoundComplete = "round_complete"
+	// EventPrepareStart is sent when a synchronized game is about to start, for players to acknowledge once ready
+	EventPrepareStart = "prepare_start"
+	// EventGameConfig is sent when a user asks for the lobby's configuration, and to everyone when the owner changes it
+	EventGameConfig = "game_config"
 )
 
 // client -> server events
 const (
 	// EventStartGameOwner is sent when the game is started by the owner, by the owner
 	EventStartGameOwner = "start_game_owner"
-	// EventRequestProblem is sent when a user requests a new problem
+	// EventRequestProblem is sent when a user asks for their current problem (e.g. after reconnecting),
+	// or for a practice problem while waiting for the game to start
 	EventRequestProblem = "request_problem"
+	// EventSkipProblem is sent when a user gives up on their current problem and moves on to the next one
+	EventSkipProblem = "skip_problem"
 	// EventGiveAnswer is sent when a user answers a problem
 	EventGiveAnswer = "give_answer"
+	// EventUndo is sent when a user takes back their last wrong answer
+	EventUndo = "undo_answer"
+	// EventForceFinish is sent when the owner ends the game early
+	EventForceFinish = "force_finish"
+	// EventTransferOwnership is sent when the owner hands the lobby to another player
+	EventTransferOwnership = "transfer_ownership"
+	// EventSendMessage is sent when a user sends a chat message
+	EventSendMessage = "send_message"
+	// EventSetChatFilter is sent when the owner turns chat filtering on or off
+	EventSetChatFilter = "set_chat_filter"
+	// EventRequestHint is sent when a user asks for the next hint on their current problem
+	EventRequestHint = "request_hint"
+	// EventRequestHintCount is sent when a user asks how many hints are left on their current problem
+	EventRequestHintCount = "request_hint_count"
+	// EventSetReady is sent when a player marks themselves as ready (or not) for the game to start
+	EventSetReady = "set_ready"
+	// EventGetPlayers is sent when a user asks for the lobby's roster
+	EventGetPlayers = "get_players"
+	// EventChangeName is sent when a player changes their name before the game starts
+	EventChangeName = "change_name"
+	// EventSpectateToggle is sent when a user switches between playing and spectating
+	EventSpectateToggle = "spectate_toggle"
+	// EventRequestTimeRemaining is sent when a user asks how long the game has left (e.g. when their tab
+	// regains focus and its clock may have drifted)
+	EventRequestTimeRemaining = "request_time_remaining"
+	// EventRequestScoreboard is sent when a user asks for the current standings
+	EventRequestScoreboard = "request_scoreboard"
+	// EventRequestPlayerRank is sent when a player asks for their current rank, without needing the whole
+	// scoreboard
+	EventRequestPlayerRank = "request_player_rank"
+	// EventRequestOwnerStatus is sent when a user asks whether they own the lobby (e.g. to know whether to show
+	// the owner's controls after reconnecting)
+	EventRequestOwnerStatus = "request_owner_status"
+	// EventRequestRoster is sent when a client wants the full roster again, e.g. after missing a diff
+	EventRequestRoster = "request_roster"
+	// EventRequestCorrectCount is sent when a player asks how many problems they've answered correctly
+	EventRequestCorrectCount = "request_correct_count"
+	// EventRequestElapsedBreakdown is sent when a player asks how long they've spent on each problem
+	EventRequestElapsedBreakdown = "request_elapsed_breakdown"
+	// EventRequestGameConfig is sent when a user asks for the lobby's configuration
+	EventRequestGameConfig = "request_game_config"
+	// EventChangeSettings is sent when the owner changes the lobby's configuration before the game starts
+	EventChangeSettings = "change_settings"
+	// EventStartAck is sent when a player is ready for a synchronized game to start
+	EventStartAck = "start_ack"
 )
 
 const TIME_TO_START_GAME = 0 * time.Second
 
+// UNDO_WINDOW is how long after a wrong answer the user has to undo it
+const UNDO_WINDOW = 3 * time.Second
+
+// DUPLICATE_ANSWER_WINDOW is how soon after an answer the same answer is taken to be a double submission and ignored
+const DUPLICATE_ANSWER_WINDOW = 1 * time.Second
+
+// MAX_ANSWER_LENGTH is the default for the longest answer (in bytes) that will be judged
+const MAX_ANSWER_LENGTH = 1024
+
 // NewMemberEvent is returned when a new member joins the game
 type NewMemberEvent struct {
 	Name string `json:"name"`
@@ -72,17 +181,237 @@ type StartGameEvent struct {
 	Duration       int       `json:"duration"`
 }
 
+// GameSettings are the rules of a game, chosen by the owner when starting it
+type GameSettings struct {
+	// MaxAttempts is the number of wrong answers allowed per problem before moving on (0 = unlimited)
+	MaxAttempts int `json:"maxAttempts"`
+	// HideScoreboard keeps players' scores private until the game ends
+	HideScoreboard bool `json:"hideScoreboard"`
+	// Tags restricts the game to problems with at least one of these tags (empty = all problems)
+	Tags []string `json:"tags"`
+	// NumProblems is how many problems each player gets (0 = every problem in the pool)
+	NumProblems int `json:"numProblems"`
+	// WeightedSelection gives each player their own order through the pool, favouring the problems
+	// that have been served least so far, so shorter games still cover the whole pool
+	WeightedSelection bool `json:"weightedSelection"`
+	// PreviewSeconds is how long each problem is shown before answers to it are accepted (0 = no preview)
+	PreviewSeconds int `json:"previewSeconds"`
+	// AdvanceMode is what happens after a correct answer: AdvanceAuto (the default) serves the next problem
+	// straight away, while with AdvanceManual players ask for it with EventRequestProblem
+	AdvanceMode string `json:"advanceMode"`
+	// WrongAnswerPenalty is how many points a wrong answer costs (0 = no penalty)
+	WrongAnswerPenalty int `json:"wrongAnswerPenalty"`
+	// DifficultyPenalties overrides WrongAnswerPenalty for problems of the given difficulties (matched ignoring
+	// case), so wrong answers to harder problems can cost more, e.g. {"easy": 1, "hard": 5}
+	DifficultyPenalties map[string]int `json:"difficultyPenalties,omitempty"`
+	// WarmupSeconds waives wrong-answer penalties for the start of the game (0 = no warmup)
+	WarmupSeconds int `json:"warmupSeconds"`
+	// WarmupFirstProblem waives wrong-answer penalties on each player's first problem
+	WarmupFirstProblem bool `json:"warmupFirstProblem"`
+	// AnonymousNames shows players as "Player 1", "Player 2", ... in score and progress updates, except to the
+	// owner (results still use real names)
+	AnonymousNames bool `json:"anonymousNames"`
+	// Seed drives the game's random choices (problem order and weighted selection), so the problems served can
+	// be reproduced from it. If it isn't given, one is picked at random and recorded in the results
+	Seed int64 `json:"seed"`
+	// LateJoin is what happens to players joining once the game has started: LateJoinFromStart (the default)
+	// starts them on the first problem, LateJoinCatchUp starts them level with the furthest-behind player, and
+	// LateJoinClosed turns them away
+	LateJoin string `json:"lateJoin"`
+	// Rounds splits the game's problems evenly into this many rounds (0 or 1 = a single round). Everyone waits
+	// for the round to finish before starting the next one together, and is sent the round's standings in between
+	Rounds int `json:"rounds"`
+	// RoundSeconds is how long each round can last before it's ended for everyone (0 = until everyone's
+	// through its problems)
+	RoundSeconds int `json:"roundSeconds"`
+	// SynchronizedStart holds the game back until every player has acknowledged it's starting (or the server's
+	// start ack timeout runs out), so players on slow connections don't start behind
+	SynchronizedStart bool `json:"synchronizedStart"`
+	// TieBreak orders players with equal scores in the scoreboard and results: TieBreakFinishTime ranks whoever
+	// finished every problem first ahead, TieBreakAttempts whoever gave the fewest answers, and TieBreakAccuracy
+	// whoever answered most accurately. Without one, tied players share a rank
+	TieBreak string `json:"tieBreak,omitempty"`
+	// HintBudget is how many hints each player can use across the whole game, on top of each problem's own
+	// hints (0 = no limit)
+	HintBudget int `json:"hintBudget"`
+	// RequeueSkipped gives players a second chance at the problems they skip, serving them again (in the order
+	// they were skipped) once they're through the rest. Problems skipped the second time round are gone for good
+	RequeueSkipped bool `json:"requeueSkipped"`
+}
+
+// Values for GameSettings.TieBreak
+const (
+	TieBreakFinishTime = "finish_time"
+	TieBreakAttempts   = "fewest_attempts"
+	TieBreakAccuracy   = "accuracy"
+)
+
+// Values for GameSettings.LateJoin
+const (
+	LateJoinFromStart = "from_start"
+	LateJoinCatchUp   = "catch_up"
+	LateJoinClosed    = "closed"
+)
+
+// Values for GameSettings.AdvanceMode
+const (
+	AdvanceAuto   = "auto"
+	AdvanceManual = "manual"
+)
+
 // AnswerEvent is passed in when the game is started by the owner
 type RequestStartGameEvent struct {
 	Duration          int      `json:"durationTime"`
 	OrderIsRandom     bool     `json:"randomOrder"`
 	UseCustomProblems bool     `json:"useCustomProblems"`
 	CustomProblems    Problems `json:"customProblems"`
+	// CustomOrder is the order to play the custom problems in, as indices into CustomProblems.
+	// It must be a permutation of every index; if it's not given the problems are played in order (or randomly)
+	CustomOrder []int `json:"customOrder"`
+	// Force starts the game even if there are fewer than the lobby's minimum players
+	Force bool `json:"force"`
+	GameSettings
+}
+
+// TransferOwnershipEvent is passed in when the owner hands over the lobby
+type TransferOwnershipEvent struct {
+	Name string `json:"name"`
+}
+
+// OwnerChangedEvent is returned when the lobby gets a new owner
+type OwnerChangedEvent struct {
+	Name string `json:"name"`
+}
+
+// OwnerStatusEvent is returned when a user asks whether they own the lobby
+type OwnerStatusEvent struct {
+	IsOwner bool `json:"isOwner"`
+	// Owner is the owner's name (empty if nobody owns the lobby yet)
+	Owner string `json:"owner"`
+}
+
+// PlayerFinishedEvent is returned when a player runs out of problems
+type PlayerFinishedEvent struct {
+	Name string `json:"name"`
+}
+
+// SendMessageEvent is passed in when a user sends a chat message
+type SendMessageEvent struct {
+	Message string `json:"message"`
+}
+
+// NewMessageEvent is returned when someone sends a chat message
+type NewMessageEvent struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// ChatHistoryEvent is returned when a client joins, with the lobby's recent chat messages (oldest first)
+type ChatHistoryEvent struct {
+	Messages []NewMessageEvent `json:"messages"`
+}
+
+// SetChatFilterEvent is passed in when the owner turns chat filtering on or off
+type SetChatFilterEvent struct {
+	Enabled bool `json:"enabled"`
+}
+
+// HintEvent is returned when a user is given a hint
+type HintEvent struct {
+	Hint string `json:"hint"`
+	// Used is how many hints have now been revealed for the problem, including this one
+	Used  int `json:"used"`
+	Total int `json:"total"`
+}
+
+// HintCountEvent is returned when a user asks how many hints their problem has
+type HintCountEvent struct {
+	Used  int `json:"used"`
+	Total int `json:"total"`
+}
+
+// AnswerResultEvent is returned after a player answers, when a render hook is configured
+type AnswerResultEvent struct {
+	Answer  string `json:"answer"`
+	Correct bool   `json:"correct"`
+	// PreviewURL is an image of the answer from the render hook, left out if the hook failed
+	PreviewURL string `json:"previewUrl,omitempty"`
+}
+
+// ProblemTime is how long a player spent on one of their problems, from when they could first answer it until they
+// solved it or moved on
+type ProblemTime struct {
+	// Number is the problem's place in the player's game, counting from 0
+	Number  int     `json:"number"`
+	Title   string  `json:"title"`
+	Seconds float64 `json:"seconds"`
+	Solved  bool    `json:"solved"`
+}
+
+// ElapsedBreakdownEvent is returned when a player asks how long they've spent on each problem
+type ElapsedBreakdownEvent struct {
+	Problems []ProblemTime `json:"problems"`
+}
+
+// CorrectCountEvent is returned when a player asks how many problems they've answered correctly
+type CorrectCountEvent struct {
+	Correct int `json:"correct"`
+}
+
+// SetReadyEvent is passed in when a player marks themselves as ready (or not)
+type SetReadyEvent struct {
+	Ready bool `json:"ready"`
+}
+
+// PlayerReadyEvent is returned when a player marks themselves as ready (or not)
+type PlayerReadyEvent struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+}
+
+// PlayerInfo describes a connected user in the roster
+type PlayerInfo struct {
+	Name      string `json:"name"`
+	Spectator bool   `json:"spectator"`
+	Ready     bool   `json:"ready"`
+	// Score is only included while the game is in play, and the scoreboard isn't hidden
+	Score *int `json:"score,omitempty"`
+}
+
+// PlayersEvent is returned when a user asks for the lobby's roster
+type PlayersEvent struct {
+	Players []PlayerInfo `json:"players"`
+}
+
+// ChangeNameEvent is passed in when a player changes their name
+type ChangeNameEvent struct {
+	Name string `json:"name"`
+}
+
+// NameChangedEvent is returned when a player changes their name
+type NameChangedEvent struct {
+	OldName string `json:"oldName"`
+	NewName string `json:"newName"`
+}
+
+// InactivityWarningEvent is returned when a client hasn't done anything for a while
+type InactivityWarningEvent struct {
+	// SecondsLeft is how long the client has to do something before they're disconnected
+	SecondsLeft int `json:"secondsLeft"`
+}
+
+// TimeRemainingEvent is returned when a user asks how long the game has left
+type TimeRemainingEvent struct {
+	SecondsLeft int `json:"secondsLeft"`
 }
 
 // NewProblemEvent is returned when a new problem is generated
 type NewProblemEvent struct {
 	Problem Problem `json:"problem"`
+	// AnswerableAt is when the problem's preview ends, if the game has one
+	AnswerableAt *time.Time `json:"answerableAt,omitempty"`
+	// Practice problems are sent while waiting for the game to start, and don't score
+	Practice bool `json:"practice,omitempty"`
 }
 
 // AnswerEvent is returned when a user answers a problem
@@ -94,47 +423,197 @@ type AnswerEvent struct {
 type NewScoreUpdateEvent struct {
 	Name  string `json:"name"`
 	Score int    `json:"score"`
+	// Accuracy is the fraction of the user's answers that were correct
+	Accuracy float64 `json:"accuracy"`
+}
+
+// AttemptsExhaustedEvent is returned when a user uses up all their attempts on a problem
+type AttemptsExhaustedEvent struct {
+	Attempts int `json:"attempts"`
+}
+
+// ErrorEvent is returned when a request can't be carried out
+type ErrorEvent struct {
+	Message string `json:"message"`
+}
+
+// Standing is a player's position on the scoreboard
+type Standing struct {
+	Name     string  `json:"name"`
+	Score    int     `json:"score"`
+	Accuracy float64 `json:"accuracy"`
+	// Correct is how many problems the player answered correctly, which their score (e.g. with penalties)
+	// doesn't show on its own
+	Correct int `json:"correct"`
+}
+
+// ScoreboardEvent is returned when a user asks for the current standings
+type ScoreboardEvent struct {
+	Standings []Standing `json:"standings"`
+}
+
+// PlayerRankEvent is returned when a player asks for their current rank
+type PlayerRankEvent struct {
+	// Rank is 1-based, and shared with anyone the player is tied with
+	Rank    int `json:"rank"`
+	Players int `json:"players"`
+}
+
+// RoundCompleteEvent is returned when a round of a multi-round game ends
+type RoundCompleteEvent struct {
+	// Round is the round that ended, counting from 1
+	Round  int `json:"round"`
+	Rounds int `json:"rounds"`
+	// Standings are what each player scored in the round
+	Standings []Standing `json:"standings"`
 }
 
 // EndGameEvent is returned when the game is over
 type EndGameEvent struct {
 	Message string `json:"message"`
+	// Standings are only included once the whole lobby's game is over
+	Standings []Standing `json:"standings,omitempty"`
 }
 
 var (
 	problems *Problems
+	// problemsLock guards problems, which can be reloaded while the server is running
+	problemsLock sync.RWMutex
+	// problemsFile is where the default problem set is loaded from
+	problemsFile = "problems.json"
 )
 
+// logsDirectory is where the results of finished games are saved
+var logsDirectory = filepath.Join(".", "logs")
+
+// sessionsDirectory is where the event logs of finished games are saved. They're only for admins, so they're kept
+// apart from logsDirectory, which is served to anyone
+var sessionsDirectory = filepath.Join(".", "sessions")
+
 // Singleton to get the problems, s.t. problems are only loaded once (upon program instantiation)
 func GetProblems() *Problems {
-	if problems == nil {
-		jsonFile, err := os.Open("problems.json")
+	problemsLock.RLock()
+	loaded := problems
+	problemsLock.RUnlock()
+	if loaded != nil {
+		return loaded
+	}
 
+	problemsLock.Lock()
+	defer problemsLock.Unlock()
+	if problems == nil {
+		loaded, err := LoadProblems(problemsFile)
 		if err != nil {
 			fmt.Println(err)
 			return nil
 		}
-		defer jsonFile.Close()
-		byteValue, _ := ioutil.ReadAll(jsonFile)
+		problems = loaded
+	}
+	return problems
+}
 
-		// We unmarshal our byteArray which contains our
-		// jsonFile's content into 'problems' which we defined above
-		json.Unmarshal(byteValue, &problems)
+// LoadProblems reads and validates a problem set
+func LoadProblems(path string) (*Problems, error) {
+	jsonFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer jsonFile.Close()
+	byteValue, err := ioutil.ReadAll(jsonFile)
+	if err != nil {
+		return nil, err
 	}
 
-	return problems
+	// We unmarshal our byteArray which contains our
+	// jsonFile's content into 'problems' which we defined above
+	var loaded Problems
+	if err := json.Unmarshal(byteValue, &loaded); err != nil {
+		return nil, err
+	}
+	if errs := validateProblems(loaded.Problems); len(errs) > 0 {
+		return nil, &ProblemValidationError{path, errs}
+	}
+	loaded.applyNormalization()
+	loaded.metadata = problemMetadata(loaded.Problems)
+	return &loaded, nil
+}
+
+// ReloadProblems swaps in a freshly loaded problem set for new games; games already being played keep
+// the set they started with. If the file is invalid the current set is kept
+func ReloadProblems() error {
+	loaded, err := LoadProblems(problemsFile)
+	if err != nil {
+		return err
+	}
+
+	problemsLock.Lock()
+	defer problemsLock.Unlock()
+	problems = loaded
+	return nil
 }
 
 func (l *Lobby) getLobbyProblems() []Problem {
 	if l.useCustom {
 		return l.CustomProblems
+	} else if l.problems != nil {
+		return l.problems
 	} else {
 		return GetProblems().Problems
 	}
 }
 
+// standings returns every player's score, highest first
+func (l *Lobby) standings() []Standing {
+	standings := make([]Standing, 0, len(l.userMapping))
+	for name, user := range l.userMapping {
+		if !user.spectator {
+			standings = append(standings, Standing{name, user.score, user.accuracy(), user.answered})
+		}
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		if order := l.settings.tieBreak(l.userMapping[standings[i].Name], l.userMapping[standings[j].Name]); order != 0 {
+			return order < 0
+		}
+		return standings[i].Name < standings[j].Name
+	})
+	return standings
+}
+
+// filterProblemsByTags returns the indices in order of the problems having at least one of the tags
+func filterProblemsByTags(problems []Problem, order []int, tags []string) []int {
+	indices := make([]int, 0, len(order))
+	for _, i := range order {
+		if len(tags) == 0 || problems[i].hasAnyTag(tags) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// identityOrder returns the order [0, n)
+func identityOrder(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+// sendError tells the client why their request failed, returning the same error for the caller to propagate
+func (c *Client) sendError(message string) error {
+	data, err := json.Marshal(ErrorEvent{message})
+	if err != nil {
+		return fmt.Errorf("failed to marshal error message: %v", err)
+	}
+	c.egress <- Event{EventError, data}
+	return errors.New(message)
+}
+
 func endGame(c *Client, message string) error {
-	var broadMessage = EndGameEvent{message}
+	var broadMessage = EndGameEvent{Message: message}
 
 	data, err := json.Marshal(broadMessage)
 	if err != nil {
@@ -147,7 +626,7 @@ func endGame(c *Client, message string) error {
 }
 
 func endGameLobby(l *Lobby, message string) error {
-	var broadMessage = EndGameEvent{message}
+	var broadMessage = EndGameEvent{message, l.standings()}
 
 	data, err := json.Marshal(broadMessage)
 	if err != nil {
@@ -155,9 +634,9 @@ func endGameLobby(l *Lobby, message string) error {
 	}
 
 	var outgoingEvent = Event{EventEndGame, data}
-	for client := range l.clients {
-		client.egress <- outgoingEvent
-	}
+	l.broadcast(outgoingEvent)
+	l.publishToFeeds(outgoingEvent)
+	l.closeFeeds()
 	return nil
 }
 
@@ -167,29 +646,14 @@ func (l *Lobby) saveEndedGame() {
 		return
 	}
 
-	type Player struct {
-		Name  string `json:"name"`
-		Score int    `json:"score"`
-	}
-	type SavedGameResult struct {
-		Name           string    `json:"name"`
-		Players        []Player  `json:"players"`
-		StartTimestamp time.Time `json:"startTimestamp"`
-		GameDuration   int       `json:"gameDuration"`
-	}
-
-	var savedGameRes = SavedGameResult{l.name, make([]Player, 0, len(l.userMapping)), *l.startTime, l.timeLimit}
-	for name, user := range l.userMapping {
-		savedGameRes.Players = append(savedGameRes.Players, Player{name, user.score})
-	}
-
+	var savedGameRes = l.gameResult(time.Now())
 	data, err := json.Marshal(savedGameRes)
 	if err != nil {
 		fmt.Println("Failed to save game {} to JSON", l.id)
 		return
 	}
 
-	logsPath := filepath.Join(".", "logs")
+	logsPath := logsDirectory
 	err = os.MkdirAll(logsPath, os.ModePerm)
 	if err != nil {
 		fmt.Println("Failed to create logs directory")
@@ -202,6 +666,22 @@ func (l *Lobby) saveEndedGame() {
 		return
 	}
 
+	// The problems are kept too, so they can be downloaded (answers and all) once the game is over
+	data, err = json.Marshal(Problems{Problems: l.playedProblems()})
+	if err != nil {
+		fmt.Printf("Failed to save game %s's problems to JSON\n", l.id)
+		return
+	}
+	err = ioutil.WriteFile(filepath.Join(logsPath, l.id+".problems.json"), data, 0644)
+	if err != nil {
+		fmt.Printf("Failed to save game %s's problems to disk\n", l.id)
+		return
+	}
+	if err := l.saveSession(); err != nil {
+		fmt.Printf("Failed to save game %s's session: %v\n", l.id, err)
+		return
+	}
+
 	fmt.Printf("Saved game %s to disk\n", l.id)
 }
 
@@ -209,69 +689,183 @@ func (l *Lobby) saveEndedGame() {
 func StartGameHandler(event Event, c *Client) error {
 	lobby := c.lobby
 
-	// var reqevent RequestStartGameEvent
-	// if err := json.Unmarshal(event.Payload, &reqevent); err != nil {
-	// 	return fmt.Errorf("bad payload in request: %v", err)
-	// }
-	if *lobby.owner != c.name {
+	if !c.ownsLobby() {
 		return fmt.Errorf("only the owner can start the game")
-	} else if lobby.inPlay() {
-		return fmt.Errorf("game is already in progress")
 	}
-	var chatevent RequestStartGameEvent
-	if err := json.Unmarshal(event.Payload, &chatevent); err != nil {
-		return fmt.Errorf("bad payload in request: %v", err)
+	lobby.RLock()
+	started := lobby.gameState != WaitingForPlayers
+	lobby.RUnlock()
+	if started {
+		// e.g. the owner started the game from another tab; there's nothing more to do
+		return nil
+	}
+	// Anything the start leaves out is as the lobby's settings have it
+	lobby.RLock()
+	settings, duration := lobby.settings, lobby.timeLimit
+	lobby.RUnlock()
+	current := RequestStartGameEvent{Duration: duration, GameSettings: settings}
+	// Decoding would write into the lobby's own tags and penalties, so they're only kept if they aren't given
+	current.Tags, current.DifficultyPenalties = nil, nil
+	chatevent, err := decodeOnto(event, current)
+	if err != nil {
+		return err
+	}
+	if chatevent.Tags == nil {
+		chatevent.Tags = settings.Tags
+	}
+	if chatevent.DifficultyPenalties == nil {
+		chatevent.DifficultyPenalties = settings.DifficultyPenalties
 	}
 
-	lobby.timeLimit = chatevent.Duration
+	if err := chatevent.GameSettings.validate(); err != nil {
+		return err
+	}
+	if players := lobby.playerCount(); players < lobby.minPlayers && !chatevent.Force {
+		return c.sendError(fmt.Sprintf("need at least %d players to start, but only %d have joined", lobby.minPlayers, players))
+	}
+
+	for chatevent.Seed == 0 {
+		chatevent.Seed = rand.Int63()
+	}
+	rng := rand.New(rand.NewSource(chatevent.Seed))
 
 	var randomOrder = chatevent.OrderIsRandom
 	var useCustomProblems = chatevent.UseCustomProblems
 	var customProblems = chatevent.CustomProblems
 
+	var lobbyProblems []Problem
+	var order []int
 	if useCustomProblems {
-		lobby.useCustom = true
-		lobby.CustomProblems = customProblems.Problems
+		if err := checkCustomProblemsSize(customProblems.Problems); err != nil {
+			return c.sendError(err.Error())
+		}
+		if errs := validateProblems(customProblems.Problems); len(errs) > 0 {
+			return fmt.Errorf("invalid custom problems: %v", errs[0])
+		}
+		customProblems.applyNormalization()
+		lobbyProblems = customProblems.Problems
+		order = identityOrder(len(lobbyProblems))
+
+		if len(chatevent.CustomOrder) > 0 {
+			if err := validateOrder(chatevent.CustomOrder, len(lobbyProblems)); err != nil {
+				return fmt.Errorf("invalid custom order: %v", err)
+			}
+			// An explicit order takes precedence over shuffling
+			order = chatevent.CustomOrder
+			randomOrder = false
+		}
+	} else {
+		lobbyProblems = GetProblems().Problems
+		order = identityOrder(len(lobbyProblems))
+	}
+
+	// Only the problems matching the chosen tags are played
+	pool := filterProblemsByTags(lobbyProblems, order, chatevent.Tags)
+	if len(pool) == 0 {
+		return fmt.Errorf("no problems match the selected tags")
+	}
+	if chatevent.NumProblems > len(pool) {
+		return fmt.Errorf("only %d problems match the selected tags, but %d were requested", len(pool), chatevent.NumProblems)
+	}
+	numProblems := len(pool)
+	if chatevent.NumProblems > 0 {
+		numProblems = chatevent.NumProblems
+	}
+	if chatevent.Rounds > numProblems {
+		return fmt.Errorf("can't split %d problems into %d rounds", numProblems, chatevent.Rounds)
 	}
-	lobbyProblems := lobby.getLobbyProblems()
-	lobby.CustomOrder = make([]int, len(lobbyProblems))
 
+	customOrder := make([]int, len(pool))
 	if randomOrder {
-		booleanArray := make([]bool, len(lobbyProblems))
-		for i := 0; i < len(lobbyProblems); i++ {
-			x := rand.Intn(len(booleanArray))
+		booleanArray := make([]bool, len(pool))
+		for i := 0; i < len(pool); i++ {
+			x := rng.Intn(len(booleanArray))
 			for booleanArray[x] {
-				x = rand.Intn(len(booleanArray))
+				x = rng.Intn(len(booleanArray))
 			}
-			lobby.CustomOrder[i] = x
+			customOrder[i] = pool[x]
 			booleanArray[x] = true
 		}
 	} else {
-		for i := 0; i < len(lobbyProblems); i++ {
-			lobby.CustomOrder[i] = i
-		}
+		copy(customOrder, pool)
+	}
+	if chatevent.NumProblems > 0 {
+		customOrder = customOrder[:chatevent.NumProblems]
 	}
 
 	startTime := time.Now().Add(TIME_TO_START_GAME)
-	lobby.startTime = &startTime
-
-	var broadMessage = StartGameEvent{startTime, lobby.timeLimit}
 
 	if !DEBUG {
 		time.Sleep(TIME_TO_START_GAME)
 	}
 
-	data, err := json.Marshal(broadMessage)
+	// The game is set up and started under the lock, so if the owner starts it from two tabs at once
+	// only the first start takes effect
+	lobby.Lock()
+	if !lobby.startGame(startTime) {
+		lobby.Unlock()
+		return nil
+	}
+	lobby.timeLimit = chatevent.Duration
+	lobby.settings = chatevent.GameSettings
+	lobby.rngs = make(map[string]*rand.Rand)
+	lobby.served = make(map[int]int)
+	lobby.problemResults = make(map[int]ProblemResult)
+	lobby.useCustom = useCustomProblems
+	if useCustomProblems {
+		lobby.CustomProblems = customProblems.Problems
+	} else {
+		// Pin the default set, so reloading it doesn't change the game
+		lobby.problems = lobbyProblems
+	}
+	lobby.CustomOrder = customOrder
+	lobby.round = 0
+	lobby.beginRound(startTime)
+	if lobby.settings.AnonymousNames {
+		lobby.assignAnonymousLabels()
+	}
+	lobby.Unlock()
+	c.manager.saveSnapshot(lobby)
+
+	if lobby.settings.SynchronizedStart {
+		return c.awaitStartAcks()
+	}
+	return c.launchGame()
+}
+
+// launchGame tells everyone the game has started, starts its clock and sends the players their first problem.
+// c is the client who started the game
+func (c *Client) launchGame() error {
+	lobby := c.lobby
+
+	lobby.RLock()
+	data, err := json.Marshal(StartGameEvent{*lobby.startTime, lobby.timeLimit})
+	lobby.RUnlock()
 	if err != nil {
 		return fmt.Errorf("failed to marshal broadcast message: %v", err)
 	}
 
-	lobby.startGame()
-
 	// Send start game message
 	var outgoingEvent = Event{EventStartGame, data}
-	for client := range lobby.clients {
-		client.egress <- outgoingEvent
+	lobby.broadcast(outgoingEvent)
+	lobby.publishToFeeds(outgoingEvent)
+
+	// End the game after the duration of the game
+	c.manager.startGameTimers(lobby)
+
+	if lobby.settings.WeightedSelection || lobby.getLobbyProblems()[lobby.CustomOrder[0]].isTemplate() {
+		// Everyone gets their own first problem (or their own variant of it), in a fixed order so the game's
+		// seed reproduces them
+		clients := lobby.snapshotClients()
+		sort.Slice(clients, func(i, j int) bool {
+			return clients[i].name < clients[j].name
+		})
+		for _, client := range clients {
+			if !lobby.userMapping[client.name].spectator {
+				client.sendClientProblem()
+			}
+		}
+		return nil
 	}
 
 	// Send the first problem (all users get the same problem & their question number starts off at 0)
@@ -284,78 +878,268 @@ func StartGameHandler(event Event, c *Client) error {
 	}
 
 	outgoingEvent = Event{EventNewProblem, data}
-	for client := range lobby.clients {
-		client.egress <- outgoingEvent
-	}
-
-	// End the game after the duration of the game
-	time.AfterFunc(time.Duration(lobby.timeLimit)*time.Second, func() {
-		lobby.endGame()
-
-		endGameLobby(lobby, "Game over!")
-		for client := range lobby.clients {
-			lobby.removeClient(client)
+	for _, client := range lobby.snapshotClients() {
+		if !lobby.userMapping[client.name].spectator {
+			client.trySend(outgoingEvent)
 		}
+	}
 
-		lobby.saveEndedGame()
-		// We can delete the lobby from the map now and have that be GC'd later
-		delete(c.manager.lobbies, lobby.id)
-	})
+	return nil
+}
 
+// ForceFinishHandler lets the owner end the game early
+func ForceFinishHandler(event Event, c *Client) error {
+	if !c.ownsLobby() {
+		return fmt.Errorf("only the owner can finish the game")
+	}
+	if !c.manager.finishGame(c.lobby, "The owner ended the game!") {
+		return fmt.Errorf("game is not in progress")
+	}
 	return nil
 }
 
 // EventGiveAnswer is sent when a user answers a problem
 func GiveAnswerHandler(event Event, c *Client) error {
-	if !c.lobby.inPlay() {
-		return fmt.Errorf("game is not in progress")
+	if c.lobby.practicing() {
+		return c.givePracticeAnswer(event)
+	} else if err := c.checkInPlay(); err != nil {
+		return err
 	}
-	var chatevent AnswerEvent
-	if err := json.Unmarshal(event.Payload, &chatevent); err != nil {
-		return fmt.Errorf("bad payload in request: %v", err)
+	user, waiting := c.roundState()
+	if user.spectator {
+		return fmt.Errorf("spectators can't answer problems")
 	}
-	user := c.lobby.userMapping[c.name]
-	problem := c.lobby.getLobbyProblems()[c.lobby.CustomOrder[user.questionNumber]]
+	chatevent, err := decode[AnswerEvent](event)
+	if err != nil {
+		return err
+	}
+	// Oversized answers are turned away before they reach the (comparatively expensive) normalizer
+	if len(chatevent.Answer) > config.MaxAnswerLength {
+		return c.sendError(fmt.Sprintf("answers can be at most %d characters long", config.MaxAnswerLength))
+	}
+	if user.finished {
+		return fmt.Errorf("already finished every problem")
+	} else if waiting {
+		return fmt.Errorf("waiting for the round to finish")
+	}
+	questionNumber := user.questionNumber
+	index, problem := c.currentProblem()
+	correct := problem.CheckAnswer(chatevent.Answer)
+
+	// The user's updated under the lock, so a round ending in the meantime (which moves everyone on) isn't undone
+	lobby := c.lobby
+	lobby.Lock()
+	user = lobby.userMapping[c.name]
+	if user.questionNumber != questionNumber {
+		// The round ended while the answer was being checked, taking its problem with it
+		lobby.Unlock()
+		return nil
+	}
+	if user.isDuplicateAnswer(chatevent.Answer) {
+		// e.g. a double click; the first submission has been (or is being) dealt with
+		lobby.Unlock()
+		return nil
+	}
+	if time.Now().Before(user.answerableAt) {
+		lobby.Unlock()
+		return c.sendError("answers aren't accepted until the problem's preview is over")
+	}
+
+	if !correct {
+		// Only the latest wrong answer can be undone
+		before := user
+		before.undo = nil
+		user.undo = &undoableAnswer{before: before, at: time.Now()}
+		user.lastAnswer = &recentAnswer{chatevent.Answer, time.Now()}
+		user.totalAnswers++
+		// Wrong answers are free while players settle in
+		if !c.inWarmup(user) {
+			user.attempts++
+			user.score -= lobby.settings.penaltyFor(problem)
+			if user.score < 0 {
+				user.score = 0
+			}
+		}
+		maxAttempts := lobby.settings.MaxAttempts
+		exhausted := maxAttempts > 0 && user.attempts >= maxAttempts
+		if exhausted {
+			// Undoing now would hand back a problem that's out of attempts
+			user.undo = nil
+		}
+		lobby.userMapping[c.name] = user
+		if exhausted {
+			lobby.recordProblemResult(c.name, index, false)
+		}
+		lobby.Unlock()
 
-	if !problem.CheckAnswer(chatevent.Answer) {
 		c.egress <- Event{EventWrongAnswer, nil}
+		c.sendAnswerResult(chatevent.Answer, false)
+		if user.score != before.score {
+			lobby.publishRosterChange(RosterUpdate, c.name, "")
+		}
+
+		if exhausted {
+			data, err := json.Marshal(AttemptsExhaustedEvent{user.attempts})
+			if err != nil {
+				return fmt.Errorf("failed to marshal broadcast message: %v", err)
+			}
+			c.egress <- Event{EventAttemptsExhausted, data}
+			// No points for this problem; move on to the next one
+			c.advanceProblem("Ran out of problems!")
+			return nil
+		}
 		return fmt.Errorf("bad payload in request")
 	}
 
 	// gainedPoints = ⌈latexSolutionLength / 10⌉
 	gainedPoints := int(math.Ceil(float64(len(problem.Latex)) / float64(10)))
-	c.lobby.userMapping[c.name] = User{
-		password: user.password, questionNumber: user.questionNumber + 1, score: user.score + gainedPoints,
+	user.score += gainedPoints
+	user.answered++
+	user.totalAnswers++
+	user.undo = nil
+	user.lastAnswer = &recentAnswer{chatevent.Answer, time.Now()}
+	lobby.userMapping[c.name] = user
+	lobby.recordProblemResult(c.name, index, true)
+	lobby.Unlock()
+	c.sendAnswerResult(chatevent.Answer, true)
+
+	scoreUpdate := func(shown string) (Event, error) {
+		data, err := json.Marshal(NewScoreUpdateEvent{shown, user.score, user.accuracy()})
+		if err != nil {
+			return Event{}, fmt.Errorf("failed to marshal broadcast message: %v", err)
+		}
+		return Event{EventNewScoreUpdate, data}, nil
 	}
-	user = c.lobby.userMapping[c.name]
 
-	var broadMessage = NewScoreUpdateEvent{c.name, user.score}
+	if lobby.settings.HideScoreboard {
+		// Players still see their own score; everyone else's is revealed at the end
+		clientsScoreUpdateEvent, err := scoreUpdate(c.name)
+		if err != nil {
+			return err
+		}
+		c.egress <- clientsScoreUpdateEvent
+	} else if err := lobby.broadcastAbout(c.name, scoreUpdate); err != nil {
+		return err
+	}
+	lobby.publishRosterChange(RosterUpdate, c.name, "")
 
-	data, err := json.Marshal(broadMessage)
-	if err != nil {
-		return fmt.Errorf("failed to marshal broadcast message: %v", err)
+	if lobby.settings.AdvanceMode == AdvanceManual {
+		// The player asks for the next problem when they're ready
+		c.nextProblem("Ran out of problems!")
+	} else {
+		c.advanceProblem("Ran out of problems!")
 	}
 
-	var clientsScoreUpdateEvent = Event{EventNewScoreUpdate, data}
+	return nil
+}
 
-	for client := range c.lobby.clients {
-		client.egress <- clientsScoreUpdateEvent
+// inWarmup reports whether the user's wrong answers are currently free of penalties
+func (c *Client) inWarmup(user User) bool {
+	settings := c.lobby.settings
+	if settings.WarmupFirstProblem && user.questionNumber == 0 {
+		return true
 	}
+	warmup := time.Duration(settings.WarmupSeconds) * time.Second
+	return warmup > 0 && time.Since(*c.lobby.startTime) < warmup
+}
 
-	if user.questionNumber == len(c.lobby.getLobbyProblems()) {
-		endGame(c, "Ran out of problems!")
-	} else {
-		c.sendClientProblem()
+// problemIndex returns the index (into the lobby's problems) of the client's current problem.
+// With weighted selection, the problem is chosen the first time it's asked for
+func (client *Client) problemIndex() int {
+	lobby := client.lobby
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	user := lobby.userMapping[client.name]
+	if n := len(lobby.CustomOrder); user.questionNumber >= n {
+		// The user's back on the problems they skipped
+		return user.skipped[user.questionNumber-n]
+	}
+	if !lobby.settings.WeightedSelection {
+		return lobby.CustomOrder[user.questionNumber]
 	}
 
-	return nil
+	// Players can jump ahead (e.g. to the start of the next round), so there may be more than one to choose
+	for len(user.order) <= user.questionNumber {
+		user.order = append(user.order, lobby.pickLeastServed(client.name, user.order))
+		lobby.userMapping[client.name] = user
+	}
+	return user.order[user.questionNumber]
+}
+
+// pickLeastServed chooses a problem for the named player from the pool that isn't in seen, at random but
+// weighted towards the problems that have been served the least, and records it as served. The weights depend
+// on what everyone else has been served so far, so the seed only reproduces a player's problems if the others
+// get through theirs in the same order.
+// @dev Requires the lobby's (write) lock to be held
+func (l *Lobby) pickLeastServed(name string, seen []int) int {
+	alreadySeen := make(map[int]bool, len(seen))
+	for _, i := range seen {
+		alreadySeen[i] = true
+	}
+	candidates := make([]int, 0, len(l.CustomOrder))
+	weights := make([]float64, 0, len(l.CustomOrder))
+	total := 0.0
+	for _, i := range l.CustomOrder {
+		if !alreadySeen[i] {
+			weight := 1 / float64(1+l.served[i])
+			candidates = append(candidates, i)
+			weights = append(weights, weight)
+			total += weight
+		}
+	}
+
+	// @dev Pre-condition: the pool hasn't been exhausted, so there's at least one candidate
+	chosen := candidates[len(candidates)-1]
+	r := l.random(name).Float64() * total
+	for j, weight := range weights {
+		if r < weight {
+			chosen = candidates[j]
+			break
+		}
+		r -= weight
+	}
+	l.served[chosen]++
+	return chosen
+}
+
+// random returns the named player's source of randomness, seeded with the game's seed and their name, so the
+// choices made for them don't depend on when everyone else asks for theirs.
+// @dev Requires the lobby's (write) lock to be held
+func (l *Lobby) random(name string) *rand.Rand {
+	if l.rngs == nil {
+		l.rngs = make(map[string]*rand.Rand)
+	}
+	rng, ok := l.rngs[name]
+	if !ok {
+		// e.g. the lobby was restored from a snapshot; the player's sequence starts over from the seed
+		hash := fnv.New64a()
+		hash.Write([]byte(name))
+		rng = rand.New(rand.NewSource(l.settings.Seed ^ int64(hash.Sum64())))
+		l.rngs[name] = rng
+	}
+	return rng
 }
 
 func (client *Client) getNewProblem() NewProblemEvent {
 	lobby := client.lobby
-	user := lobby.userMapping[client.name]
 
-	newProblemBroadcast := NewProblemEvent{lobby.getLobbyProblems()[lobby.CustomOrder[user.questionNumber]]}
+	_, problem := client.currentProblem()
+	newProblemBroadcast := NewProblemEvent{Problem: problem.withoutAnswer()}
+
+	// The preview starts when the problem is first sent, so asking for it again doesn't extend it
+	preview := lobby.settings.PreviewSeconds
+	lobby.Lock()
+	user := lobby.userMapping[client.name]
+	if user.answerableAt.IsZero() {
+		user.answerableAt = time.Now().Add(time.Duration(preview) * time.Second)
+		lobby.userMapping[client.name] = user
+	}
+	lobby.Unlock()
+	if preview > 0 {
+		answerableAt := user.answerableAt
+		newProblemBroadcast.AnswerableAt = &answerableAt
+	}
 
 	return newProblemBroadcast
 }
@@ -375,20 +1159,592 @@ func (client *Client) sendClientProblem() error {
 	return nil
 }
 
+// advanceProblem moves the client on to their next problem and sends it, ending their game if there are none left
+func (client *Client) advanceProblem(outOfProblemsMessage string) {
+	if client.nextProblem(outOfProblemsMessage) {
+		client.sendClientProblem()
+	}
+}
+
+// nextProblem moves the client on to their next problem without sending it, ending their game if there are
+// none left. It returns whether there's a next problem
+func (client *Client) nextProblem(outOfProblemsMessage string) bool {
+	lobby := client.lobby
+	lobby.Lock()
+	user := lobby.userMapping[client.name]
+	user.questionNumber++
+	user.attempts = 0
+	user.hintsUsed = 0
+	user.answerableAt = time.Time{}
+	lobby.userMapping[client.name] = user
+	waiting := lobby.waitingForRound(user)
+	lobby.Unlock()
+
+	if user.questionNumber >= len(lobby.CustomOrder)+len(user.skipped) {
+		client.finishProblems(outOfProblemsMessage)
+		return false
+	}
+	if waiting {
+		// The next round starts once everyone's through this one
+		if lobby.roundFinished() {
+			client.manager.completeRound(lobby, lobby.round)
+		}
+		return false
+	}
+	return true
+}
+
+// finishProblems marks the client as having gone through the whole problem pool,
+// ending the lobby's game once every player has
+func (client *Client) finishProblems(message string) error {
+	lobby := client.lobby
+	lobby.Lock()
+	user := lobby.userMapping[client.name]
+	user.finished = true
+	user.finishedAt = time.Now()
+	lobby.userMapping[client.name] = user
+	lobby.Unlock()
+
+	endGame(client, message)
+
+	err := lobby.broadcastAbout(client.name, func(shown string) (Event, error) {
+		data, err := json.Marshal(PlayerFinishedEvent{shown})
+		if err != nil {
+			return Event{}, fmt.Errorf("failed to marshal broadcast message: %v", err)
+		}
+		return Event{EventPlayerFinished, data}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if lobby.allPlayersFinished() {
+		client.manager.finishGame(lobby, "Everyone has finished!")
+	}
+	return nil
+}
+
 func RequestProblemHandler(event Event, c *Client) error {
-	if !c.lobby.inPlay() {
-		return fmt.Errorf("game is not in progress")
+	if c.lobby.practicing() {
+		return c.sendPracticeProblem()
+	} else if err := c.checkInPlay(); err != nil {
+		return err
 	}
-	user := c.lobby.userMapping[c.name]
-	user = User{password: user.password, questionNumber: user.questionNumber + 1, score: user.score}
 
-	c.lobby.userMapping[c.name] = user
+	if user, waiting := c.roundState(); user.spectator {
+		return fmt.Errorf("spectators can't request problems")
+	} else if user.finished {
+		return fmt.Errorf("already finished every problem")
+	} else if waiting {
+		return fmt.Errorf("waiting for the round to finish")
+	}
 
-	if user.questionNumber == len(c.lobby.getLobbyProblems()) {
-		endGame(c, "Ran out of questions!")
+	// This only resends the current problem, so duplicate requests (e.g. from a double click) don't skip any
+	return c.sendClientProblem()
+}
+
+// SkipProblemHandler moves the user on from their current problem without scoring it
+func SkipProblemHandler(event Event, c *Client) error {
+	if err := c.checkInPlay(); err != nil {
+		return err
+	}
+
+	user, waiting := c.roundState()
+	if user.spectator {
+		return fmt.Errorf("spectators can't skip problems")
+	} else if user.finished {
+		return fmt.Errorf("already finished every problem")
+	} else if waiting {
+		return fmt.Errorf("waiting for the round to finish")
+	}
+	questionNumber := user.questionNumber
+	index := c.problemIndex()
+
+	lobby := c.lobby
+	lobby.Lock()
+	if lobby.userMapping[c.name].questionNumber != questionNumber {
+		// The round ended in the meantime, and already moved them on
+		lobby.Unlock()
+		return nil
+	}
+	lobby.recordProblemResult(c.name, index, false)
+	user = lobby.userMapping[c.name]
+	user.undo = nil
+	if lobby.settings.RequeueSkipped && user.questionNumber < len(lobby.CustomOrder) {
+		user.skipped = append(user.skipped, index)
+	}
+	lobby.userMapping[c.name] = user
+	lobby.Unlock()
+	c.advanceProblem("Ran out of questions!")
+	return nil
+}
+
+// UndoHandler takes back the user's last wrong answer (if it was within UNDO_WINDOW),
+// restoring their attempts and serving that problem again
+func UndoHandler(event Event, c *Client) error {
+	if err := c.checkInPlay(); err != nil {
+		return err
+	}
+	lobby := c.lobby
+	lobby.Lock()
+	user := lobby.userMapping[c.name]
+	if user.undo == nil {
+		lobby.Unlock()
+		return fmt.Errorf("there's no answer to undo")
+	} else if user.finished {
+		lobby.Unlock()
+		return fmt.Errorf("already finished every problem")
+	}
+	if time.Since(user.undo.at) > UNDO_WINDOW {
+		user.undo = nil
+		lobby.userMapping[c.name] = user
+		lobby.Unlock()
+		return fmt.Errorf("it's too late to undo the last answer")
+	}
+
+	lobby.userMapping[c.name] = user.undo.before
+	lobby.Unlock()
+	if user.undo.before.score != user.score {
+		lobby.publishRosterChange(RosterUpdate, c.name, "")
+	}
+	return c.sendClientProblem()
+}
+
+// TransferOwnershipHandler hands the lobby over to another connected player at the owner's request
+func TransferOwnershipHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	if !c.ownsLobby() {
+		return fmt.Errorf("only the owner can transfer ownership")
+	}
+	transferevent, err := decode[TransferOwnershipEvent](event)
+	if err != nil {
+		return err
+	}
+
+	lobby.Lock()
+	if !lobby.isOwner(c.name) {
+		// Ownership changed hands while the request was being decoded
+		lobby.Unlock()
+		return fmt.Errorf("only the owner can transfer ownership")
+	}
+	target, userExists := lobby.userMapping[transferevent.Name]
+	if !userExists || !lobby.hasClient(transferevent.Name) {
+		lobby.Unlock()
+		return fmt.Errorf("no connected player named %s", transferevent.Name)
+	} else if target.spectator {
+		lobby.Unlock()
+		return fmt.Errorf("spectators can't own the lobby")
+	} else if target.guest {
+		lobby.Unlock()
+		return fmt.Errorf("guests can't own the lobby")
+	}
+
+	newOwner := transferevent.Name
+	lobby.owner = &newOwner
+	lobby.Unlock()
+	return lobby.announceOwner(newOwner)
+}
+
+// announceOwner lets everyone know who owns the lobby now
+func (lobby *Lobby) announceOwner(owner string) error {
+	data, err := json.Marshal(OwnerChangedEvent{owner})
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message: %v", err)
+	}
+	lobby.broadcast(Event{EventOwnerChanged, data})
+	return nil
+}
+
+// RequestOwnerStatusHandler tells the user whether they own the lobby
+func RequestOwnerStatusHandler(event Event, c *Client) error {
+	c.lobby.RLock()
+	status := OwnerStatusEvent{IsOwner: c.lobby.isOwner(c.name)}
+	if c.lobby.owner != nil {
+		status.Owner = *c.lobby.owner
+	}
+	c.lobby.RUnlock()
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal owner status: %v", err)
+	}
+	c.egress <- Event{EventOwnerStatus, data}
+	return nil
+}
+
+// checkInPlay tells the client (and returns an error) if the game isn't in play, so events that only make sense
+// during the game can't serve problems or change anyone's progress before it starts or after it ends
+func (c *Client) checkInPlay() error {
+	c.lobby.RLock()
+	state := c.lobby.gameState
+	starting := c.lobby.awaitingAcks != nil
+	c.lobby.RUnlock()
+	switch state {
+	case InPlay:
+		if starting {
+			return c.sendError("the game is about to start")
+		}
 		return nil
+	case WaitingForPlayers:
+		return c.sendError("the game hasn't started yet")
+	default:
+		return c.sendError("the game is over")
+	}
+}
+
+// checkCanPlay returns an error if the client can't currently be working on a problem
+func (c *Client) checkCanPlay() error {
+	user, waiting := c.roundState()
+	if err := c.checkInPlay(); err != nil {
+		return err
+	} else if user.spectator {
+		return fmt.Errorf("spectators don't have problems")
+	} else if user.finished {
+		return fmt.Errorf("already finished every problem")
+	} else if waiting {
+		return fmt.Errorf("waiting for the round to finish")
+	}
+	return nil
+}
+
+// RequestHintHandler reveals the next hint for the user's current problem
+func RequestHintHandler(event Event, c *Client) error {
+	if err := c.checkCanPlay(); err != nil {
+		return err
+	}
+	lobby := c.lobby
+	lobby.RLock()
+	questionNumber := lobby.userMapping[c.name].questionNumber
+	lobby.RUnlock()
+	_, problem := c.currentProblem()
+
+	lobby.Lock()
+	user := lobby.userMapping[c.name]
+	if user.questionNumber != questionNumber {
+		// They moved on while the problem was being looked up, so the hint would be for the wrong one
+		lobby.Unlock()
+		return c.sendError("your problem changed before the hint could be revealed")
+	}
+	if user.hintsUsed >= len(problem.Hints) {
+		lobby.Unlock()
+		return c.sendError("there are no hints left for this problem")
+	}
+	if budget := lobby.settings.HintBudget; budget > 0 && user.totalHints >= budget {
+		lobby.Unlock()
+		return c.sendError(fmt.Sprintf("you've used all %d of your hints for this game", budget))
 	}
 
-	c.sendClientProblem()
+	hint := problem.Hints[user.hintsUsed]
+	user.hintsUsed++
+	user.totalHints++
+	lobby.userMapping[c.name] = user
+	lobby.Unlock()
+
+	data, err := json.Marshal(HintEvent{hint, user.hintsUsed, len(problem.Hints)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal hint: %v", err)
+	}
+	c.egress <- Event{EventHint, data}
+	return nil
+}
+
+// RequestHintCountHandler tells the user how many hints their current problem has, and how many they've used,
+// without revealing any
+func RequestHintCountHandler(event Event, c *Client) error {
+	if err := c.checkCanPlay(); err != nil {
+		return err
+	}
+	_, problem := c.currentProblem()
+	c.lobby.RLock()
+	hintsUsed := c.lobby.userMapping[c.name].hintsUsed
+	c.lobby.RUnlock()
+
+	data, err := json.Marshal(HintCountEvent{hintsUsed, len(problem.Hints)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal hint count: %v", err)
+	}
+	c.egress <- Event{EventHintCount, data}
+	return nil
+}
+
+// RequestCorrectCountHandler tells the player how many problems they've answered correctly
+func RequestCorrectCountHandler(event Event, c *Client) error {
+	c.lobby.RLock()
+	answered := c.lobby.userMapping[c.name].answered
+	c.lobby.RUnlock()
+	data, err := json.Marshal(CorrectCountEvent{answered})
+	if err != nil {
+		return fmt.Errorf("failed to marshal correct count: %v", err)
+	}
+	c.egress <- Event{EventCorrectCount, data}
+	return nil
+}
+
+// RequestElapsedBreakdownHandler tells the player how long they've spent on each problem they're done with
+func RequestElapsedBreakdownHandler(event Event, c *Client) error {
+	timings := c.lobby.userMapping[c.name].timings
+	if timings == nil {
+		timings = []ProblemTime{}
+	}
+	data, err := json.Marshal(ElapsedBreakdownEvent{timings})
+	if err != nil {
+		return fmt.Errorf("failed to marshal elapsed breakdown: %v", err)
+	}
+	c.egress <- Event{EventElapsedBreakdown, data}
+	return nil
+}
+
+// SetReadyHandler marks the player as ready (or not) for the game to start, letting everyone know
+func SetReadyHandler(event Event, c *Client) error {
+	readyevent, err := decode[SetReadyEvent](event)
+	if err != nil {
+		return err
+	}
+
+	c.lobby.Lock()
+	user := c.lobby.userMapping[c.name]
+	if c.lobby.gameState != WaitingForPlayers {
+		c.lobby.Unlock()
+		return fmt.Errorf("game has already started")
+	} else if user.spectator {
+		c.lobby.Unlock()
+		return fmt.Errorf("spectators can't be ready")
+	}
+	user.ready = readyevent.Ready
+	c.lobby.userMapping[c.name] = user
+	c.lobby.Unlock()
+
+	data, err := json.Marshal(PlayerReadyEvent{c.name, readyevent.Ready})
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message: %v", err)
+	}
+	c.lobby.broadcast(Event{EventPlayerReady, data})
+	c.lobby.publishRosterChange(RosterUpdate, c.name, "")
+	return nil
+}
+
+// roster returns every connected user (and those who've only just disconnected), sorted by name, as the viewer
+// is allowed to see them. A roster for everyone is built with no viewer
+func (l *Lobby) roster(viewer string) []PlayerInfo {
+	l.RLock()
+	defer l.RUnlock()
+
+	showScores := l.gameState == InPlay && !l.settings.HideScoreboard
+	seen := make(map[string]bool, len(l.clients))
+	players := make([]PlayerInfo, 0, len(l.clients))
+	for client := range l.clients {
+		// A user can be connected more than once (e.g. from two tabs)
+		if seen[client.name] {
+			continue
+		}
+		seen[client.name] = true
+		players = append(players, l.playerInfo(client.name, viewer, showScores))
+	}
+	for name := range l.disconnected {
+		if !seen[name] {
+			players = append(players, l.playerInfo(name, viewer, showScores))
+		}
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].Name < players[j].Name })
+	return players
+}
+
+// playerInfo describes the user as they're shown to the viewer in the roster. When names are hidden, only the
+// owner and the player themselves see the score next to the name, as the scoreboard only shows others' labels
+// @dev Requires the lobby's lock to be held
+func (l *Lobby) playerInfo(name string, viewer string, showScores bool) PlayerInfo {
+	user := l.userMapping[name]
+	player := PlayerInfo{Name: name, Spectator: user.spectator, Ready: user.ready}
+	named := !l.settings.AnonymousNames || name == viewer || l.isOwner(viewer)
+	if showScores && named && !user.spectator {
+		score := user.score
+		player.Score = &score
+	}
+	return player
+}
+
+// GetPlayersHandler sends the client the lobby's current roster
+func GetPlayersHandler(event Event, c *Client) error {
+	data, err := json.Marshal(PlayersEvent{c.lobby.roster(c.name)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal roster: %v", err)
+	}
+	c.egress <- Event{EventPlayers, data}
+	return nil
+}
+
+// ChangeNameHandler renames the player (on every connection they have) before the game starts
+func ChangeNameHandler(event Event, c *Client) error {
+	nameevent, err := decode[ChangeNameEvent](event)
+	if err != nil {
+		return err
+	}
+	if err := validateUsername(nameevent.Name); err != nil {
+		return c.sendError(err.Error())
+	}
+
+	lobby := c.lobby
+	lobby.Lock()
+	oldName := c.name
+	if lobby.gameState != WaitingForPlayers {
+		lobby.Unlock()
+		return c.sendError("names can't be changed once the game has started")
+	} else if _, taken := lobby.userMapping[nameevent.Name]; taken {
+		lobby.Unlock()
+		return c.sendError(fmt.Sprintf("the name %s is already taken", nameevent.Name))
+	}
+
+	lobby.userMapping[nameevent.Name] = lobby.userMapping[oldName]
+	delete(lobby.userMapping, oldName)
+	for otp, name := range lobby.otpMapping {
+		if name == oldName {
+			lobby.otpMapping[otp] = nameevent.Name
+		}
+	}
+	renamedOwner := lobby.isOwner(oldName)
+	if renamedOwner {
+		newName := nameevent.Name
+		lobby.owner = &newName
+	}
+	for client := range lobby.clients {
+		if client.name == oldName {
+			client.name = nameevent.Name
+		}
+	}
+	lobby.Unlock()
+
+	data, err := json.Marshal(NameChangedEvent{oldName, nameevent.Name})
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast message: %v", err)
+	}
+	lobby.broadcast(Event{EventNameChanged, data})
+	lobby.publishRosterChange(RosterRename, nameevent.Name, oldName)
+	if renamedOwner {
+		return lobby.announceOwner(nameevent.Name)
+	}
+	return nil
+}
+
+// SpectateToggleHandler switches the user between playing and spectating. Before the game starts either
+// way is allowed (if there's room for another player), but once it's in play players can only drop out
+func SpectateToggleHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	lobby.Lock()
+	user := lobby.userMapping[c.name]
+	if lobby.gameState == Finished {
+		lobby.Unlock()
+		return c.sendError("the game is over")
+	} else if user.spectator && lobby.gameState != WaitingForPlayers {
+		lobby.Unlock()
+		return c.sendError("spectators can't join once the game has started")
+	} else if user.spectator && lobby.maxPlayers > 0 && lobby.countPlayers() >= lobby.maxPlayers {
+		lobby.Unlock()
+		return c.sendError("lobby is full")
+	} else if !user.spectator && lobby.isOwner(c.name) {
+		lobby.Unlock()
+		return c.sendError("spectators can't own the lobby, transfer ownership first")
+	}
+	user.spectator = !user.spectator
+	user.ready = false
+	lobby.userMapping[c.name] = user
+	inPlay := lobby.gameState == InPlay
+	lobby.Unlock()
+
+	data, err := json.Marshal(PlayersEvent{lobby.roster("")})
+	if err != nil {
+		return fmt.Errorf("failed to marshal roster: %v", err)
+	}
+	lobby.broadcast(Event{EventPlayers, data})
+	lobby.publishRosterChange(RosterUpdate, c.name, "")
+
+	// The game might have only been waiting on this player
+	if inPlay && lobby.allPlayersFinished() {
+		c.manager.finishGame(lobby, "Everyone has finished!")
+	}
+	return nil
+}
+
+// RequestTimeRemainingHandler tells the user how long the game has left, by the server's clock
+func RequestTimeRemainingHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	lobby.RLock()
+	if lobby.gameState != InPlay {
+		lobby.RUnlock()
+		return fmt.Errorf("the game isn't being played")
+	}
+	remaining := lobby.timeRemaining(time.Now())
+	lobby.RUnlock()
+
+	data, err := json.Marshal(TimeRemainingEvent{int(remaining.Round(time.Second).Seconds())})
+	if err != nil {
+		return fmt.Errorf("failed to marshal time remaining: %v", err)
+	}
+	c.egress <- Event{EventTimeRemaining, data}
+	return nil
+}
+
+// visibleStandings returns the standings as the viewer is allowed to see them: while scores are hidden, players
+// only see their own; when names are hidden, everyone but the owner sees the other players' labels.
+// @dev Requires the lobby's (write) lock to be held
+func (lobby *Lobby) visibleStandings(standings []Standing, viewer string) []Standing {
+	hidden := lobby.settings.HideScoreboard && lobby.gameState != Finished
+	shown := make([]Standing, 0, len(standings))
+	for _, standing := range standings {
+		if standing.Name == viewer {
+			shown = append(shown, standing)
+		} else if !hidden {
+			if lobby.settings.AnonymousNames && !lobby.isOwner(viewer) {
+				standing.Name = lobby.anonymousLabel(standing.Name)
+			}
+			shown = append(shown, standing)
+		}
+	}
+	return shown
+}
+
+// RequestScoreboardHandler sends the user the current standings. While scores are hidden, players only see
+// their own; when names are hidden, everyone but the owner sees the other players' labels
+func RequestScoreboardHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	lobby.Lock()
+	shown := lobby.visibleStandings(lobby.standings(), c.name)
+	lobby.Unlock()
+
+	data, err := json.Marshal(ScoreboardEvent{shown})
+	if err != nil {
+		return fmt.Errorf("failed to marshal scoreboard: %v", err)
+	}
+	c.egress <- Event{EventScoreboard, data}
+	return nil
+}
+
+// RequestPlayerRankHandler tells the player their current rank and how many players there are. Ranks give away
+// how everyone else is doing, so they're hidden along with the scoreboard until the game ends
+func RequestPlayerRankHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	lobby.RLock()
+	if lobby.settings.HideScoreboard && lobby.gameState != Finished {
+		lobby.RUnlock()
+		return c.sendError("ranks are hidden until the game ends")
+	}
+	standings := lobby.standings()
+	ranks := lobby.ranks(standings)
+	lobby.RUnlock()
+
+	rank := 0
+	for i, standing := range standings {
+		if standing.Name == c.name {
+			rank = ranks[i]
+		}
+	}
+	if rank == 0 {
+		return c.sendError("spectators don't have a rank")
+	}
+
+	data, err := json.Marshal(PlayerRankEvent{rank, len(standings)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal player rank: %v", err)
+	}
+	c.egress <- Event{EventPlayerRank, data}
 	return nil
 }
@@ -0,0 +1,1847 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testManagers tracks which manager each test lobby is registered with
+var testManagers = make(map[*Lobby]*Manager)
+
+// newTestLobby creates a lobby (registered with a fresh manager) using the given problems in order
+func newTestLobby(t *testing.T, problems []Problem) *Lobby {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	testManagers[lobby] = manager
+
+	// Games that finish save their results
+	useTempLogsDirectory(t)
+	// The game's timers are stopped before the test's settings are put back, so none of them go off afterwards
+	t.Cleanup(func() {
+		lobby.Lock()
+		for _, timer := range []*time.Timer{lobby.endTimer, lobby.ceilingTimer, lobby.roundTimer} {
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+		lobby.Unlock()
+		lobby.stopWaiting()
+	})
+	startTime := time.Now()
+	lobby.startTime = &startTime
+
+	lobby.useCustom = true
+	lobby.CustomProblems = problems
+	lobby.CustomOrder = make([]int, len(problems))
+	for i := range problems {
+		lobby.CustomOrder[i] = i
+	}
+	return lobby
+}
+
+// addTestClient adds a client without a websocket connection; events sent to it are buffered
+func addTestClient(lobby *Lobby, name string) *Client {
+	c := &Client{
+		name:    name,
+		lobby:   lobby,
+		manager: testManagers[lobby],
+		egress:  make(chan Event, 64),
+		closing: make(chan []byte, 1),
+		done:    make(chan struct{}),
+	}
+	if lobby.owner == nil {
+		lobby.owner = &c.name
+	}
+	lobby.userMapping[name] = User{}
+	lobby.clients[c] = true
+	return c
+}
+
+// useTempLogsDirectory saves results (and sessions) to temporary directories for the rest of the test
+func useTempLogsDirectory(t *testing.T) {
+	previousLogs, previousSessions := logsDirectory, sessionsDirectory
+	logsDirectory, sessionsDirectory = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { logsDirectory, sessionsDirectory = previousLogs, previousSessions })
+}
+
+// drainEvents returns all events currently queued for the client
+func drainEvents(c *Client) []Event {
+	var events []Event
+	for {
+		select {
+		case e := <-c.egress:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// countEvents returns how many of the events are of the given type
+func countEvents(events []Event, eventType string) int {
+	count := 0
+	for _, e := range events {
+		if e.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func giveAnswer(t *testing.T, c *Client, answer string) error {
+	t.Helper()
+	payload, err := json.Marshal(AnswerEvent{answer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return GiveAnswerHandler(Event{EventGiveAnswer, payload}, c)
+}
+
+func TestGiveAnswerHandler_AttemptLimit(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+	})
+	lobby.settings.MaxAttempts = 2
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	giveAnswer(t, c, "wrong")
+	if user := lobby.userMapping["alice"]; user.questionNumber != 0 || user.attempts != 1 {
+		t.Fatalf("expected to still be on the first problem with 1 attempt, got %+v", user)
+	}
+
+	giveAnswer(t, c, "also wrong")
+	user := lobby.userMapping["alice"]
+	if user.questionNumber != 1 {
+		t.Errorf("expected to be advanced after exhausting attempts, on question %d", user.questionNumber)
+	}
+	if user.score != 0 {
+		t.Errorf("expected no points for an exhausted problem, got %d", user.score)
+	}
+
+	events := drainEvents(c)
+	if countEvents(events, EventAttemptsExhausted) != 1 {
+		t.Errorf("expected an %s event, got %v", EventAttemptsExhausted, events)
+	}
+	last := events[len(events)-1]
+	var newProblem NewProblemEvent
+	if err := json.Unmarshal(last.Payload, &newProblem); last.Type != EventNewProblem || err != nil {
+		t.Fatalf("expected the next problem to be sent, got %v", last)
+	}
+	if newProblem.Problem.Title != "Two" || newProblem.Problem.Answer != "" {
+		t.Errorf("expected the second problem without its answer, got %+v", newProblem.Problem)
+	}
+
+	// Attempts reset for the new problem, and answering it still scores
+	if err := giveAnswer(t, c, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if user := lobby.userMapping["alice"]; user.score == 0 {
+		t.Error("expected the correct answer on the next problem to score")
+	}
+}
+
+func TestGiveAnswerHandler_UnlimitedAttempts(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	for i := 0; i < 10; i++ {
+		giveAnswer(t, c, "wrong")
+	}
+	if user := lobby.userMapping["alice"]; user.questionNumber != 0 {
+		t.Errorf("expected no auto-advance without an attempt limit, on question %d", user.questionNumber)
+	}
+	if countEvents(drainEvents(c), EventAttemptsExhausted) != 0 {
+		t.Error("didn't expect attempts to be exhausted")
+	}
+}
+
+func TestGiveAnswerHandler_HideScoreboard(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc"},
+		{Title: "Two", Latex: "def", Answer: "def"},
+	})
+	lobby.settings.HideScoreboard = true
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if err := giveAnswer(t, alice, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(drainEvents(alice), EventNewScoreUpdate) != 1 {
+		t.Error("expected alice to still see her own score")
+	}
+	if countEvents(drainEvents(bob), EventNewScoreUpdate) != 0 {
+		t.Error("expected alice's score to be hidden from bob")
+	}
+
+	endGameLobby(lobby, "Game over!")
+	for _, c := range []*Client{alice, bob} {
+		events := drainEvents(c)
+		if len(events) != 1 || events[0].Type != EventEndGame {
+			t.Fatalf("expected an end game event, got %v", events)
+		}
+		var endGame EndGameEvent
+		if err := json.Unmarshal(events[0].Payload, &endGame); err != nil {
+			t.Fatal(err)
+		}
+		expected := []Standing{{"alice", 1, 1, 1}, {"bob", 0, 0, 0}}
+		if len(endGame.Standings) != len(expected) {
+			t.Fatalf("expected standings %v, got %v", expected, endGame.Standings)
+		}
+		for i := range expected {
+			if endGame.Standings[i] != expected[i] {
+				t.Errorf("expected standings %v, got %v", expected, endGame.Standings)
+			}
+		}
+	}
+}
+
+func TestGiveAnswerHandler_VisibleScoreboard(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "abc", Answer: "abc"}})
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if err := giveAnswer(t, alice, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(drainEvents(bob), EventNewScoreUpdate) != 1 {
+		t.Error("expected bob to see alice's score update")
+	}
+}
+
+func requestStartGame(t *testing.T, owner *Client, req RequestStartGameEvent) error {
+	t.Helper()
+	if req.Duration == 0 {
+		req.Duration = 3600
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return StartGameHandler(Event{EventStartGameOwner, payload}, owner)
+}
+
+func TestStartGameHandler_TagFilter(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	custom := Problems{Problems: []Problem{
+		{Title: "Derivative", Description: "d", Latex: "f'(x)", Tags: []string{"calculus"}},
+		{Title: "Matrix", Description: "m", Latex: "A^T", Tags: []string{"matrices"}},
+		{Title: "Integral", Description: "i", Latex: "\\int f", Tags: []string{"Calculus", "integrals"}},
+	}}
+
+	err := requestStartGame(t, owner, RequestStartGameEvent{
+		OrderIsRandom:     true,
+		UseCustomProblems: true,
+		CustomProblems:    custom,
+		GameSettings:      GameSettings{Tags: []string{"calculus"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lobby.CustomOrder) != 2 {
+		t.Fatalf("expected 2 problems in play, got %v", lobby.CustomOrder)
+	}
+
+	// Every problem served must match the filter
+	drainEvents(owner)
+	for i := 0; i < len(lobby.CustomOrder); i++ {
+		problem := owner.getNewProblem().Problem
+		if !problem.hasAnyTag([]string{"calculus"}) {
+			t.Errorf("served problem %q that doesn't match the tag filter", problem.Title)
+		}
+		owner.advanceProblem("done")
+	}
+}
+
+func TestStartGameHandler_CustomProblemLimits(t *testing.T) {
+	previous := config
+	config.MaxCustomProblems = 2
+	config.MaxCustomProblemsBytes = 200
+	t.Cleanup(func() { config = previous })
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+
+	tooMany := Problems{Problems: []Problem{
+		{Title: "One", Description: "1", Latex: "a"},
+		{Title: "Two", Description: "2", Latex: "b"},
+		{Title: "Three", Description: "3", Latex: "c"},
+	}}
+	if err := requestStartGame(t, owner, RequestStartGameEvent{UseCustomProblems: true, CustomProblems: tooMany}); err == nil {
+		t.Error("expected a set with too many problems to be rejected")
+	}
+	tooLarge := Problems{Problems: []Problem{{Title: "Long", Description: strings.Repeat("x", 200), Latex: "a"}}}
+	if err := requestStartGame(t, owner, RequestStartGameEvent{UseCustomProblems: true, CustomProblems: tooLarge}); err == nil {
+		t.Error("expected a set that's too large to be rejected")
+	}
+	if rejections := countEvents(drainEvents(owner), EventError); rejections != 2 {
+		t.Errorf("expected the owner to be told why both sets were rejected, got %d errors", rejections)
+	}
+	if lobby.inPlay() {
+		t.Fatal("the game shouldn't have started")
+	}
+
+	withinLimits := Problems{Problems: tooMany.Problems[:2]}
+	if err := requestStartGame(t, owner, RequestStartGameEvent{UseCustomProblems: true, CustomProblems: withinLimits}); err != nil {
+		t.Fatal(err)
+	}
+	if !lobby.inPlay() || len(lobby.CustomProblems) != 2 {
+		t.Errorf("expected the game to start with the 2 custom problems, got %v", lobby.CustomProblems)
+	}
+}
+
+func TestStartGameHandler_TagFilterTooRestrictive(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	custom := Problems{Problems: []Problem{
+		{Title: "Derivative", Description: "d", Latex: "f'(x)", Tags: []string{"calculus"}},
+		{Title: "Matrix", Description: "m", Latex: "A^T", Tags: []string{"matrices"}},
+	}}
+
+	err := requestStartGame(t, owner, RequestStartGameEvent{
+		UseCustomProblems: true,
+		CustomProblems:    custom,
+		GameSettings:      GameSettings{Tags: []string{"calculus"}, NumProblems: 2},
+	})
+	if err == nil {
+		t.Error("expected a filter with too few matching problems to be rejected")
+	}
+	err = requestStartGame(t, owner, RequestStartGameEvent{
+		UseCustomProblems: true,
+		CustomProblems:    custom,
+		GameSettings:      GameSettings{Tags: []string{"geometry"}},
+	})
+	if err == nil {
+		t.Error("expected a filter with no matching problems to be rejected")
+	}
+	if lobby.inPlay() {
+		t.Error("the game shouldn't have started")
+	}
+}
+
+func TestStartGameHandler_MinPlayers(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	lobby.minPlayers = 3
+	owner := addTestClient(lobby, "owner")
+	addTestClient(lobby, "bob")
+	watcher := addTestClient(lobby, "watcher")
+	lobby.userMapping[watcher.name] = User{spectator: true}
+
+	// Spectators don't count towards the minimum
+	if err := requestStartGame(t, owner, RequestStartGameEvent{}); err == nil {
+		t.Fatal("expected starting below minPlayers to be blocked")
+	}
+	if lobby.inPlay() {
+		t.Fatal("the game shouldn't have started")
+	}
+	if countEvents(drainEvents(owner), EventError) != 1 {
+		t.Error("expected the owner to be sent an error event")
+	}
+
+	addTestClient(lobby, "carol")
+	if err := requestStartGame(t, owner, RequestStartGameEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	if !lobby.inPlay() {
+		t.Error("expected the game to start once minPlayers joined")
+	}
+	if countEvents(drainEvents(watcher), EventNewProblem) != 0 {
+		t.Error("spectators shouldn't be sent problems")
+	}
+}
+
+func TestStartGameHandler_ForceStart(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	lobby.minPlayers = 2
+	owner := addTestClient(lobby, "owner")
+
+	if err := requestStartGame(t, owner, RequestStartGameEvent{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !lobby.inPlay() {
+		t.Error("expected a forced start to ignore minPlayers")
+	}
+}
+
+func TestStartGameHandler_CustomOrder(t *testing.T) {
+	custom := Problems{Problems: []Problem{
+		{Title: "Zero", Description: "0", Latex: "0"},
+		{Title: "One", Description: "1", Latex: "1"},
+		{Title: "Two", Description: "2", Latex: "2"},
+	}}
+	tests := []struct {
+		name     string
+		order    []int
+		expected []int
+	}{
+		{"valid", []int{2, 0, 1}, []int{2, 0, 1}},
+		{"missing", nil, []int{0, 1, 2}},
+		{"duplicate", []int{0, 0, 1}, nil},
+		{"out of range", []int{0, 1, 3}, nil},
+	}
+	for _, test := range tests {
+		lobby := newTestLobby(t, nil)
+		owner := addTestClient(lobby, "owner")
+		err := requestStartGame(t, owner, RequestStartGameEvent{
+			UseCustomProblems: true,
+			CustomProblems:    custom,
+			CustomOrder:       test.order,
+		})
+
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected the order to be rejected", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for i := range test.expected {
+			if lobby.CustomOrder[i] != test.expected[i] {
+				t.Errorf("%s: expected order %v, got %v", test.name, test.expected, lobby.CustomOrder)
+				break
+			}
+		}
+	}
+}
+
+func TestUndoHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+	})
+	lobby.settings.MaxAttempts = 2
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	giveAnswer(t, c, "typo")
+	drainEvents(c)
+
+	// Undoing within the window takes back the last attempt and re-serves the problem
+	if err := UndoHandler(Event{EventUndo, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	user := lobby.userMapping["alice"]
+	if user.questionNumber != 0 || user.attempts != 0 {
+		t.Errorf("expected to be back on the first problem with no attempts, got %+v", user)
+	}
+	events := drainEvents(c)
+	var newProblem NewProblemEvent
+	if len(events) != 1 || json.Unmarshal(events[0].Payload, &newProblem) != nil || newProblem.Problem.Title != "One" {
+		t.Errorf("expected the first problem to be served again, got %v", events)
+	}
+
+	// Only the immediately previous attempt can be undone
+	if err := UndoHandler(Event{EventUndo, nil}, c); err == nil {
+		t.Error("expected a second undo to be rejected")
+	}
+	if user := lobby.userMapping["alice"]; user.attempts != 0 {
+		t.Errorf("expected the second undo to leave attempts alone, got %d", user.attempts)
+	}
+
+	// Running out of attempts can't be undone, or the cap would mean nothing
+	giveAnswer(t, c, "typo once more")
+	giveAnswer(t, c, "typo again")
+	if lobby.userMapping["alice"].questionNumber != 1 {
+		t.Fatal("expected to be moved on after exhausting attempts")
+	}
+	if err := UndoHandler(Event{EventUndo, nil}, c); err == nil {
+		t.Error("expected undoing the last attempt to be rejected")
+	}
+	if user := lobby.userMapping["alice"]; user.questionNumber != 1 {
+		t.Errorf("expected to stay on the second problem, got %d", user.questionNumber)
+	}
+}
+
+func TestUndoHandler_OutsideWindow(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	giveAnswer(t, c, "typo")
+	user := lobby.userMapping["alice"]
+	user.undo.at = time.Now().Add(-UNDO_WINDOW - time.Second)
+	lobby.userMapping["alice"] = user
+
+	if err := UndoHandler(Event{EventUndo, nil}, c); err == nil {
+		t.Error("expected an undo outside the window to be rejected")
+	}
+	if user := lobby.userMapping["alice"]; user.attempts != 1 {
+		t.Errorf("expected the attempt to still count, got %d", user.attempts)
+	}
+}
+
+func TestForceFinishHandler(t *testing.T) {
+	useTempLogsDirectory(t)
+	lobby := newTestLobby(t, []Problem{{Title: "One", Description: "1", Latex: "a"}})
+	owner := addTestClient(lobby, "owner")
+	bob := addTestClient(lobby, "bob")
+	manager := testManagers[lobby]
+
+	if err := ForceFinishHandler(Event{EventForceFinish, nil}, owner); err == nil {
+		t.Error("expected finishing a game that hasn't started to be rejected")
+	}
+	if err := requestStartGame(t, owner, RequestStartGameEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	drainEvents(owner)
+	drainEvents(bob)
+
+	if err := ForceFinishHandler(Event{EventForceFinish, nil}, bob); err == nil {
+		t.Error("expected a non-owner to be unable to finish the game")
+	}
+	if err := ForceFinishHandler(Event{EventForceFinish, nil}, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	if lobby.gameState != Finished {
+		t.Errorf("expected the game to be finished, got %s", lobby.gameState)
+	}
+	if _, ok := manager.lobbies[lobby.id]; ok {
+		t.Error("expected the lobby to be removed from the manager")
+	}
+	for _, c := range []*Client{owner, bob} {
+		if countEvents(drainEvents(c), EventEndGame) != 1 {
+			t.Errorf("expected %s to be told the game ended", c.name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(logsDirectory, lobby.id+".result.json")); err != nil {
+		t.Errorf("expected the results to be saved: %v", err)
+	}
+
+	// The timer was cancelled, so the game can't be finished again
+	if manager.finishGame(lobby, "Game over!") {
+		t.Error("expected a finished game not to be finished again")
+	}
+}
+
+func transferOwnership(t *testing.T, c *Client, name string) error {
+	t.Helper()
+	payload, err := json.Marshal(TransferOwnershipEvent{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TransferOwnershipHandler(Event{EventTransferOwnership, payload}, c)
+}
+
+func TestTransferOwnershipHandler(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if err := transferOwnership(t, alice, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !lobby.isOwner("bob") || lobby.isOwner("alice") {
+		t.Errorf("expected bob to own the lobby, owner is %s", *lobby.owner)
+	}
+	for _, c := range []*Client{alice, bob} {
+		events := drainEvents(c)
+		if countEvents(events, EventOwnerChanged) != 1 {
+			t.Errorf("expected %s to be told about the new owner, got %v", c.name, events)
+		}
+	}
+
+	// alice is no longer the owner, so can't take it back
+	if err := transferOwnership(t, alice, "alice"); err == nil {
+		t.Error("expected a transfer by a non-owner to fail")
+	}
+}
+
+// ownerStatus asks whether the client owns the lobby
+func ownerStatus(t *testing.T, c *Client) OwnerStatusEvent {
+	t.Helper()
+	drainEvents(c)
+	if err := RequestOwnerStatusHandler(Event{EventRequestOwnerStatus, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(c)
+	if len(events) != 1 || events[0].Type != EventOwnerStatus {
+		t.Fatalf("expected an %s event, got %v", EventOwnerStatus, events)
+	}
+	var status OwnerStatusEvent
+	if err := json.Unmarshal(events[0].Payload, &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestRequestOwnerStatusHandler(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if status := ownerStatus(t, alice); status != (OwnerStatusEvent{true, "alice"}) {
+		t.Errorf("expected alice to be told they own the lobby, got %+v", status)
+	}
+	if status := ownerStatus(t, bob); status != (OwnerStatusEvent{false, "alice"}) {
+		t.Errorf("expected bob to be told alice owns the lobby, got %+v", status)
+	}
+
+	if err := transferOwnership(t, alice, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if status := ownerStatus(t, alice); status != (OwnerStatusEvent{false, "bob"}) {
+		t.Errorf("expected alice to be told bob owns the lobby now, got %+v", status)
+	}
+	if status := ownerStatus(t, bob); status != (OwnerStatusEvent{true, "bob"}) {
+		t.Errorf("expected bob to be told they own the lobby now, got %+v", status)
+	}
+}
+
+func TestChangeNameHandler_AnnouncesRenamedOwner(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	payload, err := json.Marshal(ChangeNameEvent{"alicia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ChangeNameHandler(Event{EventChangeName, payload}, alice); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(bob)
+	if countEvents(events, EventOwnerChanged) != 1 {
+		t.Errorf("expected bob to be told the owner is now called alicia, got %v", events)
+	}
+	if status := ownerStatus(t, alice); status != (OwnerStatusEvent{true, "alicia"}) {
+		t.Errorf("expected the renamed owner to still own the lobby, got %+v", status)
+	}
+}
+
+func TestTransferOwnershipHandler_InvalidTarget(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	addTestClient(lobby, "carol")
+	lobby.userMapping["carol"] = User{spectator: true}
+	addTestClient(lobby, "dave")
+	lobby.userMapping["dave"] = User{guest: true}
+
+	for _, name := range []string{"nobody", "carol", "dave"} {
+		if err := transferOwnership(t, alice, name); err == nil {
+			t.Errorf("expected a transfer to %s to fail", name)
+		}
+	}
+	if !lobby.isOwner("alice") {
+		t.Errorf("expected alice to still own the lobby, owner is %s", *lobby.owner)
+	}
+}
+
+func TestGiveAnswerHandler_ProblemPoolExhausted(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+	})
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	for _, answer := range []string{"a", "b"} {
+		if err := giveAnswer(t, alice, answer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if user := lobby.userMapping["alice"]; !user.finished {
+		t.Fatalf("expected alice to be finished, got %+v", user)
+	}
+	if countEvents(drainEvents(bob), EventPlayerFinished) != 1 {
+		t.Error("expected bob to be told alice has finished")
+	}
+	if !lobby.inPlay() {
+		t.Fatal("expected the game to continue while bob is still playing")
+	}
+
+	// Finished players can't go past the end of the pool
+	if err := giveAnswer(t, alice, "b"); err == nil {
+		t.Error("expected answering after finishing to fail")
+	}
+	if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err == nil {
+		t.Error("expected requesting a problem after finishing to fail")
+	}
+
+	// The game ends once every player has finished
+	SkipProblemHandler(Event{EventSkipProblem, nil}, bob)
+	SkipProblemHandler(Event{EventSkipProblem, nil}, bob)
+	if lobby.inPlay() {
+		t.Error("expected the game to end once every player finished")
+	}
+}
+
+// servedCounts has 200 players each answer the first two problems of a ten problem pool,
+// returning how many times each problem was served
+func servedCounts(t *testing.T, weighted bool) []int {
+	problems := make([]Problem, 10)
+	for i := range problems {
+		problems[i] = Problem{Title: fmt.Sprint(i), Latex: "x", Answer: "x"}
+	}
+	lobby := newTestLobby(t, problems)
+	lobby.settings.WeightedSelection = weighted
+	lobby.startGame(time.Now())
+
+	counts := make([]int, len(problems))
+	for i := 0; i < 200; i++ {
+		c := addTestClient(lobby, fmt.Sprintf("player%d", i))
+		for j := 0; j < 2; j++ {
+			counts[c.problemIndex()]++
+			if err := giveAnswer(t, c, "x"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		drainEvents(c)
+	}
+	return counts
+}
+
+// spread is the difference between the most and least served problems
+func spread(counts []int) int {
+	lowest, highest := counts[0], counts[0]
+	for _, count := range counts {
+		if count < lowest {
+			lowest = count
+		}
+		if count > highest {
+			highest = count
+		}
+	}
+	return highest - lowest
+}
+
+func TestWeightedSelection_BalancesExposure(t *testing.T) {
+	unweighted := servedCounts(t, false)
+	weighted := servedCounts(t, true)
+
+	// Without weighting everyone gets the same two problems; with it each problem's fair share is 40 servings
+	if spread(weighted)*4 >= spread(unweighted) {
+		t.Errorf("expected weighting to spread problems more evenly, got %v (weighted) vs %v", weighted, unweighted)
+	}
+}
+
+func TestWeightedSelection_NoRepeats(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+		{Title: "Three", Latex: "c", Answer: "c"},
+	})
+	lobby.settings.WeightedSelection = true
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+	addTestClient(lobby, "bob")
+
+	seen := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		index := c.problemIndex()
+		if seen[index] {
+			t.Fatalf("problem %d was served twice", index)
+		}
+		seen[index] = true
+		giveAnswer(t, c, lobby.getLobbyProblems()[index].Answer)
+	}
+	if !lobby.userMapping["alice"].finished {
+		t.Error("expected alice to finish after every problem in the pool")
+	}
+}
+
+// requestHintCount returns the hint count the client is sent for their current problem
+func requestHintCount(t *testing.T, c *Client) HintCountEvent {
+	t.Helper()
+	if err := RequestHintCountHandler(Event{EventRequestHintCount, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	var count HintCountEvent
+	events := drainEvents(c)
+	if len(events) != 1 || events[0].Type != EventHintCount {
+		t.Fatalf("expected a %s event, got %v", EventHintCount, events)
+	}
+	if err := json.Unmarshal(events[0].Payload, &count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRequestHintCountHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a", Hints: []string{"It's a letter", "It's the first letter"}},
+		{Title: "Two", Latex: "b", Answer: "b"},
+	})
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	if count := requestHintCount(t, c); count != (HintCountEvent{Used: 0, Total: 2}) {
+		t.Errorf("expected 0 of 2 hints used, got %+v", count)
+	}
+
+	if err := RequestHintHandler(Event{EventRequestHint, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(c)
+	var hint HintEvent
+	json.Unmarshal(events[0].Payload, &hint)
+	if hint.Hint != "It's a letter" {
+		t.Errorf("expected the first hint, got %q", hint.Hint)
+	}
+	if count := requestHintCount(t, c); count != (HintCountEvent{Used: 1, Total: 2}) {
+		t.Errorf("expected 1 of 2 hints used, got %+v", count)
+	}
+
+	RequestHintHandler(Event{EventRequestHint, nil}, c)
+	if err := RequestHintHandler(Event{EventRequestHint, nil}, c); err == nil {
+		t.Error("expected asking for more hints than the problem has to fail")
+	}
+	drainEvents(c)
+
+	// The count is for the current problem
+	giveAnswer(t, c, "a")
+	drainEvents(c)
+	if count := requestHintCount(t, c); count != (HintCountEvent{Used: 0, Total: 0}) {
+		t.Errorf("expected no hints on the second problem, got %+v", count)
+	}
+}
+
+func TestRequestHintHandler_HintBudget(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a", Hints: []string{"It's a letter", "It's the first letter"}},
+		{Title: "Two", Latex: "b", Answer: "b", Hints: []string{"It's a letter"}},
+		{Title: "Three", Latex: "c", Answer: "c", Hints: []string{"It's a letter", "It's the third letter"}},
+	})
+	lobby.settings.HintBudget = 2
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	// The first problem's own limit runs out before the budget does
+	if err := RequestHintHandler(Event{EventRequestHint, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	giveAnswer(t, c, "a")
+	if err := RequestHintHandler(Event{EventRequestHint, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := RequestHintHandler(Event{EventRequestHint, nil}, c); err == nil {
+		t.Error("expected the second problem's one hint to be its limit")
+	}
+	giveAnswer(t, c, "b")
+	drainEvents(c)
+
+	// The budget runs out before the third problem's hints do
+	if err := RequestHintHandler(Event{EventRequestHint, nil}, c); err == nil {
+		t.Error("expected hints to be refused once the game's budget is used up")
+	}
+	events := drainEvents(c)
+	var message ErrorEvent
+	if len(events) != 1 || events[0].Type != EventError {
+		t.Fatalf("expected an error to be sent, got %v", events)
+	} else if json.Unmarshal(events[0].Payload, &message); !strings.Contains(message.Message, "all 2 of your hints") {
+		t.Errorf("expected to be told the budget is used up, got %q", message.Message)
+	}
+	if count := requestHintCount(t, c); count != (HintCountEvent{Used: 0, Total: 2}) {
+		t.Errorf("expected the third problem's hints to be untouched, got %+v", count)
+	}
+}
+
+func TestGetNewProblem_HidesHints(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a", Hints: []string{"secret"}}})
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	if problem := c.getNewProblem().Problem; len(problem.Hints) != 0 {
+		t.Errorf("expected hints not to be sent with the problem, got %v", problem.Hints)
+	}
+}
+
+func TestStartGameHandler_ConcurrentStarts(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	problems := Problems{Problems: []Problem{{Title: "One", Description: "a", Latex: "a", Answer: "a"}}}
+
+	// The owner starts the game from two tabs at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload, _ := json.Marshal(RequestStartGameEvent{Duration: 3600, UseCustomProblems: true, CustomProblems: problems})
+			errs <- StartGameHandler(Event{EventStartGameOwner, payload}, owner)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected starting an already started game to be benign, got %v", err)
+		}
+	}
+	events := drainEvents(owner)
+	if count := countEvents(events, EventStartGame); count != 1 {
+		t.Errorf("expected the game to start exactly once, got %d start events", count)
+	}
+	if count := countEvents(events, EventNewProblem); count != 1 {
+		t.Errorf("expected exactly one first problem, got %d", count)
+	}
+	if !lobby.inPlay() {
+		t.Error("expected the game to be in play")
+	}
+}
+
+// getPlayers returns the roster the client is sent
+func getPlayers(t *testing.T, c *Client) []PlayerInfo {
+	t.Helper()
+	drainEvents(c)
+	if err := GetPlayersHandler(Event{EventGetPlayers, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(c)
+	if len(events) != 1 || events[0].Type != EventPlayers {
+		t.Fatalf("expected a %s event, got %v", EventPlayers, events)
+	}
+	var players PlayersEvent
+	if err := json.Unmarshal(events[0].Payload, &players); err != nil {
+		t.Fatal(err)
+	}
+	return players.Players
+}
+
+func TestGetPlayersHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "abc", Answer: "abc"}})
+	alice := addTestClient(lobby, "alice")
+	addTestClient(lobby, "alice") // a second tab
+	bob := addTestClient(lobby, "bob")
+	addTestClient(lobby, "carol")
+	lobby.userMapping["carol"] = User{spectator: true}
+	// dave has logged in, but isn't connected
+	lobby.userMapping["dave"] = User{}
+
+	payload, _ := json.Marshal(SetReadyEvent{true})
+	if err := SetReadyHandler(Event{EventSetReady, payload}, bob); err != nil {
+		t.Fatal(err)
+	}
+	if countEvents(drainEvents(alice), EventPlayerReady) != 1 {
+		t.Error("expected everyone to be told bob is ready")
+	}
+
+	expected := []PlayerInfo{
+		{Name: "alice"},
+		{Name: "bob", Ready: true},
+		{Name: "carol", Spectator: true},
+	}
+	players := getPlayers(t, alice)
+	if len(players) != len(expected) {
+		t.Fatalf("expected %d players, got %+v", len(expected), players)
+	}
+	for i, player := range players {
+		if player.Name != expected[i].Name || player.Ready != expected[i].Ready ||
+			player.Spectator != expected[i].Spectator || player.Score != nil {
+			t.Errorf("expected %+v, got %+v", expected[i], player)
+		}
+	}
+
+	// Scores are included once the game is in play
+	lobby.startGame(time.Now())
+	giveAnswer(t, bob, "abc")
+	for _, player := range getPlayers(t, alice) {
+		if player.Spectator {
+			if player.Score != nil {
+				t.Errorf("expected spectators not to have a score, got %d", *player.Score)
+			}
+		} else if player.Score == nil || *player.Score != lobby.userMapping[player.Name].score {
+			t.Errorf("expected %s's score to be included, got %v", player.Name, player.Score)
+		}
+	}
+
+	lobby.settings.HideScoreboard = true
+	for _, player := range getPlayers(t, alice) {
+		if player.Score != nil {
+			t.Errorf("expected scores to be hidden, got %s: %d", player.Name, *player.Score)
+		}
+	}
+}
+
+func TestGiveAnswerHandler_Accuracy(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+		{Title: "Three", Latex: "c", Answer: "c"},
+	})
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	if accuracy := lobby.userMapping["alice"].accuracy(); accuracy != 0 {
+		t.Errorf("expected 0 accuracy before answering, got %v", accuracy)
+	}
+
+	for _, answer := range []string{"x", "a", "y", "z", "b"} {
+		giveAnswer(t, alice, answer)
+	}
+	if accuracy := lobby.userMapping["alice"].accuracy(); accuracy != 0.4 {
+		t.Errorf("expected 2 of 5 answers to be correct, got %v", accuracy)
+	}
+
+	var update NewScoreUpdateEvent
+	for _, e := range drainEvents(bob) {
+		if e.Type == EventNewScoreUpdate {
+			json.Unmarshal(e.Payload, &update)
+		}
+	}
+	if update.Name != "alice" || update.Accuracy != 0.4 {
+		t.Errorf("expected alice's accuracy in the scoreboard update, got %+v", update)
+	}
+	if standings := lobby.standings(); standings[0].Accuracy != 0.4 {
+		t.Errorf("expected accuracy in the standings, got %+v", standings)
+	}
+}
+
+func TestGiveAnswerHandler_MaxAnswerLength(t *testing.T) {
+	previous := config
+	config.MaxAnswerLength = 8
+	t.Cleanup(func() { config = previous })
+
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc"},
+		{Title: "Two", Latex: "def", Answer: "def"},
+	})
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	if err := giveAnswer(t, c, strings.Repeat("x", 9)); err == nil {
+		t.Error("expected an oversized answer to be rejected")
+	}
+	events := drainEvents(c)
+	if countEvents(events, EventError) != 1 || countEvents(events, EventWrongAnswer) != 0 {
+		t.Errorf("expected an error without the answer being judged, got %v", events)
+	}
+	if user := lobby.userMapping["alice"]; user.attempts != 0 || user.totalAnswers != 0 {
+		t.Errorf("expected an oversized answer not to count as an attempt, got %+v", user)
+	}
+
+	if err := giveAnswer(t, c, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if user := lobby.userMapping["alice"]; user.score == 0 {
+		t.Error("expected an answer within the limit to be judged")
+	}
+}
+
+func changeName(t *testing.T, c *Client, name string) error {
+	t.Helper()
+	payload, err := json.Marshal(ChangeNameEvent{name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ChangeNameHandler(Event{EventChangeName, payload}, c)
+}
+
+func TestChangeNameHandler(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "ownr")
+	bob := addTestClient(lobby, "bob")
+	lobby.userMapping["ownr"] = User{password: "hash"}
+
+	if err := changeName(t, owner, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lobby.userMapping["ownr"]; ok {
+		t.Error("expected the old name to be removed")
+	}
+	if user := lobby.userMapping["owner"]; user.password != "hash" {
+		t.Errorf("expected the user to keep their password, got %+v", user)
+	}
+	if owner.name != "owner" || !lobby.isOwner("owner") {
+		t.Errorf("expected the client to be renamed and keep ownership, got %s", owner.name)
+	}
+	var changed NameChangedEvent
+	for _, e := range drainEvents(bob) {
+		if e.Type == EventNameChanged {
+			json.Unmarshal(e.Payload, &changed)
+		}
+	}
+	if changed != (NameChangedEvent{"ownr", "owner"}) {
+		t.Errorf("expected everyone to be told about the rename, got %+v", changed)
+	}
+}
+
+func TestChangeNameHandler_Rejected(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	addTestClient(lobby, "bob")
+
+	for _, name := range []string{"bob", "", " alice ", strings.Repeat("a", MAX_USERNAME_LENGTH+1)} {
+		if err := changeName(t, alice, name); err == nil {
+			t.Errorf("expected renaming to %q to be rejected", name)
+		}
+	}
+
+	lobby.startGame(time.Now())
+	if err := changeName(t, alice, "alicia"); err == nil {
+		t.Error("expected renaming during the game to be rejected")
+	}
+	if alice.name != "alice" {
+		t.Errorf("expected alice to keep their name, got %s", alice.name)
+	}
+}
+
+func toggleSpectating(c *Client) error {
+	return SpectateToggleHandler(Event{EventSpectateToggle, nil}, c)
+}
+
+func TestSpectateToggleHandler_WaitingForPlayers(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	lobby.maxPlayers = 2
+	lobby.userMapping["bob"] = User{spectator: true}
+
+	if err := toggleSpectating(owner); err == nil {
+		t.Error("expected the owner to be unable to spectate")
+	}
+	if err := toggleSpectating(bob); err == nil {
+		t.Error("expected a spectator to be unable to join a full lobby")
+	}
+	if !lobby.userMapping["bob"].spectator {
+		t.Error("expected bob to still be spectating")
+	}
+
+	lobby.userMapping["alice"] = User{ready: true}
+	drainEvents(owner)
+	if err := toggleSpectating(alice); err != nil {
+		t.Fatal(err)
+	}
+	if user := lobby.userMapping["alice"]; !user.spectator || user.ready {
+		t.Errorf("expected alice to be an unready spectator, got %+v", user)
+	}
+	if countEvents(drainEvents(owner), EventPlayers) != 1 {
+		t.Error("expected the roster to be broadcast")
+	}
+
+	// There's room for bob now
+	if err := toggleSpectating(bob); err != nil {
+		t.Fatal(err)
+	}
+	if lobby.userMapping["bob"].spectator {
+		t.Error("expected bob to be playing")
+	}
+}
+
+func TestSpectateToggleHandler_InPlay(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "abc", Answer: "abc"}})
+	owner := addTestClient(lobby, "owner")
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	lobby.userMapping["bob"] = User{spectator: true}
+	lobby.startGame(time.Now())
+
+	if err := toggleSpectating(bob); err == nil {
+		t.Error("expected a spectator to be unable to join once the game has started")
+	}
+
+	lobby.userMapping["owner"] = User{finished: true}
+	if err := toggleSpectating(alice); err != nil {
+		t.Fatal(err)
+	}
+	if !lobby.userMapping["alice"].spectator {
+		t.Error("expected alice to be spectating")
+	}
+	// alice was the only player still going
+	if lobby.gameState != Finished {
+		t.Error("expected the game to end once every remaining player had finished")
+	}
+	if err := toggleSpectating(owner); err == nil {
+		t.Error("expected switching to be rejected once the game is over")
+	}
+}
+
+func TestGiveAnswerHandler_Preview(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc"},
+		{Title: "Two", Latex: "def", Answer: "def"},
+	})
+	alice := addTestClient(lobby, "alice")
+	lobby.startGame(time.Now())
+	lobby.settings.PreviewSeconds = 5
+
+	if err := alice.sendClientProblem(); err != nil {
+		t.Fatal(err)
+	}
+	var problem NewProblemEvent
+	json.Unmarshal(drainEvents(alice)[0].Payload, &problem)
+	if problem.AnswerableAt == nil || time.Until(*problem.AnswerableAt) <= 0 {
+		t.Fatalf("expected the problem to say when its preview ends, got %v", problem.AnswerableAt)
+	}
+
+	if err := giveAnswer(t, alice, "abc"); err == nil {
+		t.Error("expected answers during the preview to be rejected")
+	}
+	if user := lobby.userMapping["alice"]; user.score != 0 || user.totalAnswers != 0 {
+		t.Errorf("expected the rejected answer not to count, got %+v", user)
+	}
+
+	// Asking for the problem again doesn't restart the preview
+	user := lobby.userMapping["alice"]
+	user.answerableAt = time.Now().Add(-time.Second)
+	lobby.userMapping["alice"] = user
+	alice.sendClientProblem()
+	if err := giveAnswer(t, alice, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if lobby.userMapping["alice"].score == 0 {
+		t.Error("expected the answer to be accepted after the preview")
+	}
+
+	// The next problem gets its own preview
+	if err := giveAnswer(t, alice, "def"); err == nil {
+		t.Error("expected answers during the next problem's preview to be rejected")
+	}
+}
+
+func TestRequestProblemHandler_Idempotent(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc"},
+		{Title: "Two", Latex: "def", Answer: "def"},
+	})
+	alice := addTestClient(lobby, "alice")
+	lobby.startGame(time.Now())
+
+	// e.g. a double click
+	for i := 0; i < 2; i++ {
+		if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := drainEvents(alice)
+	if len(events) != 2 {
+		t.Fatalf("expected the problem to be sent twice, got %v", events)
+	}
+	for _, e := range events {
+		var problem NewProblemEvent
+		json.Unmarshal(e.Payload, &problem)
+		if problem.Problem.Title != "One" {
+			t.Errorf("expected the current problem to be resent, got %s", problem.Problem.Title)
+		}
+	}
+	if user := lobby.userMapping["alice"]; user.questionNumber != 0 {
+		t.Errorf("expected requesting the problem not to skip it, got question %d", user.questionNumber)
+	}
+
+	if err := SkipProblemHandler(Event{EventSkipProblem, nil}, alice); err != nil {
+		t.Fatal(err)
+	}
+	var problem NewProblemEvent
+	json.Unmarshal(drainEvents(alice)[0].Payload, &problem)
+	if problem.Problem.Title != "Two" || lobby.userMapping["alice"].questionNumber != 1 {
+		t.Errorf("expected skipping to move on to the next problem, got %s", problem.Problem.Title)
+	}
+}
+
+func TestRequestProblemHandler_ManualAdvanceServesOneAtATime(t *testing.T) {
+	for _, weighted := range []bool{false, true} {
+		lobby := newTestLobby(t, []Problem{
+			{Title: "One", Latex: "a", Answer: "a"},
+			{Title: "Two", Latex: "b", Answer: "b"},
+			{Title: "Three", Latex: "c", Answer: "c"},
+		})
+		lobby.settings.AdvanceMode = AdvanceManual
+		lobby.settings.WeightedSelection = weighted
+		lobby.startGame(time.Now())
+		alice := addTestClient(lobby, "alice")
+
+		// Asking again before answering gets the same problem, rather than another one to hoard
+		var titles []string
+		for i := 0; i < 3; i++ {
+			if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err != nil {
+				t.Fatal(err)
+			}
+			var problem NewProblemEvent
+			json.Unmarshal(drainEvents(alice)[0].Payload, &problem)
+			titles = append(titles, problem.Problem.Title)
+		}
+		if titles[0] != titles[1] || titles[1] != titles[2] {
+			t.Errorf("weighted %v: expected every request to get the same problem, got %v", weighted, titles)
+		}
+		user := lobby.userMapping["alice"]
+		if user.questionNumber != 0 || (weighted && len(user.order) != 1) {
+			t.Errorf("weighted %v: expected only one problem to be outstanding, got question %d of %v", weighted, user.questionNumber, user.order)
+		}
+	}
+}
+
+func TestStartGameHandler_MaxGameDuration(t *testing.T) {
+	previous := config
+	config.MaxGameDuration = 50 * time.Millisecond
+	t.Cleanup(func() { config = previous })
+
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "abc", Answer: "abc"}})
+	owner := addTestClient(lobby, "owner")
+	// The time limit alone would let the game run for an hour
+	if err := requestStartGame(t, owner, RequestStartGameEvent{Duration: 3600}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lobby is removed once the game has been finished
+	running := func() bool {
+		_, ok := testManagers[lobby].getLobby(lobby.id)
+		return ok
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for running() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if running() {
+		t.Fatal("expected the game to be finished at the maximum game duration")
+	}
+	var end EndGameEvent
+	for _, e := range drainEvents(owner) {
+		if e.Type == EventEndGame {
+			json.Unmarshal(e.Payload, &end)
+		}
+	}
+	if !strings.Contains(end.Message, "maximum") {
+		t.Errorf("expected players to be told the game hit the maximum length, got %q", end.Message)
+	}
+}
+
+func TestGiveAnswerHandler_AdvanceMode(t *testing.T) {
+	for _, mode := range []string{AdvanceAuto, AdvanceManual} {
+		lobby := newTestLobby(t, nil)
+		owner := addTestClient(lobby, "owner")
+		custom := Problems{Problems: []Problem{
+			{Title: "One", Description: "d", Latex: "abc", Answer: "abc"},
+			{Title: "Two", Description: "d", Latex: "def", Answer: "def"},
+		}}
+		req := RequestStartGameEvent{UseCustomProblems: true, CustomProblems: custom, GameSettings: GameSettings{AdvanceMode: mode}}
+		if err := requestStartGame(t, owner, req); err != nil {
+			t.Fatal(err)
+		}
+		drainEvents(owner)
+
+		if err := giveAnswer(t, owner, "abc"); err != nil {
+			t.Fatal(err)
+		}
+		served := countEvents(drainEvents(owner), EventNewProblem)
+		if mode == AdvanceAuto && served != 1 {
+			t.Errorf("%s: expected the next problem to be served after a correct answer, got %d", mode, served)
+		} else if mode == AdvanceManual && served != 0 {
+			t.Errorf("%s: expected no problem until one is requested, got %d", mode, served)
+		}
+
+		if mode == AdvanceManual {
+			if err := RequestProblemHandler(Event{EventRequestProblem, nil}, owner); err != nil {
+				t.Fatal(err)
+			}
+			var problem NewProblemEvent
+			json.Unmarshal(drainEvents(owner)[0].Payload, &problem)
+			if problem.Problem.Title != "Two" {
+				t.Errorf("%s: expected the next problem once requested, got %s", mode, problem.Problem.Title)
+			}
+		}
+	}
+
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	if err := requestStartGame(t, owner, RequestStartGameEvent{GameSettings: GameSettings{AdvanceMode: "sometimes"}}); err == nil {
+		t.Error("expected an unknown advance mode to be rejected")
+	}
+}
+
+func TestRequestTimeRemainingHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	c := addTestClient(lobby, "alice")
+	if err := RequestTimeRemainingHandler(Event{EventRequestTimeRemaining, nil}, c); err == nil {
+		t.Error("expected asking for the time before the game starts to fail")
+	}
+
+	lobby.startGame(time.Now())
+	lobby.timeLimit = 600
+	startTime := time.Now().Add(-100 * time.Second)
+	lobby.startTime = &startTime
+	if err := RequestTimeRemainingHandler(Event{EventRequestTimeRemaining, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(c)
+	if len(events) != 1 || events[0].Type != EventTimeRemaining {
+		t.Fatalf("expected only a time_remaining reply, got %v", events)
+	}
+	var remaining TimeRemainingEvent
+	json.Unmarshal(events[0].Payload, &remaining)
+	if remaining.SecondsLeft != 500 {
+		t.Errorf("expected 500 seconds left, got %d", remaining.SecondsLeft)
+	}
+
+	// Once the time is up there's nothing left, rather than a negative time
+	startTime = time.Now().Add(-700 * time.Second)
+	RequestTimeRemainingHandler(Event{EventRequestTimeRemaining, nil}, c)
+	json.Unmarshal(drainEvents(c)[0].Payload, &remaining)
+	if remaining.SecondsLeft != 0 {
+		t.Errorf("expected no time left, got %d", remaining.SecondsLeft)
+	}
+}
+
+func TestGiveAnswerHandler_WarmupSeconds(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	lobby.settings.WrongAnswerPenalty = 3
+	lobby.settings.WarmupSeconds = 60
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+	user := lobby.userMapping["alice"]
+	user.score = 10
+	lobby.userMapping["alice"] = user
+
+	giveAnswer(t, c, "wrong")
+	if user := lobby.userMapping["alice"]; user.score != 10 || user.attempts != 0 {
+		t.Errorf("expected no penalty during the warmup, got a score of %d after %d attempts", user.score, user.attempts)
+	}
+
+	startTime := time.Now().Add(-2 * time.Minute)
+	lobby.startTime = &startTime
+	giveAnswer(t, c, "still wrong")
+	if user := lobby.userMapping["alice"]; user.score != 7 || user.attempts != 1 {
+		t.Errorf("expected a penalty after the warmup, got a score of %d after %d attempts", user.score, user.attempts)
+	}
+
+	// Undoing the answer refunds the penalty
+	if err := UndoHandler(Event{EventUndo, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	if score := lobby.userMapping["alice"].score; score != 10 {
+		t.Errorf("expected undo to refund the penalty, got a score of %d", score)
+	}
+}
+
+func TestGiveAnswerHandler_DuplicateSubmissions(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}, {Title: "Two", Latex: "b", Answer: "b"}})
+	lobby.settings.WrongAnswerPenalty = 1
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+	user := lobby.userMapping["alice"]
+	user.score = 10
+	lobby.userMapping["alice"] = user
+
+	// A double submission only counts once
+	giveAnswer(t, c, "x")
+	giveAnswer(t, c, "x")
+	if user := lobby.userMapping["alice"]; user.score != 9 || user.attempts != 1 || user.totalAnswers != 1 {
+		t.Errorf("expected the repeated answer to be ignored, got a score of %d after %d answers", user.score, user.totalAnswers)
+	}
+
+	// Different answers both count
+	giveAnswer(t, c, "y")
+	if user := lobby.userMapping["alice"]; user.score != 8 || user.attempts != 2 || user.totalAnswers != 2 {
+		t.Errorf("expected a different answer to count, got a score of %d after %d answers", user.score, user.totalAnswers)
+	}
+
+	// As does the same answer given again once the window's passed
+	user = lobby.userMapping["alice"]
+	user.lastAnswer.at = time.Now().Add(-DUPLICATE_ANSWER_WINDOW)
+	lobby.userMapping["alice"] = user
+	giveAnswer(t, c, "y")
+	if user := lobby.userMapping["alice"]; user.score != 7 || user.attempts != 3 || user.totalAnswers != 3 {
+		t.Errorf("expected the answer to count after the window, got a score of %d after %d answers", user.score, user.totalAnswers)
+	}
+}
+
+func TestGiveAnswerHandler_DuplicateCorrectSubmission(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}, {Title: "Two", Latex: "b", Answer: "b"}})
+	lobby.settings.WrongAnswerPenalty = 1
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	// The repeat arrives once the first has moved them on, but isn't taken as a wrong answer to the next problem
+	giveAnswer(t, c, "a")
+	giveAnswer(t, c, "a")
+	user := lobby.userMapping["alice"]
+	if user.questionNumber != 1 || user.attempts != 0 || user.totalAnswers != 1 || user.score != 1 {
+		t.Errorf("expected the repeated answer to be ignored, got %d answers, %d attempts and a score of %d on problem %d",
+			user.totalAnswers, user.attempts, user.score, user.questionNumber)
+	}
+}
+
+func TestGiveAnswerHandler_DifficultyPenalties(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a", Difficulty: "Easy"},
+		{Title: "Two", Latex: "b", Answer: "b", Difficulty: "Hard"},
+		{Title: "Three", Latex: "c", Answer: "c"},
+	})
+	settings := GameSettings{WrongAnswerPenalty: 2, DifficultyPenalties: map[string]int{"easy": 1, "HARD": 5}}
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+	lobby.settings = settings
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	user := lobby.userMapping["alice"]
+	user.score = 20
+	lobby.userMapping["alice"] = user
+
+	// Each problem's wrong answer costs what its difficulty does, or the flat penalty if it isn't listed
+	for _, step := range []struct {
+		wrong, right string
+		penalty      int
+	}{{"x", "a", 1}, {"x", "b", 5}, {"x", "c", 2}} {
+		before := lobby.userMapping["alice"].score
+		giveAnswer(t, alice, step.wrong)
+		if lost := before - lobby.userMapping["alice"].score; lost != step.penalty {
+			t.Errorf("expected a wrong answer to %q to cost %d, but it cost %d", step.right, step.penalty, lost)
+		}
+		if err := giveAnswer(t, alice, step.right); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	settings = GameSettings{DifficultyPenalties: map[string]int{"hard": -1}}
+	if err := settings.validate(); err == nil {
+		t.Error("expected a negative penalty to be rejected")
+	}
+}
+
+func TestGiveAnswerHandler_WarmupFirstProblem(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+	})
+	lobby.settings.WrongAnswerPenalty = 1
+	lobby.settings.WarmupFirstProblem = true
+	lobby.startGame(time.Now())
+	c := addTestClient(lobby, "alice")
+
+	giveAnswer(t, c, "wrong")
+	giveAnswer(t, c, "a")
+	scored := lobby.userMapping["alice"].score
+	if scored != 1 {
+		t.Fatalf("expected no penalty on the first problem, got a score of %d", scored)
+	}
+
+	giveAnswer(t, c, "wrong")
+	if score := lobby.userMapping["alice"].score; score != scored-1 {
+		t.Errorf("expected a penalty on the second problem, got a score of %d", score)
+	}
+}
+
+// requestScoreboard returns the standings sent to the client on request
+func requestScoreboard(t *testing.T, c *Client) []Standing {
+	t.Helper()
+	if err := RequestScoreboardHandler(Event{EventRequestScoreboard, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(c)
+	if len(events) != 1 || events[0].Type != EventScoreboard {
+		t.Fatalf("expected only a scoreboard reply, got %v", events)
+	}
+	var scoreboard ScoreboardEvent
+	if err := json.Unmarshal(events[0].Payload, &scoreboard); err != nil {
+		t.Fatal(err)
+	}
+	return scoreboard.Standings
+}
+
+func TestRequestScoreboardHandler(t *testing.T) {
+	for _, hidden := range []bool{false, true} {
+		lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+		lobby.settings.HideScoreboard = hidden
+		lobby.startGame(time.Now())
+		alice := addTestClient(lobby, "alice")
+		addTestClient(lobby, "bob")
+		lobby.userMapping["alice"] = User{score: 3}
+		lobby.userMapping["bob"] = User{score: 5}
+
+		expected := []Standing{{"bob", 5, 0, 0}, {"alice", 3, 0, 0}}
+		if hidden {
+			expected = []Standing{{"alice", 3, 0, 0}}
+		}
+		if standings := requestScoreboard(t, alice); !reflect.DeepEqual(standings, expected) {
+			t.Errorf("hidden %v: expected %+v, got %+v", hidden, expected, standings)
+		}
+
+		// Everything is revealed once the game is over
+		lobby.gameState = Finished
+		expected = []Standing{{"bob", 5, 0, 0}, {"alice", 3, 0, 0}}
+		if standings := requestScoreboard(t, alice); !reflect.DeepEqual(standings, expected) {
+			t.Errorf("hidden %v: expected %+v after the game, got %+v", hidden, expected, standings)
+		}
+	}
+}
+
+// requestPlayerRank asks for the client's rank, returning their reply
+func requestPlayerRank(t *testing.T, c *Client) (PlayerRankEvent, error) {
+	t.Helper()
+	err := RequestPlayerRankHandler(Event{EventRequestPlayerRank, nil}, c)
+	var rank PlayerRankEvent
+	for _, event := range drainEvents(c) {
+		if event.Type == EventPlayerRank {
+			if err := json.Unmarshal(event.Payload, &rank); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return rank, err
+}
+
+func TestRequestPlayerRankHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	startTime := time.Now().Add(-time.Hour)
+	lobby.startGame(startTime)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	carol := addTestClient(lobby, "carol")
+	lobby.userMapping["alice"] = User{score: 3, answered: 3, totalAnswers: 5}
+	lobby.userMapping["bob"] = User{score: 5, answered: 5, totalAnswers: 5}
+	lobby.userMapping["carol"] = User{score: 3, answered: 3, totalAnswers: 3}
+
+	if rank, err := requestPlayerRank(t, bob); err != nil || rank != (PlayerRankEvent{1, 3}) {
+		t.Errorf("expected bob to be 1st of 3, got %+v (%v)", rank, err)
+	}
+	// Without a tie-break rule, alice and carol share 2nd place
+	for _, c := range []*Client{alice, carol} {
+		if rank, err := requestPlayerRank(t, c); err != nil || rank != (PlayerRankEvent{2, 3}) {
+			t.Errorf("expected %s to share 2nd of 3, got %+v (%v)", c.name, rank, err)
+		}
+	}
+
+	// Carol took fewer attempts, so breaks the tie
+	lobby.settings.TieBreak = TieBreakAttempts
+	if rank, err := requestPlayerRank(t, carol); err != nil || rank != (PlayerRankEvent{2, 3}) {
+		t.Errorf("expected carol to be 2nd of 3, got %+v (%v)", rank, err)
+	}
+	if rank, err := requestPlayerRank(t, alice); err != nil || rank != (PlayerRankEvent{3, 3}) {
+		t.Errorf("expected alice to be 3rd of 3, got %+v (%v)", rank, err)
+	}
+
+	// The rank follows the current standings
+	lobby.userMapping["alice"] = User{score: 6, answered: 6, totalAnswers: 8}
+	if rank, err := requestPlayerRank(t, alice); err != nil || rank != (PlayerRankEvent{1, 3}) {
+		t.Errorf("expected alice to be 1st of 3 after scoring, got %+v (%v)", rank, err)
+	}
+}
+
+func TestRequestPlayerRankHandler_HiddenScoreboard(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	lobby.settings.HideScoreboard = true
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	addTestClient(lobby, "bob")
+	lobby.userMapping["alice"] = User{score: 3}
+	lobby.userMapping["bob"] = User{score: 5}
+
+	if rank, err := requestPlayerRank(t, alice); err == nil {
+		t.Errorf("expected ranks to be hidden during the game, got %+v", rank)
+	}
+
+	// Everything is revealed once the game is over
+	lobby.gameState = Finished
+	if rank, err := requestPlayerRank(t, alice); err != nil || rank != (PlayerRankEvent{2, 2}) {
+		t.Errorf("expected alice to be 2nd of 2 after the game, got %+v (%v)", rank, err)
+	}
+}
+
+func TestRequestCorrectCountHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+		{Title: "Three", Latex: "c", Answer: "c"},
+	})
+	lobby.settings.WrongAnswerPenalty = 1
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+
+	// Wrong, right, wrong, wrong, right (wrong answers are reported as errors)
+	for _, answer := range []string{"x", "a", "x", "y", "b"} {
+		giveAnswer(t, alice, answer)
+	}
+	drainEvents(alice)
+
+	if err := RequestCorrectCountHandler(Event{EventRequestCorrectCount, nil}, alice); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(alice)
+	var count CorrectCountEvent
+	if len(events) != 1 || events[0].Type != EventCorrectCount {
+		t.Fatalf("expected only a correct count reply, got %v", events)
+	} else if err := json.Unmarshal(events[0].Payload, &count); err != nil {
+		t.Fatal(err)
+	} else if count.Correct != 2 {
+		t.Errorf("expected 2 correct answers, got %d", count.Correct)
+	}
+
+	// The scoreboard shows the count alongside the score
+	standings := requestScoreboard(t, alice)
+	if len(standings) != 1 || standings[0].Correct != 2 || standings[0].Score != lobby.userMapping["alice"].score {
+		t.Errorf("expected alice's standing to show 2 correct answers, got %+v", standings)
+	}
+}
+
+func TestSkipProblemHandler_RequeueSkipped(t *testing.T) {
+	for _, requeue := range []bool{false, true} {
+		lobby := newTestLobby(t, []Problem{
+			{Title: "One", Latex: "a", Answer: "a"},
+			{Title: "Two", Latex: "b", Answer: "b"},
+			{Title: "Three", Latex: "c", Answer: "c"},
+		})
+		lobby.settings.RequeueSkipped = requeue
+		lobby.startGame(time.Now())
+		alice := addTestClient(lobby, "alice")
+		addTestClient(lobby, "bob")
+
+		// served returns the titles of the problems sent to alice since last asked
+		served := func() []string {
+			titles := make([]string, 0)
+			for _, event := range drainEvents(alice) {
+				if event.Type == EventNewProblem {
+					var problem NewProblemEvent
+					json.Unmarshal(event.Payload, &problem)
+					titles = append(titles, problem.Problem.Title)
+				}
+			}
+			return titles
+		}
+		skip := func() {
+			if err := SkipProblemHandler(Event{EventSkipProblem, nil}, alice); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// alice skips One, solves Two, then skips Three
+		skip()
+		if err := giveAnswer(t, alice, "b"); err != nil {
+			t.Fatal(err)
+		}
+		skip()
+		titles := served()
+		if !requeue {
+			if !reflect.DeepEqual(titles, []string{"Two", "Three"}) || !lobby.userMapping["alice"].finished {
+				t.Errorf("expected alice to finish without seeing skipped problems again, got %v", titles)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(titles, []string{"Two", "Three", "One"}) || lobby.userMapping["alice"].finished {
+			t.Fatalf("expected One to be served again after the rest, got %v", titles)
+		}
+
+		// Their second go at One counts, and Three comes back after it
+		if err := giveAnswer(t, alice, "a"); err != nil {
+			t.Fatal(err)
+		}
+		if user := lobby.userMapping["alice"]; user.answered != 2 {
+			t.Errorf("expected solving a requeued problem to count, got %d answered", user.answered)
+		}
+		if titles := served(); !reflect.DeepEqual(titles, []string{"Three"}) {
+			t.Fatalf("expected Three to be served again last, got %v", titles)
+		}
+		// Problems skipped a second time aren't served again
+		skip()
+		if titles := served(); len(titles) != 0 || !lobby.userMapping["alice"].finished {
+			t.Errorf("expected alice to finish after skipping Three again, got %v", titles)
+		}
+	}
+}
+
+func TestRequestElapsedBreakdownHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+		{Title: "Three", Latex: "c", Answer: "c"},
+	})
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	// alice takes 30 seconds to solve the first problem, then gives up on the second after 90
+	for _, spent := range []time.Duration{30 * time.Second, 90 * time.Second} {
+		user := lobby.userMapping["alice"]
+		user.answerableAt = time.Now().Add(-spent)
+		lobby.userMapping["alice"] = user
+		if spent == 30*time.Second {
+			if err := giveAnswer(t, alice, "a"); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := SkipProblemHandler(Event{EventSkipProblem, nil}, alice); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drainEvents(alice)
+
+	if err := RequestElapsedBreakdownHandler(Event{EventRequestElapsedBreakdown, nil}, alice); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(alice)
+	var breakdown ElapsedBreakdownEvent
+	if len(events) != 1 || events[0].Type != EventElapsedBreakdown {
+		t.Fatalf("expected only the breakdown to be sent, got %v", events)
+	} else if err := json.Unmarshal(events[0].Payload, &breakdown); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ProblemTime{{0, "One", 30, true}, {1, "Two", 90, false}}
+	if len(breakdown.Problems) != len(expected) {
+		t.Fatalf("expected the breakdown %+v, got %+v", expected, breakdown.Problems)
+	}
+	for i, timing := range breakdown.Problems {
+		want := expected[i]
+		if timing.Number != want.Number || timing.Title != want.Title || timing.Solved != want.Solved ||
+			math.Abs(timing.Seconds-want.Seconds) > 1 {
+			t.Errorf("expected problem %d to take %+v, got %+v", i, want, timing)
+		}
+	}
+
+	// Only the player's own times are sent
+	drainEvents(bob)
+	if err := RequestElapsedBreakdownHandler(Event{EventRequestElapsedBreakdown, nil}, bob); err != nil {
+		t.Fatal(err)
+	}
+	if events := drainEvents(bob); len(events) != 1 || string(events[0].Payload) != `{"problems":[]}` {
+		t.Errorf("expected bob's breakdown to be empty, got %v", events)
+	}
+}
+
+func TestHandlers_RejectedOutsideTheGame(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	alice := addTestClient(lobby, "alice")
+
+	for _, state := range []GameState{WaitingForPlayers, Finished} {
+		lobby.gameState = state
+		expected := "the game hasn't started yet"
+		if state == Finished {
+			expected = "the game is over"
+		}
+		if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err == nil {
+			t.Errorf("%s: expected requesting a problem to be rejected", state)
+		}
+		if err := giveAnswer(t, alice, "a"); err == nil {
+			t.Errorf("%s: expected answering to be rejected", state)
+		}
+		events := drainEvents(alice)
+		if len(events) != 2 {
+			t.Fatalf("%s: expected only errors, got %v", state, events)
+		}
+		for _, event := range events {
+			var errorEvent ErrorEvent
+			if err := json.Unmarshal(event.Payload, &errorEvent); err != nil || event.Type != EventError {
+				t.Errorf("%s: expected an error, got %v", state, event)
+			} else if errorEvent.Message != expected {
+				t.Errorf("%s: expected %q, got %q", state, expected, errorEvent.Message)
+			}
+		}
+		if user := lobby.userMapping["alice"]; !reflect.DeepEqual(user, User{}) {
+			t.Errorf("%s: expected alice's progress to be untouched, got %+v", state, user)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// FEED_BUFFER_SIZE is how many events a feed can fall behind by before events are dropped for it
+const FEED_BUFFER_SIZE = 32
+
+// subscribeFeed registers a new spectator feed for the lobby's scoreboard and progress updates
+func (lobby *Lobby) subscribeFeed() chan Event {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	feed := make(chan Event, FEED_BUFFER_SIZE)
+	lobby.feeds[feed] = true
+	return feed
+}
+
+// unsubscribeFeed removes a feed, closing it if it's still open
+func (lobby *Lobby) unsubscribeFeed(feed chan Event) {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	if _, ok := lobby.feeds[feed]; ok {
+		delete(lobby.feeds, feed)
+		close(feed)
+	}
+}
+
+// publishToFeeds sends the event to every feed, dropping it for any feed that's too far behind, and logs it
+func (lobby *Lobby) publishToFeeds(event Event) {
+	lobby.logEvent(event)
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	for feed := range lobby.feeds {
+		select {
+		case feed <- event:
+		default:
+			log.Printf("Feed for lobby %s is full, dropping %s event", lobby.name, event.Type)
+		}
+	}
+}
+
+// closeFeeds ends every feed, e.g. once the game is over
+func (lobby *Lobby) closeFeeds() {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	for feed := range lobby.feeds {
+		delete(lobby.feeds, feed)
+		close(feed)
+	}
+}
+
+// lobbyFeedHandler streams a lobby's scoreboard and progress updates as Server-Sent Events,
+// for displays (e.g. a projector) that can't use websockets
+func (m *Manager) lobbyFeedHandler(w http.ResponseWriter, r *http.Request) {
+	lobby, lobbyExists := m.getLobby(r.URL.Query().Get("l"))
+	if !lobbyExists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	feed := lobby.subscribeFeed()
+	defer lobby.unsubscribeFeed(feed)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// The viewer disconnected
+			return
+		case event, ok := <-feed:
+			if !ok {
+				// The game is over
+				return
+			}
+			payload := event.Payload
+			if payload == nil {
+				payload = []byte("null")
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload); err != nil {
+				log.Println(err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
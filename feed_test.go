@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLobbyFeedHandler_StreamsScoreUpdates(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc"},
+		{Title: "Two", Latex: "def", Answer: "def"},
+	})
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+
+	server := httptest.NewServer(http.HandlerFunc(testManagers[lobby].lobbyFeedHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/lobby/feed?l=" + lobby.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", resp.Header.Get("Content-Type"))
+	}
+
+	// Wait until the feed is subscribed before answering
+	deadline := time.Now().Add(time.Second)
+	for {
+		lobby.RLock()
+		subscribed := len(lobby.feeds) > 0
+		lobby.RUnlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("feed never subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := giveAnswer(t, alice, "abc"); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	eventLine, _ := reader.ReadString('\n')
+	dataLine, _ := reader.ReadString('\n')
+	if strings.TrimSpace(eventLine) != "event: "+EventNewScoreUpdate {
+		t.Fatalf("expected a score update event, got %q", eventLine)
+	}
+	var update NewScoreUpdateEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.TrimSpace(dataLine), "data: ")), &update); err != nil {
+		t.Fatal(err)
+	}
+	if update.Name != "alice" || update.Score != 1 {
+		t.Errorf("unexpected score update %+v", update)
+	}
+
+	// The feed ends (and is cleaned up) once the game is over
+	endGameLobby(lobby, "Game over!")
+	reader.ReadString('\n') // blank line ending the score update
+	if line, _ := reader.ReadString('\n'); strings.TrimSpace(line) != "event: "+EventEndGame {
+		t.Errorf("expected an end game event, got %q", line)
+	}
+	lobby.RLock()
+	defer lobby.RUnlock()
+	if len(lobby.feeds) != 0 {
+		t.Error("expected feeds to be removed once the game is over")
+	}
+}
+
+func TestLobbyFeedHandler_UnknownLobby(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	req := httptest.NewRequest(http.MethodGet, "/lobby/feed?l=nope", nil)
+	rec := httptest.NewRecorder()
+	testManagers[lobby].lobbyFeedHandler(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestLobbyFeedHandler_ViewerDisconnect(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	server := httptest.NewServer(http.HandlerFunc(testManagers[lobby].lobbyFeedHandler))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/lobby/feed?l=" + lobby.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feedCount := func() int {
+		lobby.RLock()
+		defer lobby.RUnlock()
+		return len(lobby.feeds)
+	}
+	deadline := time.Now().Add(time.Second)
+	for feedCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp.Body.Close()
+	deadline = time.Now().Add(time.Second)
+	for feedCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if feedCount() != 0 {
+		t.Error("expected the feed to be removed after the viewer disconnected")
+	}
+}
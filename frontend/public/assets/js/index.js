@@ -374,8 +374,8 @@ function sendEvent(eventName, payload) {
     conn.send(JSON.stringify(event));
 }
 
-function requestNewProblem() {
-    sendEvent("request_problem", {});
+function skipProblem() {
+    sendEvent("skip_problem", {});
 }
 
 function updateScore(scoreUpdateEvent) {
@@ -435,12 +435,81 @@ function routeEvent(event) {
             const problemEvent = Object.assign(new NewProblemEvent, event.payload.problem);
             console.log(problemEvent.latex)
             loadProblem(problemEvent);
+            if (event.payload.answerableAt) {
+                // Answers aren't accepted until the preview is over
+                $('#user-input').prop("disabled", true);
+                const previewLeft = new Date(event.payload.answerableAt) - new Date();
+                setTimeout(() => {
+                    $('#user-input').prop("disabled", false);
+                    if (!mobile) {
+                        $('#user-input').focus();
+                    }
+                }, Math.max(previewLeft, 0));
+            }
             break;
         case "new_score_update":
             const scoreUpdateEvent = Object.assign(new NewScoreUpdateEvent, event.payload);
             updateScore(scoreUpdateEvent);
             break;
+        case "error":
+            alert(event.payload.message);
+            break;
+        case "owner_changed":
+            break;
+        case "owner_status":
+            break;
+        case "player_finished":
+            break;
+        case "round_complete":
+            break;
+        case "roster":
+            break;
+        case "roster_diff":
+            break;
+        case "correct_count":
+            break;
+        case "elapsed_breakdown":
+            break;
+        case "answer_result":
+            break;
+        case "game_config":
+            break;
+        case "prepare_start":
+            break;
+        case "new_message":
+            break;
+        case "chat_history":
+            break;
+        case "hint":
+            alert(event.payload.hint);
+            break;
+        case "hint_count":
+            break;
+        case "player_ready":
+            break;
+        case "players":
+            break;
+        case "scoreboard":
+            break;
+        case "player_rank":
+            break;
+        case "time_remaining":
+            // Correct any drift in our clock
+            secondsRemaining = event.payload.secondsLeft;
+            displayTime(secondsRemaining);
+            break;
+        case "inactivity_warning":
+            alert("You'll be disconnected in " + event.payload.secondsLeft + " seconds unless you do something!");
+            break;
+        case "name_changed":
+            renameUser(event.payload.oldName, event.payload.newName);
+            break;
+        case "attempts_exhausted":
+            break;
         case "end_game":
+            for (const standing of event.payload.standings || []) {
+                updateScore(standing);
+            }
             endGame();
             // TODO: logic for ending the game
             break;
@@ -477,6 +546,17 @@ function removeUser(username) {
     }
 }
 
+/**
+ * Updates a user's name on the lobby screen
+ */
+function renameUser(oldName, newName) {
+    for (const person of document.getElementsByClassName("lobby-person")) {
+        if (person.textContent == oldName) {
+            person.textContent = newName;
+        }
+    }
+}
+
 function toggleSettingsPanel() {
     var settingsPanel = $("#lobby-manager");
     if (settingsPanel.css("display") == "none") {
@@ -499,11 +579,18 @@ function connectWebsocket(otp, lobby) {
 
         // Onopen
         conn.onopen = function (evt) {
+            // The server only sends new_member events for the other players
+            addNewUser(document.getElementById("username").value);
             alert("Connected to the game!");
         }
 
         conn.onclose = function (evt) {
-            alert("Disconnected; try logging into the lobby again!");
+            // Codes 4000+ are sent by the server along with the reason it disconnected us
+            if (evt.code >= 4000 && evt.reason) {
+                alert(evt.reason);
+            } else {
+                alert("Disconnected; try logging into the lobby again!");
+            }
         }
 
         // Add a listener to the onmessage event
@@ -525,7 +612,10 @@ function login() {
     let formData = {
         "username": document.getElementById("username").value,
         "password": document.getElementById("password").value,
-        "lobbyId": (new URL(window.location.href)).searchParams.get("l")
+        "lobbyPassword": document.getElementById("lobby-password").value,
+        "lobbyId": (new URL(window.location.href)).searchParams.get("l"),
+        // Keeps our scores together on the leaderboard across games
+        "identity": localStorage.getItem("identity") || ""
     }
     // Send the request
     fetch("/login", {
@@ -539,6 +629,7 @@ function login() {
             throw 'unauthorized';
         }
     }).then((data) => {
+        localStorage.setItem("identity", data.identity);
         // Now we have a OTP, send a Request to Connect to WebSocket
         $("#login-form").hide();
         $("#lobby-screen").show();
@@ -584,7 +675,7 @@ function showStatistics(id) {
     $("#participant-scores").show();
 
 
-    fetch(window.location.origin + '/logs/' + id + '.result.json')
+    fetch(window.location.origin + '/results?l=' + id)
         .then(response => response.json())
         .then(data => {
             data.players.sort((player1, player2) => player2.score - player1.score)
@@ -599,6 +690,7 @@ function showStatistics(id) {
             $("#lobby-end-text").append(
                 `The lobby <b>${data.name}</b> started at ${formattedDate} and lasted for ${numMinutes} minutes.<br><br>`
             );
+            $("#lobby-end-text").append(`<a href="/results?l=${id}&format=csv">Download results (CSV)</a><br><br>`);
         });
 }
 
@@ -615,6 +707,7 @@ function createLobby() {
     console.log(document.getElementById("lobby-name").value);
     let formData = {
         "lobbyName": document.getElementById("lobby-name").value,
+        "lobbyPassword": document.getElementById("new-lobby-password").value,
     }
     // Send the request
     fetch("/createLobby", {
@@ -687,6 +780,14 @@ $(document).ready(function() {
         shuffleMusic();
     });
 
+    // Timers are throttled in background tabs, so check the clock when we're looked at again
+    document.addEventListener("visibilitychange", function() {
+        if (document.visibilityState == "visible" && gameTimer !== undefined
+            && conn instanceof WebSocket && conn.readyState == WebSocket.OPEN) {
+            sendEvent("request_time_remaining", {});
+        }
+    });
+
     $("#start-button-timed").click(function() {
         startGame(true);
     });
@@ -726,7 +827,7 @@ $(document).ready(function() {
     $("#skip-button").click(function() {
         skippedProblems.push(problemNumber - 1);
         if (isMultiplayer) {
-            requestNewProblem();
+            skipProblem();
         } else {
             loadSingleplayerProblem();
         }
@@ -740,7 +841,7 @@ $(document).ready(function() {
         const valid = await validateProblem()
         console.log(valid)
         if (isMultiplayer && valid) {
-            setTimeout(() => sendEvent("give_answer", {}), 1500);
+            setTimeout(() => sendEvent("give_answer", {answer: $("#user-input").val()}), 1500);
         } else if (valid) {
             setTimeout(loadSingleplayerProblem, 1500);
         }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// LeaderboardEntry is a player's record across every game they've finished
+type LeaderboardEntry struct {
+	// Name is the name the player last played under
+	Name  string `json:"name"`
+	Games int    `json:"games"`
+	Score int    `json:"score"`
+}
+
+// leaderboard is the leaderboard file's contents: players' records keyed by their identity, which is kept
+// secret so nobody can play under someone else's record
+type leaderboard struct {
+	Players map[string]*LeaderboardEntry `json:"players"`
+}
+
+// leaderboardLock serialises updates to the leaderboard file
+var leaderboardLock sync.Mutex
+
+// loadLeaderboard reads the leaderboard file, which is empty if it doesn't exist yet
+func loadLeaderboard(path string) (leaderboard, error) {
+	board := leaderboard{make(map[string]*LeaderboardEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return board, nil
+	} else if err != nil {
+		return board, err
+	}
+	if err := json.Unmarshal(data, &board); err != nil {
+		return board, err
+	}
+	if board.Players == nil {
+		board.Players = make(map[string]*LeaderboardEntry)
+	}
+	return board, nil
+}
+
+// saveLeaderboard adds the scores of everyone who played the lobby's finished game to the leaderboard file,
+// if the leaderboard is enabled
+func (l *Lobby) saveLeaderboard() {
+	path := config.LeaderboardFile
+	if path == "" || l.gameState != Finished {
+		return
+	}
+	leaderboardLock.Lock()
+	defer leaderboardLock.Unlock()
+
+	board, err := loadLeaderboard(path)
+	if err != nil {
+		log.Printf("Failed to load the leaderboard: %v", err)
+		return
+	}
+	for name, user := range l.userMapping {
+		if user.spectator || user.identity == "" {
+			continue
+		}
+		entry, ok := board.Players[user.identity]
+		if !ok {
+			entry = &LeaderboardEntry{}
+			board.Players[user.identity] = entry
+		}
+		entry.Name = name
+		entry.Games++
+		entry.Score += user.score
+	}
+
+	data, err := json.Marshal(board)
+	if err != nil {
+		log.Printf("Failed to save the leaderboard: %v", err)
+		return
+	}
+	// Written to a temporary file first, so a crash mid-write can't lose everyone's scores
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		log.Printf("Failed to save the leaderboard: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		log.Printf("Failed to save the leaderboard: %v", err)
+		os.Remove(tmp.Name())
+	}
+}
+
+// sorted returns the players' records, highest total score first
+func (b leaderboard) sorted() []LeaderboardEntry {
+	entries := make([]LeaderboardEntry, 0, len(b.Players))
+	for _, entry := range b.Players {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// leaderboardHandler returns the cumulative leaderboard across every finished game
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if config.LeaderboardFile == "" {
+		http.Error(w, "the leaderboard is disabled", http.StatusNotFound)
+		return
+	}
+
+	leaderboardLock.Lock()
+	board, err := loadLeaderboard(config.LeaderboardFile)
+	leaderboardLock.Unlock()
+	if err != nil {
+		log.Printf("Failed to load the leaderboard: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(board.sorted())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// useLeaderboardFile enables the leaderboard, kept in a temporary file, for the duration of the test
+func useLeaderboardFile(t *testing.T) {
+	t.Helper()
+	previous := config
+	config.LeaderboardFile = filepath.Join(t.TempDir(), "leaderboard.json")
+	t.Cleanup(func() { config = previous })
+}
+
+// finishTestGame records a finished game with the given scores, keyed by player name
+func finishTestGame(t *testing.T, identities map[string]string, scores map[string]int) {
+	t.Helper()
+	lobby := newTestLobby(t, nil)
+	for name, score := range scores {
+		lobby.userMapping[name] = User{score: score, identity: identities[name]}
+	}
+	lobby.gameState = Finished
+	lobby.saveLeaderboard()
+}
+
+func TestLeaderboard_AccumulatesAcrossGames(t *testing.T) {
+	useLeaderboardFile(t)
+	identities := map[string]string{
+		"alice": "11111111-1111-1111-1111-111111111111",
+		"bob":   "22222222-2222-2222-2222-222222222222",
+		"carol": "33333333-3333-3333-3333-333333333333",
+	}
+	finishTestGame(t, identities, map[string]int{"alice": 300, "bob": 500})
+	// Players keep their record under a new name
+	identities["bobby"] = identities["bob"]
+	finishTestGame(t, identities, map[string]int{"alice": 400, "bobby": 100, "carol": 700})
+
+	rec := httptest.NewRecorder()
+	leaderboardHandler(rec, httptest.NewRequest(http.MethodGet, "/leaderboard", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var entries []LeaderboardEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	expected := []LeaderboardEntry{
+		{"alice", 2, 700},
+		{"carol", 1, 700},
+		{"bobby", 2, 600},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+}
+
+func TestLeaderboard_Disabled(t *testing.T) {
+	previous := config
+	config.LeaderboardFile = ""
+	t.Cleanup(func() { config = previous })
+
+	rec := httptest.NewRecorder()
+	leaderboardHandler(rec, httptest.NewRequest(http.MethodGet, "/leaderboard", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when the leaderboard is disabled, got %d", rec.Code)
+	}
+}
+
+func TestLoginIdentity(t *testing.T) {
+	identity := "11111111-1111-1111-1111-111111111111"
+	if got := loginIdentity(identity); got != identity {
+		t.Errorf("expected a valid identity to be kept, got %s", got)
+	}
+	if got := loginIdentity("not an identity"); got == "not an identity" || got == "" {
+		t.Errorf("expected an invalid identity to be replaced, got %q", got)
+	}
+}
@@ -4,41 +4,107 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
+	cfg, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	config = cfg
+	problemsFile = cfg.ProblemsFile
+	practiceFile = cfg.PracticeProblemsFile
+	logsDirectory = cfg.LogsDirectory
+	sessionsDirectory = cfg.SessionsDirectory
+
 	// Initialize problems -- done at the start so there's not excessive latency on the first game
 	GetProblems()
 	println("Starting server...")
 
-	// Create a root ctx and a CancelFunc which can be used to cancel retentionMap goroutine
+	// Create a root ctx which is cancelled on shutdown, stopping retentionMap goroutines
 	rootCtx := context.Background()
-	ctx, cancel := context.WithCancel(rootCtx)
+	ctx, cancel := signal.NotifyContext(rootCtx, os.Interrupt, syscall.SIGTERM)
 
 	defer cancel()
 
-	setupAPI(ctx)
+	manager := setupAPI(ctx)
 
-	// Serve on port :8080
-	err := http.ListenAndServe(":8080", nil)
-	if err != nil {
+	// Reload the problem set on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := ReloadProblems(); err != nil {
+				log.Printf("Failed to reload problems: %v", err)
+			} else {
+				log.Println("Reloaded problems")
+			}
+		}
+	}()
+
+	server := &http.Server{Addr: config.ListenAddr, Handler: manager.rejectBannedIPs(limitRequestBodies(http.DefaultServeMux))}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	err = server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatal("ListenAndServe: ", err)
 	}
+
+	log.Println("Shutting down...")
+	manager.shutdown(5 * time.Second)
 }
 
 // setupAPI will start all Routes and their Handlers
-func setupAPI(ctx context.Context) {
+func setupAPI(ctx context.Context) *Manager {
 
 	// Create a Manager instance used to handle WebSocket Connections
 	manager := NewManager(ctx)
+	if config.SnapshotsDirectory != "" {
+		// Bring back any games that were in progress before a restart
+		if err := manager.enableSnapshots(config.SnapshotsDirectory, config.SnapshotInterval); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.ResultRetention > 0 {
+		manager.startResultsCleanup(config.ResultRetention)
+	}
 
 	// Basic routes (frontend + logs + creation of lobby)
 	http.Handle("/", http.FileServer(http.Dir("./frontend/public")))
-	http.Handle("/logs/", http.StripPrefix("/logs/", http.FileServer(http.Dir("./logs"))))
+	http.Handle("/logs/", http.StripPrefix("/logs/", http.FileServer(http.Dir(logsDirectory))))
 	http.HandleFunc("/createLobby", manager.createLobbyHandler)
+	http.HandleFunc("/lobby/custom/validate", manager.validateCustomProblemsHandler)
+	http.HandleFunc("/lobby/problems", manager.lobbyProblemsHandler)
+	http.HandleFunc("/problems/metadata", problemsMetadataHandler)
+	http.HandleFunc("/problems/", problemHandler)
+	http.HandleFunc("/problems/import/csv", importProblemsCSVHandler)
+	http.HandleFunc("/latex/judge", judgeHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 
 	// Routes used for lobby
 	http.HandleFunc("/login", manager.loginHandler)
 	http.HandleFunc("/ws", manager.serveWS)
 	http.HandleFunc("/lobbyStatus", manager.lobbyStatus)
+	http.HandleFunc("/lobby/preview", manager.lobbyPreviewHandler)
+	http.HandleFunc("/lobby/estimate", estimateHandler)
+	http.HandleFunc("/results", resultsHandler)
+	http.HandleFunc("/leaderboard", leaderboardHandler)
+	http.HandleFunc("/lobby/feed", manager.lobbyFeedHandler)
+
+	// Admin routes
+	http.HandleFunc("/admin/stats", requireAdmin(manager.adminStatsHandler))
+	http.HandleFunc("/admin/reload-problems", requireAdmin(reloadProblemsHandler))
+	http.HandleFunc("/admin/problem-stats", requireAdmin(manager.problemStatsHandler))
+	http.HandleFunc("/admin/cleanup-results", requireAdmin(manager.cleanupResultsHandler))
+	http.HandleFunc("/admin/lobby/reset", requireAdmin(manager.resetLobbyHandler))
+	http.HandleFunc("/admin/lobby/archive", requireAdmin(archiveHandler))
+
+	return manager
 }
@@ -4,12 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -21,40 +29,225 @@ var (
 	*/
 	websocketUpgrader = websocket.Upgrader{
 		// Apply the Origin Checker
-		CheckOrigin:     nil,
+		CheckOrigin:     checkOrigin,
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
 )
 
+// checkOrigin only lets pages served by this server, or by one of the configured allowed origins, open
+// websockets, unless every origin is allowed. Requests without an Origin don't come from a browser page, so
+// can't be forged by one, and are let through
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || config.AllowAllOrigins {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range config.allowedOrigins() {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
 var (
 	ErrEventNotSupported = errors.New("this event type is not supported")
 )
 
 var handlers = map[string]EventHandler{
-	EventStartGameOwner: StartGameHandler,
-	EventGiveAnswer:     GiveAnswerHandler,
-	EventRequestProblem: RequestProblemHandler,
+	EventStartGameOwner:          StartGameHandler,
+	EventGiveAnswer:              GiveAnswerHandler,
+	EventRequestProblem:          RequestProblemHandler,
+	EventSkipProblem:             SkipProblemHandler,
+	EventUndo:                    UndoHandler,
+	EventForceFinish:             ForceFinishHandler,
+	EventTransferOwnership:       TransferOwnershipHandler,
+	EventSendMessage:             ChatHandler,
+	EventSetChatFilter:           SetChatFilterHandler,
+	EventRequestHint:             RequestHintHandler,
+	EventRequestHintCount:        RequestHintCountHandler,
+	EventSetReady:                SetReadyHandler,
+	EventGetPlayers:              GetPlayersHandler,
+	EventChangeName:              ChangeNameHandler,
+	EventSpectateToggle:          SpectateToggleHandler,
+	EventRequestTimeRemaining:    RequestTimeRemainingHandler,
+	EventRequestScoreboard:       RequestScoreboardHandler,
+	EventRequestPlayerRank:       RequestPlayerRankHandler,
+	EventRequestOwnerStatus:      RequestOwnerStatusHandler,
+	EventRequestRoster:           RequestRosterHandler,
+	EventRequestCorrectCount:     RequestCorrectCountHandler,
+	EventRequestElapsedBreakdown: RequestElapsedBreakdownHandler,
+	EventRequestGameConfig:       RequestGameConfigHandler,
+	EventChangeSettings:          ChangeSettingsHandler,
+	EventStartAck:                StartAckHandler,
 }
 
 type Problem struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Latex       string `json:"latex"`
+	// Answer is the expected submission; problems without one are checked client-side (rendered output)
+	Answer string `json:"answer,omitempty"`
+	// AcceptableAnswers are other forms of the answer that normalization can't unify with it; a submission
+	// matching any of them (or Answer) is correct
+	AcceptableAnswers []string `json:"acceptableAnswers,omitempty"`
+	// Tags group problems by topic (e.g. "calculus"), so games can be themed
+	Tags []string `json:"tags,omitempty"`
+	// Difficulty is a free-form rating of the problem (e.g. "easy")
+	Difficulty string `json:"difficulty,omitempty"`
+	// Normalization overrides the problem set's answer normalization for this problem
+	Normalization *NormalizationOptions `json:"normalization,omitempty"`
+	// Tolerance makes the problem's answers numeric: submissions close enough to one of them are correct too
+	Tolerance *NumericTolerance `json:"tolerance,omitempty"`
+	// Hints are revealed to players one at a time, on request
+	Hints []string `json:"hints,omitempty"`
+	// Variables make the problem a template: each player gets their own variant, with each variable given a
+	// random value and every [[expression]] of the variables in the problem's text, answers and hints filled in
+	Variables map[string]VariableRange `json:"variables,omitempty"`
 }
 
 func (p *Problem) CheckAnswer(submittedAnswer string) bool {
-	return true // TODO: Implement this (check against answer)
+	answers := p.AcceptableAnswers
+	if p.Answer != "" {
+		answers = append([]string{p.Answer}, answers...)
+	}
+	if len(answers) == 0 {
+		return true
+	}
+	opts := DefaultNormalization
+	if p.Normalization != nil {
+		opts = *p.Normalization
+	}
+	for _, answer := range answers {
+		correct, expected, submitted := judgeAnswer(answer, submittedAnswer, opts)
+		if correct || (p.Tolerance != nil && p.Tolerance.matches(expected, submitted)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyTag reports whether the problem has at least one of the given tags (case-insensitive)
+func (p *Problem) hasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		for _, own := range p.Tags {
+			if strings.EqualFold(tag, own) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutAnswer returns a copy of the problem that's safe to send to players
+func (p Problem) withoutAnswer() Problem {
+	p.Answer = ""
+	p.AcceptableAnswers = nil
+	p.Hints = nil
+	return p
 }
 
 type Problems struct {
 	Problems []Problem `json:"problems"`
+	// Normalization applies to every problem in the set that doesn't set its own
+	Normalization *NormalizationOptions `json:"normalization,omitempty"`
+	// metadata summarises the set's tags and difficulties, computed when the set is loaded
+	metadata ProblemMetadata
+}
+
+// applyNormalization gives each problem without its own normalization options the set's options
+func (ps *Problems) applyNormalization() {
+	if ps.Normalization == nil {
+		return
+	}
+	for i := range ps.Problems {
+		if ps.Problems[i].Normalization == nil {
+			ps.Problems[i].Normalization = ps.Normalization
+		}
+	}
 }
 
 type User struct {
 	password       string
 	questionNumber int
 	score          int
+	// attempts is the number of wrong answers given for the current problem
+	attempts int
+	// hintsUsed is the number of hints revealed for the current problem
+	hintsUsed int
+	// totalHints is the number of hints revealed across the whole game
+	totalHints int
+	// answerableAt is when the user could start answering their current problem, after any preview
+	// (zero if it hasn't been sent yet)
+	answerableAt time.Time
+	// ready is set by players waiting for the game to start, to show they're good to go
+	ready bool
+	// spectators watch the game without playing
+	spectator bool
+	// guests joined without a password, so can't take owner actions
+	guest bool
+	// undo lets the user take back their last wrong answer
+	undo *undoableAnswer
+	// lastAnswer is the user's latest answer, to spot it being submitted again by accident
+	lastAnswer *recentAnswer
+	// finished is set once the user has gone through every problem in the game
+	finished   bool
+	finishedAt time.Time
+	// answered is the number of problems answered correctly
+	answered int
+	// totalAnswers is the number of answers given, right or wrong
+	totalAnswers int
+	// order is the problems (as indices into the lobby's problems) served to the user so far,
+	// when the game uses weighted selection
+	order []int
+	// skipped is the problems (as indices into the lobby's problems) the user skipped, to be served again after
+	// the rest of their problems when the game requeues skipped problems
+	skipped []int
+	// practiceNumber is how many practice problems the user has solved while waiting for the game to start
+	practiceNumber int
+	// identity stays the same across lobbies, so the user's scores can be added up on the leaderboard
+	identity string
+	// lateJoiner is set for users who logged in after the game started, until they first connect
+	lateJoiner bool
+	// variant is the user's own variant of their current problem, if it's a template
+	variant *problemVariant
+	// timings are how long the user spent on each problem they're done with, in the order they did them
+	timings []ProblemTime
+}
+
+// isDuplicateAnswer reports whether the answer repeats the user's last answer within DUPLICATE_ANSWER_WINDOW. It
+// needn't have been to the same problem, as a correct answer moves them on before its repeat arrives
+func (u User) isDuplicateAnswer(answer string) bool {
+	last := u.lastAnswer
+	return last != nil && last.answer == answer && time.Since(last.at) < DUPLICATE_ANSWER_WINDOW
+}
+
+// accuracy is the fraction of the user's answers that were correct (0 if they haven't answered)
+func (u User) accuracy() float64 {
+	if u.totalAnswers == 0 {
+		return 0
+	}
+	return float64(u.answered) / float64(u.totalAnswers)
+}
+
+// undoableAnswer is a user's state from before a wrong answer
+type undoableAnswer struct {
+	before User
+	at     time.Time
+}
+
+// recentAnswer is the last answer a user gave, so it isn't counted twice if it's submitted twice
+type recentAnswer struct {
+	answer string
+	at     time.Time
 }
 
 type GameState string
@@ -71,8 +264,41 @@ type Lobby struct {
 	name      string
 	timeLimit int
 	startTime *time.Time
-	owner     *string
-	gameState GameState
+	// endTimer finishes the game once the time limit is up
+	endTimer *time.Timer
+	// ceilingTimer finishes the game once it reaches the server's maximum game duration, even if endTimer
+	// has been held back
+	ceilingTimer *time.Timer
+	// round is the current round of a multi-round game (counting from 0), which started at roundStartedAt
+	round          int
+	roundStartedAt time.Time
+	// roundBaselines are where each player stood as the current round started
+	roundBaselines map[string]roundBaseline
+	// roundTimer ends the current round once its time is up
+	roundTimer *time.Timer
+	owner      *string
+	// ownerHandover hands the lobby to another player if the owner doesn't reconnect in time
+	ownerHandover *time.Timer
+	// disconnected are the users who've lost their last connection but can still come back, until their timer
+	// lets everyone know they've left. They keep their place (and slot) in the lobby until then
+	disconnected map[string]*time.Timer
+	// awaitingAcks are the players a synchronized start is still waiting on (nil unless one is pending), and
+	// startAckTimer starts the game once they've all acknowledged it or the timeout runs out
+	awaitingAcks  map[string]bool
+	startAckTimer *time.Timer
+	gameState     GameState
+
+	// Bounds on the number of (non-spectator) players; 0 means no bound
+	minPlayers int
+	maxPlayers int
+	// allowGuests lets users join without a password
+	allowGuests bool
+	// allowPractice lets players practice on problems from the default set while they wait for the game to start
+	allowPractice bool
+	// passwordHash is the hash of the password everyone joining must give (empty if the lobby is open)
+	passwordHash string
+	// chatFilter applies the server's chat filter to messages sent in the lobby
+	chatFilter bool
 
 	// username to (hashed) password
 	userMapping map[string]User
@@ -82,15 +308,57 @@ type Lobby struct {
 	useCustom      bool
 	CustomProblems []Problem
 	CustomOrder    []int
+	// problems is the default problem set as it was when the game started
+	problems []Problem
+
+	settings GameSettings
+	// rngs make each player's random choices, from settings.Seed and their name
+	rngs map[string]*rand.Rand
+	// served counts how many times each problem has been served, for weighted selection
+	served map[int]int
+	// problemResults tallies how players fared on each problem (by index into the lobby's problems)
+	problemResults map[int]ProblemResult
+	// anonymousLabels are the labels shown instead of players' names, when the game hides them
+	anonymousLabels map[string]string
 
 	clients ClientList // TODO: investigate needs to be merged with userMapping (?)
+	// feeds are the spectator (SSE) streams following the lobby
+	feeds map[chan Event]bool
+	// eventLog is every event published to the feeds, saved with the game's results
+	eventLogLock sync.Mutex
+	eventLog     []LoggedEvent
+	// chatHistory keeps the recent chat messages shown to clients as they join
+	chatHistory *chatHistory
+	// rosterLock orders roster snapshots and diffs, which are numbered by rosterSeq
+	rosterLock sync.Mutex
+	rosterSeq  uint64
 
 	// Using a syncMutex here to be able to lcok state before editing clients
 	// Could also use Channels to block
 	sync.RWMutex
 
 	// otps is a map of allowed OTP to accept connections from
-	otps RetentionMap
+	otps *RetentionMap
+}
+
+// MAX_USERNAME_LENGTH is the longest a username can be, in characters
+const MAX_USERNAME_LENGTH = 32
+
+// validateUsername checks the name is one users can log in (or be renamed) with
+func validateUsername(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("username can't be empty")
+	} else if strings.TrimSpace(name) != name {
+		return errors.New("username can't start or end with spaces")
+	} else if utf8.RuneCountInString(name) > MAX_USERNAME_LENGTH {
+		return fmt.Errorf("username can be at most %d characters", MAX_USERNAME_LENGTH)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return errors.New("username can only contain printable characters")
+		}
+	}
+	return nil
 }
 
 // UUID to Lobby map
@@ -100,6 +368,18 @@ type LobbyList map[string]*Lobby
 type Manager struct {
 	lobbies LobbyList
 	ctx     context.Context
+	// lobbiesLock guards lobbies, which is also read by background tasks (e.g. snapshots)
+	lobbiesLock sync.RWMutex
+
+	// history caches the scan of past games' results for the admin stats
+	history historyCache
+	// snapshots persist lobbies so games survive a restart
+	snapshots snapshotStore
+	// bans are the IPs turned away for failing to log in too often
+	bans *banList
+	// clientRoutines counts the goroutines serving connected clients that are still running, so shutdown can
+	// wait for them
+	clientRoutines int64
 }
 
 // NewManager is used to initalize all the values inside the manager
@@ -107,10 +387,160 @@ func NewManager(ctx context.Context) *Manager {
 	m := &Manager{
 		lobbies: make(LobbyList),
 		ctx:     ctx,
+		bans:    newBanList(),
 	}
 	return m
 }
 
+// getLobby returns the lobby with the given id
+func (m *Manager) getLobby(id string) (*Lobby, bool) {
+	m.lobbiesLock.RLock()
+	defer m.lobbiesLock.RUnlock()
+
+	lobby, lobbyExists := m.lobbies[id]
+	return lobby, lobbyExists
+}
+
+// addLobby registers the lobby with the manager
+func (m *Manager) addLobby(lobby *Lobby) {
+	m.lobbiesLock.Lock()
+	defer m.lobbiesLock.Unlock()
+
+	m.lobbies[lobby.id] = lobby
+}
+
+// removeLobby unregisters the lobby, e.g. once its game is over
+func (m *Manager) removeLobby(lobby *Lobby) {
+	m.lobbiesLock.Lock()
+	defer m.lobbiesLock.Unlock()
+
+	delete(m.lobbies, lobby.id)
+}
+
+// allLobbies returns every lobby, so they can be iterated without holding the lock
+func (m *Manager) allLobbies() []*Lobby {
+	m.lobbiesLock.RLock()
+	defer m.lobbiesLock.RUnlock()
+
+	lobbies := make([]*Lobby, 0, len(m.lobbies))
+	for _, lobby := range m.lobbies {
+		lobbies = append(lobbies, lobby)
+	}
+	return lobbies
+}
+
+// finishGame ends an in-play game for the whole lobby: everyone is sent the final standings and
+// disconnected, the results are saved, and the lobby is removed. It returns false if the game wasn't in play.
+func (m *Manager) finishGame(lobby *Lobby, message string) bool {
+	lobby.Lock()
+	if !lobby.inPlay() {
+		lobby.Unlock()
+		return false
+	}
+	lobby.endGame()
+	if lobby.endTimer != nil {
+		lobby.endTimer.Stop()
+	}
+	if lobby.ceilingTimer != nil {
+		lobby.ceilingTimer.Stop()
+	}
+	if lobby.roundTimer != nil {
+		lobby.roundTimer.Stop()
+	}
+	lobby.Unlock()
+
+	lobby.announceFinalRound()
+	endGameLobby(lobby, message)
+	lobby.RLock()
+	for client := range lobby.clients {
+		client.disconnect(CloseGameOver, message)
+	}
+	lobby.RUnlock()
+
+	lobby.saveEndedGame()
+	lobby.saveLeaderboard()
+	m.removeSnapshot(lobby)
+	// We can delete the lobby from the map now and have that be GC'd later
+	m.removeLobby(lobby)
+	return true
+}
+
+// timeRemaining is how long the lobby's game has left at the given time (never negative)
+func (l *Lobby) timeRemaining(now time.Time) time.Duration {
+	remaining := l.startTime.Add(time.Duration(l.timeLimit) * time.Second).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// startGameTimers finishes the lobby's game once its time limit is up, or it reaches the maximum game duration
+func (m *Manager) startGameTimers(lobby *Lobby) {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	lobby.endTimer = time.AfterFunc(lobby.timeRemaining(time.Now()), func() {
+		m.finishGame(lobby, "Game over!")
+	})
+	if config.MaxGameDuration > 0 {
+		lobby.ceilingTimer = time.AfterFunc(time.Until(lobby.startTime.Add(config.MaxGameDuration)), func() {
+			m.finishGame(lobby, "Game over! The game reached the maximum length")
+		})
+	}
+	m.startRoundTimer(lobby)
+}
+
+// shutdown disconnects every client, telling them the server is going away, and waits (up to the timeout)
+// for their connections to close
+func (m *Manager) shutdown(timeout time.Duration) {
+	for _, lobby := range m.allLobbies() {
+		lobby.RLock()
+		for client := range lobby.clients {
+			client.disconnect(CloseServerShutdown, "Server shutting down")
+		}
+		lobby.RUnlock()
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		connected := 0
+		for _, lobby := range m.allLobbies() {
+			lobby.RLock()
+			connected += len(lobby.clients)
+			lobby.RUnlock()
+		}
+		if connected == 0 && atomic.LoadInt64(&m.clientRoutines) == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Nobody's coming back, so there's nothing left to wait for them to do
+	for _, lobby := range m.allLobbies() {
+		lobby.stopWaiting()
+	}
+}
+
+// stopWaiting stops the lobby's timers waiting on players: to reconnect, for the owner to come back, and to
+// acknowledge a synchronized start
+func (lobby *Lobby) stopWaiting() {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	for name, timer := range lobby.disconnected {
+		timer.Stop()
+		delete(lobby.disconnected, name)
+	}
+	if lobby.ownerHandover != nil {
+		lobby.ownerHandover.Stop()
+		lobby.ownerHandover = nil
+	}
+	if lobby.startAckTimer != nil {
+		lobby.startAckTimer.Stop()
+		lobby.startAckTimer = nil
+	}
+}
+
 func NewLobby(ctx context.Context, name string, id string) *Lobby {
 	l := &Lobby{
 		userMapping:    make(map[string]User),
@@ -121,7 +551,13 @@ func NewLobby(ctx context.Context, name string, id string) *Lobby {
 		owner:          nil,
 		gameState:      WaitingForPlayers,
 		startTime:      nil,
+		chatFilter:     true,
 		clients:        make(ClientList),
+		disconnected:   make(map[string]*time.Timer),
+		feeds:          make(map[chan Event]bool),
+		chatHistory:    newChatHistory(config.ChatHistorySize),
+		served:         make(map[int]int),
+		problemResults: make(map[int]ProblemResult),
 		otps:           NewRetentionMap(ctx, 5*time.Second),
 		CustomProblems: nil,
 		CustomOrder:    nil,
@@ -130,11 +566,20 @@ func NewLobby(ctx context.Context, name string, id string) *Lobby {
 	return l
 }
 
-func (lobby *Lobby) startGame() {
+// startGame puts the lobby in play from the given time, returning false (and doing nothing) if it's already
+// been started. The start time is set along with the state, so a game in play always has one
+func (lobby *Lobby) startGame(startTime time.Time) bool {
 	if lobby.gameState != WaitingForPlayers {
-		panic("Game is already in progress")
+		return false
 	}
 	lobby.gameState = InPlay
+	lobby.startTime = &startTime
+	// Practice doesn't carry over into the game
+	for name, user := range lobby.userMapping {
+		user.practiceNumber = 0
+		lobby.userMapping[name] = user
+	}
+	return true
 }
 
 func (lobby *Lobby) endGame() {
@@ -148,6 +593,136 @@ func (lobby *Lobby) inPlay() bool {
 	return lobby.gameState == InPlay
 }
 
+// isOwner reports whether the user owns the lobby (there may not be an owner, e.g. if only guests have joined).
+// @dev Requires the lobby's lock to be held
+func (lobby *Lobby) isOwner(name string) bool {
+	return lobby.owner != nil && *lobby.owner == name
+}
+
+// ownsLobby reports whether the client's user owns the lobby. Their name is read under the lobby's lock, as
+// it's changed under it
+func (c *Client) ownsLobby() bool {
+	c.lobby.RLock()
+	defer c.lobby.RUnlock()
+	return c.lobby.isOwner(c.name)
+}
+
+// playerCount returns the number of connected players, not counting spectators
+func (lobby *Lobby) playerCount() int {
+	lobby.RLock()
+	defer lobby.RUnlock()
+	return lobby.countPlayers()
+}
+
+// countPlayers is playerCount for callers already holding the lobby's lock. Players who've disconnected are
+// counted until their grace runs out, so their slots are kept for them
+func (lobby *Lobby) countPlayers() int {
+	players := make(map[string]bool)
+	for client := range lobby.clients {
+		if !lobby.userMapping[client.name].spectator {
+			players[client.name] = true
+		}
+	}
+	for name := range lobby.disconnected {
+		if !lobby.userMapping[name].spectator {
+			players[name] = true
+		}
+	}
+	return len(players)
+}
+
+// allPlayersFinished reports whether every connected player has gone through all the problems
+func (lobby *Lobby) allPlayersFinished() bool {
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	for client := range lobby.clients {
+		user := lobby.userMapping[client.name]
+		if !user.spectator && !user.finished {
+			return false
+		}
+	}
+	return true
+}
+
+// isConnected reports whether the user has a connected client in the lobby
+func (lobby *Lobby) isConnected(name string) bool {
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	return lobby.hasClient(name)
+}
+
+// isPresent reports whether the user is connected, or has only just disconnected and may come back
+func (lobby *Lobby) isPresent(name string) bool {
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	_, disconnected := lobby.disconnected[name]
+	return disconnected || lobby.hasClient(name)
+}
+
+// hasClient reports whether the user has a connected client in the lobby.
+// @dev Requires the lobby's lock to be held
+func (lobby *Lobby) hasClient(name string) bool {
+	for client := range lobby.clients {
+		if client.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitOwner gives the disconnected owner config.OwnerReconnectGrace to come back (by logging in again with
+// their username and password) before the lobby is handed to another player. Without a grace configured, the
+// lobby stays theirs however long they're gone.
+// @dev Requires the lobby's (write) lock to be held
+func (lobby *Lobby) awaitOwner() {
+	if config.OwnerReconnectGrace <= 0 || lobby.owner == nil || lobby.ownerHandover != nil {
+		return
+	}
+	owner := *lobby.owner
+	var handover *time.Timer
+	handover = time.AfterFunc(config.OwnerReconnectGrace, func() {
+		lobby.handOverOwnership(owner, &handover)
+	})
+	lobby.ownerHandover = handover
+}
+
+// handOverOwnership passes the lobby from the owner, if they still haven't reconnected, to the first connected
+// player (by name) who can own it. If there's nobody to take over, the owner keeps the lobby
+func (lobby *Lobby) handOverOwnership(owner string, handover **time.Timer) {
+	lobby.Lock()
+	if lobby.ownerHandover != *handover {
+		// The owner came back (and maybe left again) before this handover could be cancelled
+		lobby.Unlock()
+		return
+	}
+	lobby.ownerHandover = nil
+	if !lobby.isOwner(owner) || lobby.hasClient(owner) {
+		lobby.Unlock()
+		return
+	}
+	successor := ""
+	for client := range lobby.clients {
+		user := lobby.userMapping[client.name]
+		if !user.spectator && !user.guest && (successor == "" || client.name < successor) {
+			successor = client.name
+		}
+	}
+	if successor == "" {
+		lobby.Unlock()
+		return
+	}
+	lobby.owner = &successor
+	lobby.Unlock()
+
+	log.Printf("%s didn't reconnect to lobby %s in time, so %s owns it now", owner, lobby.id, successor)
+	if err := lobby.announceOwner(successor); err != nil {
+		log.Println(err)
+	}
+}
+
 // routeEvent is used to make sure the correct event goes into the correct handler
 func (m *Manager) routeEvent(event Event, c *Client) error {
 	// Check if Handler is present in Map
@@ -155,8 +730,16 @@ func (m *Manager) routeEvent(event Event, c *Client) error {
 		println(time.Now().Format("2006/01/02 15:04:05") +
 			" Event from " + c.name + " in lobby " + c.lobby.name + ": " + event.Type,
 		)
+		if activityEvents[event.Type] {
+			c.noteActivity()
+		}
 		// Execute the handler and return any err
 		if err := handler(event, c); err != nil {
+			// Let the client know what was wrong with what they sent
+			var payloadErr *PayloadError
+			if errors.As(err, &payloadErr) {
+				c.sendError(payloadErr.Error())
+			}
 			return err
 		}
 		return nil
@@ -165,6 +748,14 @@ func (m *Manager) routeEvent(event Event, c *Client) error {
 	}
 }
 
+// loginIdentity returns the identity a user logged in with, or a new one if they don't have a valid one yet
+func loginIdentity(identity string) string {
+	if _, err := uuid.Parse(identity); err != nil {
+		return uuid.NewString()
+	}
+	return identity
+}
+
 // loginHandler is used to verify an user authentication and return a one time password
 func (m *Manager) loginHandler(w http.ResponseWriter, r *http.Request) {
 
@@ -172,55 +763,104 @@ func (m *Manager) loginHandler(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		Password string `json:"password"`
 		LobbyId  string `json:"lobbyId"` // UUID
+		// LobbyPassword is the password shared by everyone in the lobby, if it has one
+		LobbyPassword string `json:"lobbyPassword"`
+		// Spectator is only used when the user first joins the lobby
+		Spectator bool `json:"spectator"`
+		// Identity is the identity given to the user by a previous login, if they have one
+		Identity string `json:"identity"`
 	}
 
 	var req userLoginRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
+	if err := decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	if err := validateUsername(req.Username); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	lobbyId := req.LobbyId
-	lobby, lobbyExists := m.lobbies[lobbyId]
+	lobby, lobbyExists := m.getLobby(lobbyId)
 	if !lobbyExists {
+		m.loginFailed(r, lobbyId, req.Username, "lobby does not exist")
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// Hashed password from the request
-	hashedReqPassword, err := HashPassword(req.Password)
-	if err != nil {
-		log.Println(err)
+	// Private lobbies are gated by their shared password before usernames are considered
+	if lobby.passwordHash != "" && !CheckPasswordHash(req.LobbyPassword, lobby.passwordHash) {
+		m.loginFailed(r, lobbyId, req.Username, "incorrect lobby password")
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	guestLogin := lobby.allowGuests && req.Password == ""
+	lobby.RLock()
 	user, userExists := lobby.userMapping[req.Username]
+	lobby.RUnlock()
 	if !userExists {
-		user.password = hashedReqPassword
-		// Initialise user
-		lobby.userMapping[req.Username] = user
+		// Hashing is slow, so it's done before taking the lock
+		newUser := User{guest: guestLogin, spectator: req.Spectator, identity: loginIdentity(req.Identity)}
+		if !guestLogin {
+			hashedReqPassword, err := HashPassword(req.Password)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			newUser.password = hashedReqPassword
+		}
+
+		lobby.Lock()
+		// Someone else may have logged in with the username in the meantime, in which case it's theirs
+		user, userExists = lobby.userMapping[req.Username]
+		if !userExists {
+			newUser.lateJoiner = lobby.gameState != WaitingForPlayers
+			// Initialise user
+			lobby.userMapping[req.Username] = newUser
+			user = newUser
+		}
+		lobby.Unlock()
+		if !userExists {
+			m.saveSnapshot(lobby)
+		}
+	}
+	if guestLogin && !user.guest {
+		// Guests don't need a password, but can't take over a password-protected username
+		m.loginFailed(r, lobbyId, req.Username, "guest login as a registered user")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
 	}
 
 	// authenticate user / verify access token
-	if CheckPasswordHash(req.Password, user.password) {
-		// If authentication passes, set the owner of the lobby
-		if lobby.owner == nil {
-			lobby.owner = &req.Username
+	if guestLogin || (!user.guest && CheckPasswordHash(req.Password, user.password)) {
+		// If authentication passes, set the owner of the lobby (guests can't own lobbies)
+		lobby.Lock()
+		becameOwner := lobby.owner == nil && !user.guest
+		if becameOwner {
+			owner := req.Username
+			lobby.owner = &owner
+		}
+		lobby.Unlock()
+		if becameOwner {
+			lobby.announceOwner(req.Username)
 		}
 
 		// add a new OTP
-		otp := lobby.otps.NewOTP()
-		lobby.otpMapping[otp.Key] = req.Username
+		otp := lobby.issueOTP(req.Username)
 
 		// format to return otp in to the frontend
 		type response struct {
-			OTP   string `json:"otp"`
-			Lobby string `json:"lobby"`
+			OTP      string `json:"otp"`
+			Lobby    string `json:"lobby"`
+			Identity string `json:"identity"`
 		}
 		resp := response{
-			OTP:   otp.Key,
-			Lobby: lobbyId,
+			OTP:      otp.Key,
+			Lobby:    lobbyId,
+			Identity: user.identity,
 		}
 
 		data, err := json.Marshal(resp)
@@ -235,6 +875,7 @@ func (m *Manager) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// failure to auth
+	m.loginFailed(r, lobbyId, req.Username, "incorrect password")
 	w.WriteHeader(http.StatusUnauthorized)
 }
 
@@ -244,14 +885,18 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 	// Grab the OTP in the Get param
 	otp := r.URL.Query().Get("otp")
 	if otp == "" {
-		w.WriteHeader(http.StatusUnauthorized)
+		http.Error(w, "missing the otp query parameter (log in to get one)", http.StatusBadRequest)
 		return
 	}
 
 	lobbyName := r.URL.Query().Get("l")
-	lobby, lobbyExists := m.lobbies[lobbyName]
+	if lobbyName == "" {
+		http.Error(w, "missing the l query parameter (the lobby to join)", http.StatusBadRequest)
+		return
+	}
+	lobby, lobbyExists := m.getLobby(lobbyName)
 	if !lobbyExists {
-		w.WriteHeader(http.StatusUnauthorized)
+		http.Error(w, "lobby not found", http.StatusNotFound)
 		return
 	}
 	if lobby.gameState == Finished {
@@ -260,8 +905,30 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify OTP is existing
-	if !lobby.otps.VerifyOTP(otp) {
+	// Only OTPs issued by the lobby's login are accepted
+	name := lobby.otpUsername(otp)
+	if name == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Players can't join a full lobby (unless they're already connected elsewhere, or their slot is being kept)
+	if lobby.maxPlayers > 0 && !lobby.userMapping[name].spectator &&
+		!lobby.isPresent(name) && lobby.playerCount() >= lobby.maxPlayers {
+		http.Error(w, "lobby is full", http.StatusForbidden)
+		return
+	}
+
+	// Verify OTP is existing, and still issued to the same user
+	claimed, ok := lobby.claimOTP(otp)
+	if !ok && websocket.IsWebSocketUpgrade(r) {
+		// The lobby issued it, so it has expired (or its user has left): browsers can't see why a handshake failed, so the connection is
+		// opened just to be closed with the reason
+		if conn, err := websocketUpgrader.Upgrade(w, r, nil); err == nil {
+			rejectConnection(conn, CloseLoginExpired, "Your login has expired, log in again")
+		}
+		return
+	} else if !ok || claimed != name {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
@@ -274,14 +941,104 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !lobby.admitLateJoiner(name) {
+		rejectConnection(conn, CloseGameStarted, "This game has already started, and isn't taking new players")
+		return
+	}
+
 	// Create New Client
-	client := NewClient(conn, m, lobby, otp)
+	client := NewClient(conn, m, lobby, name)
 	// Add the newly created client to the manager
-	lobby.addClient(client)
+	if lobby.addClient(client) {
+		lobby.publishRosterChange(RosterJoin, name, "")
+	}
+
+	m.goClient(client.writeMessages)
+	if timeout, warning := config.InactivityTimeout, config.InactivityWarning; timeout > 0 {
+		m.goClient(func() { client.watchInactivity(timeout, warning) })
+	}
+	// The client's own events are only read once it's been welcomed, so they can't race with it
+	client.welcome()
+	m.goClient(client.readMessages)
+}
 
-	go client.readMessages()
-	go client.writeMessages()
+// goClient runs one of a client's goroutines, keeping count of them for shutdown
+func (m *Manager) goClient(routine func()) {
+	atomic.AddInt64(&m.clientRoutines, 1)
+	go func() {
+		defer atomic.AddInt64(&m.clientRoutines, -1)
+		routine()
+	}()
+}
 
+// admitLateJoiner lets a player who logged in after the game started join it, if the game allows late joins,
+// starting them from the point the game's settings say. Everyone else is always admitted. Late joiners who are
+// turned away are forgotten, so they don't show up in the results
+func (lobby *Lobby) admitLateJoiner(name string) bool {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	user, ok := lobby.userMapping[name]
+	if !ok || !user.lateJoiner || lobby.gameState != InPlay {
+		return true
+	}
+	if user.spectator {
+		user.lateJoiner = false
+		lobby.userMapping[name] = user
+		return true
+	}
+
+	switch lobby.settings.LateJoin {
+	case LateJoinClosed:
+		delete(lobby.userMapping, name)
+		return false
+	case LateJoinCatchUp:
+		// With weighted selection each player has their own order, so there's no common point to catch up to
+		if !lobby.settings.WeightedSelection {
+			user.questionNumber = lobby.furthestBehind(name)
+		}
+	}
+	// Rounds that are over can't be played any more
+	if start := lobby.roundStart(lobby.round); user.questionNumber < start {
+		user.questionNumber = start
+	}
+	user.lateJoiner = false
+	lobby.userMapping[name] = user
+	return true
+}
+
+// furthestBehind returns the lowest question number among the players still playing, other than the named one
+// (0 if there are none)
+func (lobby *Lobby) furthestBehind(name string) int {
+	lowest := -1
+	for other, user := range lobby.userMapping {
+		if other == name || user.spectator || user.finished || user.lateJoiner {
+			continue
+		}
+		if lowest == -1 || user.questionNumber < lowest {
+			lowest = user.questionNumber
+		}
+	}
+	if lowest == -1 {
+		return 0
+	}
+	// Players back on problems they skipped are past the end of the shared order
+	if lowest >= len(lobby.CustomOrder) {
+		return len(lobby.CustomOrder) - 1
+	}
+	return lowest
+}
+
+// welcome introduces a newly connected client to the lobby: they're shown the recent chat and the roster, then
+// while waiting, everyone is told about each other; once the game is in play, the client is caught up with it
+func (client *Client) welcome() {
+	lobby := client.lobby
+	if err := client.sendChatHistory(); err != nil {
+		log.Println(err)
+	}
+	if err := lobby.sendRoster(client); err != nil {
+		log.Println(err)
+	}
 	if lobby.gameState == WaitingForPlayers {
 		// Sending newMember events to all joined clients
 		var broadMessage = NewMemberEvent{client.name}
@@ -293,10 +1050,14 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var outgoingEvent = Event{EventNewMember, data}
-		for c := range client.lobby.clients {
-			if c.name != client.name {
-				c.egress <- outgoingEvent
+		lobby.publishToFeeds(outgoingEvent)
+		for _, c := range lobby.snapshotClients() {
+			// The joiner already knows about themselves, so they're only told about the others
+			if c.name == client.name {
+				continue
 			}
+			c.trySend(outgoingEvent)
+
 			var smallMessage = NewMemberEvent{c.name}
 			data, err = json.Marshal(smallMessage)
 			if err != nil {
@@ -307,7 +1068,27 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 			client.egress <- smallOutgoingEvent
 		}
 	} else if lobby.gameState == InPlay {
-		var startGameMessage = StartGameEvent{*lobby.startTime, lobby.timeLimit}
+		lobby.RLock()
+		startTime := lobby.startTime
+		if startTime != nil && lobby.awaitingAcks != nil {
+			// The game is waiting on a synchronized start, which everyone will be sent once it happens
+			prepare, err := lobby.prepareStart()
+			lobby.RUnlock()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			client.egress <- prepare
+			return
+		}
+		lobby.RUnlock()
+		if startTime == nil {
+			// The lobby's state is inconsistent; the client is told rather than the server crashing
+			log.Printf("Lobby %s is in play without a start time", lobby.id)
+			client.sendError("the game hasn't started properly")
+			return
+		}
+		var startGameMessage = StartGameEvent{*startTime, lobby.timeLimit}
 
 		data, err := json.Marshal(startGameMessage)
 		if err != nil {
@@ -318,6 +1099,10 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 		var outgoingEvent = Event{EventStartGame, data}
 		client.egress <- outgoingEvent
 
+		if user, waiting := client.roundState(); user.spectator || user.finished || waiting {
+			return
+		}
+
 		newProblemMessage := client.getNewProblem()
 
 		data, err = json.Marshal(newProblemMessage)
@@ -331,43 +1116,75 @@ func (m *Manager) serveWS(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (m *Manager) lobbyStatus(w http.ResponseWriter, r *http.Request) {
-	type lobbyStatusRequest struct {
-		Id string `json:"lobbyId"`
+// lobbyState returns the lobby (if it's still running) and the state of its game. Lobbies that aren't running
+// have either finished, if their results were saved, or never existed
+func (m *Manager) lobbyState(id string) (*Lobby, GameState) {
+	if lobby, lobbyExists := m.getLobby(id); lobbyExists {
+		lobby.RLock()
+		defer lobby.RUnlock()
+		return lobby, lobby.gameState
 	}
-	var req lobbyStatusRequest
+	if id == "" || filepath.Base(id) != id {
+		return nil, DNE
+	}
+	logFilepath := filepath.Join(logsDirectory, id+".result.json")
+	if _, err := os.Stat(logFilepath); errors.Is(err, os.ErrNotExist) {
+		return nil, DNE
+	}
+	return nil, Finished
+}
 
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+// lobbyPreviewHandler returns a lobby's public details, so they can be shown before logging in
+func (m *Manager) lobbyPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+
 	type response struct {
-		Status GameState `json:"lobbyStatus"`
+		Name             string    `json:"name,omitempty"`
+		Status           GameState `json:"lobbyStatus"`
+		Players          int       `json:"players"`
+		MaxPlayers       int       `json:"maxPlayers"`
+		PasswordRequired bool      `json:"passwordRequired"`
+	}
+	lobby, state := m.lobbyState(r.URL.Query().Get("l"))
+	resp := response{Status: state}
+	if lobby != nil {
+		lobby.RLock()
+		resp.Name = lobby.name
+		resp.Players = lobby.countPlayers()
+		resp.MaxPlayers = lobby.maxPlayers
+		resp.PasswordRequired = lobby.passwordHash != ""
+		lobby.RUnlock()
 	}
 
-	lobby, lobbyExists := m.lobbies[req.Id]
-
-	if !lobbyExists {
-		var resp response
-		// If lobby doesn't exist in map, either it's been deleted or the game has ended
-		logFilepath := filepath.Join(".", "logs", req.Id+".result.json")
-		if _, err := os.Stat(logFilepath); errors.Is(err, os.ErrNotExist) {
-			resp = response{Status: DNE}
-		} else {
-			resp = response{Status: Finished}
-		}
-		data, err := json.Marshal(resp)
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
 
-		if err != nil {
-			log.Println(err)
-		}
-		w.WriteHeader(http.StatusOK)
-		w.Write(data)
+func (m *Manager) lobbyStatus(w http.ResponseWriter, r *http.Request) {
+	type lobbyStatusRequest struct {
+		Id string `json:"lobbyId"`
+	}
+	var req lobbyStatusRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
 		return
 	}
+	type response struct {
+		Status GameState `json:"lobbyStatus"`
+	}
 
-	resp := response{Status: lobby.gameState}
+	_, state := m.lobbyState(req.Id)
+	resp := response{Status: state}
 	data, err := json.Marshal(resp)
 	if err != nil {
 		log.Println(err)
@@ -378,18 +1195,48 @@ func (m *Manager) lobbyStatus(w http.ResponseWriter, r *http.Request) {
 
 func (m *Manager) createLobbyHandler(w http.ResponseWriter, r *http.Request) {
 	type createLobbyRequest struct {
-		Name string `json:"lobbyName"`
+		Name       string `json:"lobbyName"`
+		MinPlayers int    `json:"minPlayers"`
+		MaxPlayers int    `json:"maxPlayers"`
+		// AllowGuests lets users join without a password
+		AllowGuests bool `json:"allowGuests"`
+		// AllowPractice lets players practice while they wait for the game to start
+		AllowPractice bool `json:"allowPractice"`
+		// LobbyPassword (optional) must be given by everyone joining the lobby, on top of their own password
+		LobbyPassword string `json:"lobbyPassword"`
 	}
 	var req createLobbyRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if req.MinPlayers < 0 || req.MaxPlayers < 0 {
+		http.Error(w, "player limits can't be negative", http.StatusBadRequest)
+		return
+	}
+	if req.MaxPlayers > 0 && req.MinPlayers > req.MaxPlayers {
+		http.Error(w, "minPlayers can't be more than maxPlayers", http.StatusBadRequest)
 		return
 	}
 
 	id := uuid.New().String()
-	m.lobbies[id] = NewLobby(m.ctx, req.Name, id)
+	lobby := NewLobby(m.ctx, req.Name, id)
+	lobby.minPlayers = req.MinPlayers
+	lobby.maxPlayers = req.MaxPlayers
+	lobby.allowGuests = req.AllowGuests
+	lobby.allowPractice = req.AllowPractice
+	if req.LobbyPassword != "" {
+		passwordHash, err := HashPassword(req.LobbyPassword)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		lobby.passwordHash = passwordHash
+	}
+	m.addLobby(lobby)
+	m.saveSnapshot(lobby)
 
 	// format to return otp in to the frontend
 	type response struct {
@@ -409,27 +1256,172 @@ func (m *Manager) createLobbyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // TODO(madhav): need update these functions?
-// addClient will add clients to our clientList
+// addClient will add clients to our clientList, reporting whether the user has just joined: it's their first
+// connection, and they aren't coming back after a disconnect
 func (m *Lobby) addClient(client *Client) bool {
 	// Lock so we can manipulate
 	m.Lock()
 	defer m.Unlock()
 
 	// Add Client
+	joined := !m.hasClient(client.name)
+	if timer, ok := m.disconnected[client.name]; ok {
+		// They made it back in time, so nobody needs to know they were gone
+		timer.Stop()
+		delete(m.disconnected, client.name)
+		joined = false
+	}
 	m.clients[client] = true
-	return true
+	if m.ownerHandover != nil && m.isOwner(client.name) {
+		// The owner made it back in time
+		m.ownerHandover.Stop()
+		m.ownerHandover = nil
+	}
+	return joined
+}
+
+// rejectConnection closes a connection that was never admitted to the lobby, telling it why
+func rejectConnection(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(config.WriteTimeout))
+	conn.Close()
+}
+
+// issueOTP creates an OTP the user can connect with. Users can only hold so many unused OTPs at once;
+// past that, their oldest is revoked
+func (lobby *Lobby) issueOTP(username string) OTP {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	// Forget OTPs that have expired or been revoked, so logging in repeatedly doesn't grow otpMapping
+	live := make([]OTP, 0)
+	for key, name := range lobby.otpMapping {
+		otp, ok := lobby.otps.lookup(key)
+		if !ok {
+			delete(lobby.otpMapping, key)
+		} else if name == username {
+			live = append(live, otp)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].Created.Before(live[j].Created)
+	})
+	for i := 0; i <= len(live)-config.MaxOTPsPerUser; i++ {
+		lobby.otps.revoke(live[i].Key)
+		delete(lobby.otpMapping, live[i].Key)
+	}
+
+	otp := lobby.otps.newOTP(func(key string) bool {
+		_, issued := lobby.otpMapping[key]
+		return issued
+	})
+	lobby.otpMapping[otp.Key] = username
+	return otp
+}
+
+// otpUsername returns the user the OTP was issued to
+func (lobby *Lobby) otpUsername(otp string) string {
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	return lobby.otpMapping[otp]
+}
+
+// claimOTP uses up the OTP, returning the user it was issued to. It fails if the OTP wasn't issued by the
+// lobby, has expired or already been used, or its user has since left the lobby
+func (lobby *Lobby) claimOTP(otp string) (string, bool) {
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	name, issued := lobby.otpMapping[otp]
+	if !issued || !lobby.otps.VerifyOTP(otp) {
+		return "", false
+	}
+	// Each OTP opens a single connection
+	delete(lobby.otpMapping, otp)
+	if _, ok := lobby.userMapping[name]; !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// snapshotClients returns the currently connected clients, so they can be iterated without holding the lock
+func (lobby *Lobby) snapshotClients() []*Client {
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	clients := make([]*Client, 0, len(lobby.clients))
+	for client := range lobby.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// broadcast sends the event to every connected client. Sends don't block (or hold the lock),
+// so one slow client can't hold up the rest of the lobby
+func (lobby *Lobby) broadcast(event Event) {
+	fanOut(lobby.snapshotClients(), func(client *Client) {
+		client.trySend(event)
+	})
 }
 
 // removeClient will remove the client and clean up
 func (m *Lobby) removeClient(client *Client) {
 	m.Lock()
-	defer m.Unlock()
-
+	left := false
 	// Check if Client exists, then delete it
 	if _, ok := m.clients[client]; ok {
-		// close connection
-		client.connection.Close()
+		// close connection, which stops readMessages
+		if client.connection != nil {
+			client.connection.Close()
+		}
+		// stop writeMessages
+		close(client.done)
 		// remove
 		delete(m.clients, client)
+		left = !m.hasClient(client.name)
+		if left && m.isOwner(client.name) {
+			m.awaitOwner()
+		}
+		if left && config.DisconnectGrace > 0 {
+			m.awaitReconnect(client.name)
+			left = false
+		}
+	}
+	m.Unlock()
+
+	if left {
+		m.announceLeft(client.name)
+	}
+}
+
+// awaitReconnect keeps the user's place for config.DisconnectGrace after they lose their last connection, so
+// they can come back from e.g. a network blip without everyone being told they left.
+// @dev Requires the lobby's (write) lock to be held
+func (m *Lobby) awaitReconnect(name string) {
+	var timer *time.Timer
+	timer = time.AfterFunc(config.DisconnectGrace, func() {
+		m.Lock()
+		if m.disconnected[name] != timer {
+			// They came back (and maybe left again) before this timer could be stopped
+			m.Unlock()
+			return
+		}
+		delete(m.disconnected, name)
+		m.Unlock()
+		m.announceLeft(name)
+	})
+	m.disconnected[name] = timer
+}
+
+// announceLeft tells everyone the user has left the lobby
+func (m *Lobby) announceLeft(name string) {
+	data, err := json.Marshal(RemoveMemberEvent{name})
+	if err != nil {
+		log.Println(err)
+		return
 	}
+	event := Event{EventRemoveMember, data}
+	m.broadcast(event)
+	m.publishToFeeds(event)
+	m.publishRosterChange(RosterLeave, name, "")
 }
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestCreateLobbyHandler_PlayerLimits(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"lobbyName": "ok", "minPlayers": 2, "maxPlayers": 4}`, http.StatusOK},
+		{`{"lobbyName": "unbounded", "minPlayers": 2}`, http.StatusOK},
+		{`{"lobbyName": "backwards", "minPlayers": 5, "maxPlayers": 4}`, http.StatusBadRequest},
+		{`{"lobbyName": "negative", "minPlayers": -1}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		manager := NewManager(context.Background())
+		req := httptest.NewRequest(http.MethodPost, "/createLobby", strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		manager.createLobbyHandler(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected %d, got %d", test.body, test.status, rec.Code)
+		}
+	}
+}
+
+func TestServeWS_MaxPlayers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	lobby.maxPlayers = 1
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	connectTestClient(t, server, lobby, "owner")
+	findClient(t, lobby, "owner")
+
+	// Spectators don't take up a slot
+	lobby.userMapping["watcher"] = User{spectator: true}
+	connectTestClient(t, server, lobby, "watcher")
+
+	otp := lobby.issueOTP("bob")
+	lobby.userMapping["bob"] = User{}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?otp=" + otp.Key + "&l=" + lobby.id
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected joining a full lobby to be forbidden, got %v", err)
+	}
+}
+
+// login posts a login request for the lobby, returning the response
+func login(t *testing.T, manager *Manager, lobbyId string, username string, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(map[string]string{"username": username, "password": password, "lobbyId": lobbyId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	manager.loginHandler(rec, req)
+	return rec
+}
+
+func TestLoginHandler_Guests(t *testing.T) {
+	manager := NewManager(context.Background())
+	lobby := NewLobby(manager.ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+
+	// Without guests allowed, an empty password is just a password, as it always was
+	if rec := login(t, manager, lobby.id, "plain", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected an empty password login to succeed, got %d", rec.Code)
+	}
+	if user := lobby.userMapping["plain"]; user.guest {
+		t.Error("expected an empty password login not to be a guest when guests aren't allowed")
+	}
+	// The checks below are about who becomes the owner after that
+	lobby.owner = nil
+
+	lobby.allowGuests = true
+	if rec := login(t, manager, lobby.id, "guest", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected a guest login to succeed, got %d", rec.Code)
+	}
+	if user := lobby.userMapping["guest"]; !user.guest {
+		t.Error("expected the user to be flagged as a guest")
+	}
+	if lobby.isOwner("guest") {
+		t.Error("guests shouldn't become the owner")
+	}
+
+	// Guests can't claim a password-protected username
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby.userMapping["member"] = User{password: string(hash)}
+	if rec := login(t, manager, lobby.id, "member", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a guest to be unable to use a protected username, got %d", rec.Code)
+	}
+	if rec := login(t, manager, lobby.id, "member", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("expected the password user to still log in, got %d", rec.Code)
+	}
+	if !lobby.isOwner("member") {
+		t.Error("expected the first password user to become the owner")
+	}
+
+	// ...and a password can't take over a guest's username
+	if rec := login(t, manager, lobby.id, "guest", "secret"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a password login to a guest username to fail, got %d", rec.Code)
+	}
+}
+
+func TestLoginHandler_LobbyPassword(t *testing.T) {
+	manager := NewManager(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/createLobby", strings.NewReader(`{"lobbyName": "private", "lobbyPassword": "letmein"}`))
+	rec := httptest.NewRecorder()
+	manager.createLobbyHandler(rec, req)
+	var created struct {
+		LobbyId string `json:"l"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	lobby, _ := manager.getLobby(created.LobbyId)
+	if lobby.passwordHash == "" || lobby.passwordHash == "letmein" {
+		t.Fatalf("expected the lobby password to be stored hashed, got %q", lobby.passwordHash)
+	}
+
+	// Cheaper hashes keep the logins below quick
+	hash := func(password string) string {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(hashed)
+	}
+	lobby.passwordHash = hash("letmein")
+	lobby.userMapping["alice"] = User{password: hash("secret")}
+
+	loginWithLobbyPassword := func(username string, lobbyPassword string) int {
+		body, err := json.Marshal(map[string]string{
+			"username": username, "password": "secret", "lobbyId": lobby.id, "lobbyPassword": lobbyPassword,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		manager.loginHandler(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := login(t, manager, lobby.id, "alice", "secret").Code; code != http.StatusUnauthorized {
+		t.Errorf("expected a login without the lobby password to fail, got %d", code)
+	}
+	if code := loginWithLobbyPassword("alice", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected a login with the wrong lobby password to fail, got %d", code)
+	}
+	if code := loginWithLobbyPassword("bob", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("expected a new user with the wrong lobby password to fail, got %d", code)
+	}
+	if _, ok := lobby.userMapping["bob"]; ok {
+		t.Error("expected failed logins not to register the user")
+	}
+	if code := loginWithLobbyPassword("alice", "letmein"); code != http.StatusOK {
+		t.Errorf("expected a login with the lobby password to succeed, got %d", code)
+	}
+}
+
+func TestServeWS_OTPBinding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+	dial := func(otp string) (*http.Response, error) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?otp=" + otp + "&l=" + lobby.id
+		conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+		return resp, err
+	}
+
+	// A valid OTP binds the connection to the user it was issued to
+	lobby.userMapping["alice"] = User{}
+	otp := lobby.issueOTP("alice")
+	if _, err := dial(otp.Key); err != nil {
+		t.Fatal(err)
+	}
+	if client := findClient(t, lobby, "alice"); client.name != "alice" {
+		t.Errorf("expected the client to be bound to alice, got %s", client.name)
+	}
+
+	// ...and only opens one connection
+	if resp, err := dial(otp.Key); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected a used OTP to be rejected, got %v", err)
+	}
+
+	// An OTP the retention map knows about, but that login never issued to anyone, is rejected
+	unbound := lobby.otps.NewOTP()
+	if resp, err := dial(unbound.Key); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an OTP without a user to be rejected, got %v", err)
+	}
+	lobby.RLock()
+	defer lobby.RUnlock()
+	if len(lobby.clients) != 1 {
+		t.Errorf("expected only alice to be connected, got %d clients", len(lobby.clients))
+	}
+}
+
+func TestServeWS_BadRequests(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	manager := testManagers[lobby]
+	lobby.userMapping["alice"] = User{}
+	otp := lobby.issueOTP("alice")
+
+	for _, test := range []struct {
+		query string
+		code  int
+	}{
+		{"l=" + lobby.id, http.StatusBadRequest},
+		{"otp=" + otp.Key, http.StatusBadRequest},
+		{"otp=" + otp.Key + "&l=nope", http.StatusNotFound},
+		{"otp=wrong&l=" + lobby.id, http.StatusUnauthorized},
+	} {
+		rec := httptest.NewRecorder()
+		manager.serveWS(rec, httptest.NewRequest(http.MethodGet, "/ws?"+test.query, nil))
+		if rec.Code != test.code {
+			t.Errorf("expected %d for %q, got %d", test.code, test.query, rec.Code)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	request := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://texnique.example/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	previous := config
+	t.Cleanup(func() { config = previous })
+	for _, test := range []struct {
+		allowAll bool
+		allowed  string
+		origin   string
+		ok       bool
+	}{
+		// By default, only pages served by this server can connect
+		{false, "", "http://texnique.example", true},
+		{false, "", "https://TEXNIQUE.example", true},
+		{false, "", "http://evil.example", false},
+		{false, "", "http://texnique.example.evil.example", false},
+		{false, "", "", true},
+		// Besides any origins that are allowed
+		{false, "https://frontend.example, http://localhost:3000", "http://localhost:3000", true},
+		{false, "https://frontend.example/", "https://frontend.example", true},
+		{false, "https://frontend.example", "http://frontend.example", false},
+		// Or every origin, when that's opted into
+		{true, "", "http://evil.example", true},
+	} {
+		config.AllowAllOrigins, config.AllowedOrigins = test.allowAll, test.allowed
+		if ok := checkOrigin(request(test.origin)); ok != test.ok {
+			t.Errorf("expected %q to be allowed: %v (allow all %v, allowed %q), got %v", test.origin, test.ok, test.allowAll, test.allowed, ok)
+		}
+	}
+}
+
+func TestServeWS_CrossOrigin(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	server := newTestServer(t, testManagers[lobby])
+	lobby.userMapping["alice"] = User{}
+	owner := "alice"
+	lobby.owner = &owner
+
+	dial := func(origin string) (*http.Response, error) {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?otp=" + lobby.issueOTP("alice").Key + "&l=" + lobby.id
+		conn, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if err == nil {
+			conn.Close()
+		}
+		return resp, err
+	}
+	if _, err := dial(server.URL); err != nil {
+		t.Errorf("expected a same-origin connection to be accepted, got %v", err)
+	}
+	if resp, err := dial("http://evil.example"); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a cross-origin connection to be refused, got %v", err)
+	}
+}
+
+// readEventsFor collects the events a connection receives in the given window
+func readEventsFor(t *testing.T, conn *websocket.Conn, window time.Duration) []Event {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(window))
+	events := make([]Event, 0)
+	for {
+		var event Event
+		if err := conn.ReadJSON(&event); err != nil {
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Fatalf("expected to read until the deadline, got %v", err)
+			}
+			return events
+		}
+		events = append(events, event)
+	}
+}
+
+// newMemberNames returns the names announced by the new_member events
+func newMemberNames(t *testing.T, events []Event) []string {
+	t.Helper()
+	names := make([]string, 0)
+	for _, event := range events {
+		if event.Type != EventNewMember {
+			continue
+		}
+		var member NewMemberEvent
+		if err := json.Unmarshal(event.Payload, &member); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, member.Name)
+	}
+	return names
+}
+
+func TestServeWS_NewMemberEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager := NewManager(ctx)
+	lobby := NewLobby(ctx, "test", "test-lobby")
+	manager.lobbies[lobby.id] = lobby
+	server := newTestServer(t, manager)
+
+	// The first to join isn't told about themselves, only about the second once they join
+	aliceConn := connectTestClient(t, server, lobby, "alice")
+	findClient(t, lobby, "alice")
+	aliceEvents := make(chan []Event, 1)
+	go func() {
+		aliceEvents <- readEventsFor(t, aliceConn, 500*time.Millisecond)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// The second is only told about the first
+	bobConn := connectTestClient(t, server, lobby, "bob")
+	findClient(t, lobby, "bob")
+	if names := newMemberNames(t, readEventsFor(t, bobConn, 200*time.Millisecond)); !reflect.DeepEqual(names, []string{"alice"}) {
+		t.Errorf("expected the second client to be told about alice only, got %v", names)
+	}
+	if names := newMemberNames(t, <-aliceEvents); !reflect.DeepEqual(names, []string{"bob"}) {
+		t.Errorf("expected the first client to only be told about bob, got %v", names)
+	}
+}
+
+func TestIssueOTP_CapsLiveOTPs(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	lobby.userMapping["alice"] = User{}
+	lobby.userMapping["bob"] = User{}
+	bobOTP := lobby.issueOTP("bob")
+
+	first := lobby.issueOTP("alice")
+	var last OTP
+	for i := 0; i < 20; i++ {
+		last = lobby.issueOTP("alice")
+	}
+	if size := len(lobby.otpMapping); size != config.MaxOTPsPerUser+1 {
+		t.Errorf("expected alice's OTPs to be capped at %d (plus bob's), got %d", config.MaxOTPsPerUser, size)
+	}
+	// The oldest are the ones revoked, and other users' OTPs are untouched
+	if _, ok := lobby.claimOTP(first.Key); ok {
+		t.Error("expected alice's oldest OTP to have been revoked")
+	}
+	if name, ok := lobby.claimOTP(last.Key); !ok || name != "alice" {
+		t.Error("expected alice's latest OTP to still work")
+	}
+	if name, ok := lobby.claimOTP(bobOTP.Key); !ok || name != "bob" {
+		t.Error("expected bob's OTP to still work")
+	}
+}
+
+func TestIssueOTP_PurgesExpiredOTPs(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	lobby.otps = NewRetentionMap(ctx, 50*time.Millisecond)
+	lobby.userMapping["alice"] = User{}
+	lobby.userMapping["bob"] = User{}
+
+	expired := lobby.issueOTP("alice")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := lobby.otps.lookup(expired.Key); !ok {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("expected the OTP to expire")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	live := lobby.issueOTP("bob")
+	if _, ok := lobby.otpMapping[expired.Key]; ok {
+		t.Error("expected the expired OTP to be purged from otpMapping")
+	}
+	if name := lobby.otpMapping[live.Key]; name != "bob" || len(lobby.otpMapping) != 1 {
+		t.Errorf("expected only bob's new OTP to be left, got %v", lobby.otpMapping)
+	}
+}
+
+func TestServeWS_EventsOnConnect(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a"},
+		{Title: "Two", Latex: "b", Answer: "b"},
+	})
+	lobby.startGame(time.Now())
+	server := newTestServer(t, testManagers[lobby])
+
+	// The client asks for its problem before the server has finished catching it up with the game
+	conn := connectTestClient(t, server, lobby, "alice")
+	if err := conn.WriteJSON(Event{EventRequestProblem, nil}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := readEventsFor(t, conn, 300*time.Millisecond)
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	// Everyone (the client included) is told they joined, then the client is sent the roster
+	expected := []string{EventRosterDiff, EventRoster, EventStartGame, EventNewProblem, EventNewProblem}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("expected %v, got %v", expected, types)
+	}
+	for _, event := range events[3:] {
+		var problem NewProblemEvent
+		if err := json.Unmarshal(event.Payload, &problem); err != nil {
+			t.Fatal(err)
+		}
+		if problem.Problem.Title != "One" {
+			t.Errorf("expected the first problem to be (re)sent, got %s", problem.Problem.Title)
+		}
+	}
+}
+
+func TestServeWS_LateJoin(t *testing.T) {
+	tests := []struct {
+		lateJoin string
+		problem  string
+	}{
+		{LateJoinFromStart, "One"},
+		{LateJoinCatchUp, "Three"},
+		{LateJoinClosed, ""},
+	}
+	for _, test := range tests {
+		lobby := newTestLobby(t, []Problem{
+			{Title: "One", Latex: "a", Answer: "a"},
+			{Title: "Two", Latex: "b", Answer: "b"},
+			{Title: "Three", Latex: "c", Answer: "c"},
+			{Title: "Four", Latex: "d", Answer: "d"},
+		})
+		lobby.settings.LateJoin = test.lateJoin
+		lobby.startGame(time.Now())
+		lobby.userMapping["ahead"] = User{questionNumber: 3}
+		lobby.userMapping["behind"] = User{questionNumber: 2}
+		lobby.userMapping["late"] = User{lateJoiner: true}
+		server := newTestServer(t, testManagers[lobby])
+
+		conn := connectTestClient(t, server, lobby, "late")
+		if test.lateJoin == LateJoinClosed {
+			closeErr := readUntilClose(t, conn)
+			if closeErr.Code != CloseGameStarted {
+				t.Errorf("expected close %d, got %d (%s)", CloseGameStarted, closeErr.Code, closeErr.Text)
+			}
+			if _, ok := lobby.userMapping["late"]; ok {
+				t.Error("expected the rejected late joiner to be forgotten")
+			}
+			continue
+		}
+
+		events := readEventsFor(t, conn, 200*time.Millisecond)
+		if len(events) != 4 || events[3].Type != EventNewProblem {
+			t.Fatalf("%s: expected to be sent the roster, the game and a problem, got %v", test.lateJoin, events)
+		}
+		var problem NewProblemEvent
+		if err := json.Unmarshal(events[3].Payload, &problem); err != nil {
+			t.Fatal(err)
+		}
+		if problem.Problem.Title != test.problem {
+			t.Errorf("%s: expected to start on %s, got %s", test.lateJoin, test.problem, problem.Problem.Title)
+		}
+	}
+}
+
+func TestServeWS_LateJoinClosedAdmitsPlayers(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	lobby.settings.LateJoin = LateJoinClosed
+	lobby.startGame(time.Now())
+	lobby.userMapping["watcher"] = User{lateJoiner: true, spectator: true}
+	server := newTestServer(t, testManagers[lobby])
+
+	// Players from before the game started (e.g. reconnecting) and spectators can still connect
+	for _, name := range []string{"player", "watcher"} {
+		conn := connectTestClient(t, server, lobby, name)
+		events := readEventsFor(t, conn, 200*time.Millisecond)
+		if len(events) < 3 || events[2].Type != EventStartGame {
+			t.Errorf("expected %s to be sent the game, got %v", name, events)
+		}
+	}
+}
+
+func TestLobbyPreviewHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "one", Latex: "x"}})
+	manager := testManagers[lobby]
+	lobby.name = "Friday night"
+	lobby.maxPlayers = 4
+	lobby.passwordHash = "hashed"
+	addTestClient(lobby, "alice")
+	addTestClient(lobby, "bob")
+	watcher := addTestClient(lobby, "watcher")
+	lobby.userMapping[watcher.name] = User{spectator: true}
+
+	type preview struct {
+		Name             string    `json:"name"`
+		Status           GameState `json:"lobbyStatus"`
+		Players          int       `json:"players"`
+		MaxPlayers       int       `json:"maxPlayers"`
+		PasswordRequired bool      `json:"passwordRequired"`
+	}
+	getPreview := func(id string) preview {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		manager.lobbyPreviewHandler(rec, httptest.NewRequest(http.MethodGet, "/lobby/preview?l="+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected the preview to succeed, got %d", rec.Code)
+		}
+		var p preview
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	expected := preview{Name: "Friday night", Status: WaitingForPlayers, Players: 2, MaxPlayers: 4, PasswordRequired: true}
+	if p := getPreview(lobby.id); p != expected {
+		t.Errorf("expected a waiting lobby to preview as %+v, got %+v", expected, p)
+	}
+
+	lobby.gameState = InPlay
+	expected.Status = InPlay
+	if p := getPreview(lobby.id); p != expected {
+		t.Errorf("expected a lobby in play to preview as %+v, got %+v", expected, p)
+	}
+
+	// Finished lobbies are removed from the manager, leaving only their results behind
+	delete(manager.lobbies, lobby.id)
+	if err := os.WriteFile(filepath.Join(logsDirectory, lobby.id+".result.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := getPreview(lobby.id); p != (preview{Status: Finished}) {
+		t.Errorf("expected a finished lobby to preview as finished, got %+v", p)
+	}
+
+	if p := getPreview("nonexistent"); p != (preview{Status: DNE}) {
+		t.Errorf("expected a nonexistent lobby to preview as DNE, got %+v", p)
+	}
+	if p := getPreview("../" + lobby.id); p != (preview{Status: DNE}) {
+		t.Errorf("expected a lobby id outside the logs directory to preview as DNE, got %+v", p)
+	}
+
+	rec := httptest.NewRecorder()
+	manager.lobbyPreviewHandler(rec, httptest.NewRequest(http.MethodPost, "/lobby/preview?l="+lobby.id, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected a POST to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestServeWS_InPlayStartTime(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	startTime := time.Unix(time.Now().Unix(), 0)
+	lobby.startGame(startTime)
+	server := newTestServer(t, testManagers[lobby])
+
+	conn := connectTestClient(t, server, lobby, "alice")
+	events := readEventsFor(t, conn, 200*time.Millisecond)
+	var start StartGameEvent
+	if len(events) < 3 || events[2].Type != EventStartGame {
+		t.Fatalf("expected to be sent the game, got %v", events)
+	} else if err := json.Unmarshal(events[2].Payload, &start); err != nil {
+		t.Fatal(err)
+	} else if !start.StartTimestamp.Equal(startTime) {
+		t.Errorf("expected the game to have started at %v, got %v", startTime, start.StartTimestamp)
+	}
+
+	// A game in play without a start time is an error for the client, not a crash
+	lobby.Lock()
+	lobby.startTime = nil
+	lobby.Unlock()
+	conn = connectTestClient(t, server, lobby, "bob")
+	events = readEventsFor(t, conn, 200*time.Millisecond)
+	if countEvents(events, EventStartGame) != 0 || countEvents(events, EventError) != 1 {
+		t.Errorf("expected bob to be sent an error instead of the game, got %v", events)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Categories of websocket errors, used to label websocketErrors
+const (
+	WebsocketReadError       = "read"
+	WebsocketWriteError      = "write"
+	WebsocketAbnormalClosure = "abnormal_closure"
+	WebsocketPingTimeout     = "ping_timeout"
+)
+
+// labeledCounter counts occurrences of something, broken down by label
+type labeledCounter struct {
+	sync.Mutex
+	counts map[string]int64
+}
+
+// newLabeledCounter creates a counter, starting the given labels at 0 so they're reported before they occur
+func newLabeledCounter(labels ...string) *labeledCounter {
+	counter := &labeledCounter{counts: make(map[string]int64)}
+	for _, label := range labels {
+		counter.counts[label] = 0
+	}
+	return counter
+}
+
+func (c *labeledCounter) inc(label string) {
+	c.Lock()
+	defer c.Unlock()
+	c.counts[label]++
+}
+
+func (c *labeledCounter) value(label string) int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.counts[label]
+}
+
+// websocketErrors counts websocket connection errors, to help diagnose flaky deployments
+var websocketErrors = newLabeledCounter(WebsocketReadError, WebsocketWriteError, WebsocketAbnormalClosure, WebsocketPingTimeout)
+
+// readErrorCategory classifies an error from reading a websocket, returning false for clean disconnections
+func readErrorCategory(err error) (string, bool) {
+	var netErr net.Error
+	switch {
+	case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+		return "", false
+	case errors.Is(err, net.ErrClosed):
+		// The server closed the connection itself, e.g. after removing the client
+		return "", false
+	case errors.As(err, &netErr) && netErr.Timeout():
+		// The read deadline is only pushed back by pongs
+		return WebsocketPingTimeout, true
+	case websocket.IsCloseError(err, websocket.CloseAbnormalClosure):
+		return WebsocketAbnormalClosure, true
+	default:
+		return WebsocketReadError, true
+	}
+}
+
+// metricsHandler reports the server's metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	websocketErrors.Lock()
+	categories := make([]string, 0, len(websocketErrors.counts))
+	for category := range websocketErrors.counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	counts := make([]int64, len(categories))
+	for i, category := range categories {
+		counts[i] = websocketErrors.counts[category]
+	}
+	websocketErrors.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP forktexnique_websocket_errors_total Websocket connection errors, by category.")
+	fmt.Fprintln(w, "# TYPE forktexnique_websocket_errors_total counter")
+	for i, category := range categories {
+		fmt.Fprintf(w, "forktexnique_websocket_errors_total{category=%q} %d\n", category, counts[i])
+	}
+}
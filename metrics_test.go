@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newMetricsTestClient adds a client on a real connection to a lobby, returning the peer's end of it
+func newMetricsTestClient(t *testing.T) (*Client, *websocket.Conn) {
+	t.Helper()
+	lobby := newTestLobby(t, nil)
+	serverConn, peer := newConnPair(t)
+	c := &Client{
+		connection: serverConn,
+		name:       "alice",
+		lobby:      lobby,
+		manager:    testManagers[lobby],
+		egress:     make(chan Event, EGRESS_BUFFER_SIZE),
+		closing:    make(chan []byte, 1),
+		done:       make(chan struct{}),
+	}
+	lobby.userMapping["alice"] = User{}
+	lobby.addClient(c)
+	return c, peer
+}
+
+// readUntilDisconnected runs the client's read loop until its connection fails
+func readUntilDisconnected(t *testing.T, c *Client) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		c.readMessages()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the read loop to stop")
+	}
+}
+
+func TestWebsocketErrors_ReadError(t *testing.T) {
+	c, peer := newMetricsTestClient(t)
+	before := websocketErrors.value(WebsocketReadError)
+
+	// Players can't send messages this large
+	if err := peer.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 2*PLAYER_MAX_MESSAGE_SIZE))); err != nil {
+		t.Fatal(err)
+	}
+	readUntilDisconnected(t, c)
+
+	if after := websocketErrors.value(WebsocketReadError); after != before+1 {
+		t.Errorf("expected the read error count to go from %d to %d, got %d", before, before+1, after)
+	}
+}
+
+func TestWebsocketErrors_AbnormalClosure(t *testing.T) {
+	c, peer := newMetricsTestClient(t)
+	before := websocketErrors.value(WebsocketAbnormalClosure)
+
+	// Drop the connection without a close frame
+	peer.UnderlyingConn().Close()
+	readUntilDisconnected(t, c)
+
+	if after := websocketErrors.value(WebsocketAbnormalClosure); after != before+1 {
+		t.Errorf("expected the abnormal closure count to go from %d to %d, got %d", before, before+1, after)
+	}
+}
+
+func TestWebsocketErrors_CleanCloseNotCounted(t *testing.T) {
+	c, peer := newMetricsTestClient(t)
+	before := websocketErrors.value(WebsocketReadError) + websocketErrors.value(WebsocketAbnormalClosure)
+
+	peer.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+	readUntilDisconnected(t, c)
+
+	if after := websocketErrors.value(WebsocketReadError) + websocketErrors.value(WebsocketAbnormalClosure); after != before {
+		t.Errorf("expected a clean close not to be counted, went from %d to %d", before, after)
+	}
+}
+
+func TestWebsocketErrors_WriteError(t *testing.T) {
+	c, _ := newMetricsTestClient(t)
+	before := websocketErrors.value(WebsocketWriteError)
+
+	c.connection.Close()
+	if c.writeEvent(Event{EventPlayers, nil}) {
+		t.Fatal("expected writing to a closed connection to fail")
+	}
+
+	if after := websocketErrors.value(WebsocketWriteError); after != before+1 {
+		t.Errorf("expected the write error count to go from %d to %d, got %d", before, before+1, after)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	websocketErrors.inc(WebsocketPingTimeout)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, category := range []string{WebsocketReadError, WebsocketWriteError, WebsocketAbnormalClosure, WebsocketPingTimeout} {
+		if !strings.Contains(body, `forktexnique_websocket_errors_total{category="`+category+`"}`) {
+			t.Errorf("expected a %s count, got:\n%s", category, body)
+		}
+	}
+}
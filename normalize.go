@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// NormalizationOptions control how answers are normalized before being compared
+type NormalizationOptions struct {
+	// CaseInsensitive makes e.g. `X` match `x`
+	CaseInsensitive bool `json:"caseInsensitive"`
+	// IgnoreWhitespace removes whitespace that doesn't change the meaning of the LaTeX
+	IgnoreWhitespace bool `json:"ignoreWhitespace"`
+	// TreatDegreesAsRadians drops degree markers, so `30^\circ` matches `30`
+	TreatDegreesAsRadians bool `json:"treatDegreesAsRadians"`
+	// StripVariablePrefix drops a leading `x =` (or just `=`), so `x = \frac{1}{2}` matches `\frac{1}{2}`.
+	// Leave it off for problems where the whole equation is the answer
+	StripVariablePrefix bool `json:"stripVariablePrefix"`
+	// StripMathDelimiters drops math-mode delimiters wrapping the whole answer, so `$\frac12$` (or `\(\frac12\)`)
+	// matches `\frac12`
+	StripMathDelimiters bool `json:"stripMathDelimiters"`
+	// SetNotation ignores the order (and repeats) of a set's members, so `\{2,1\}` matches `\{1,2\}`, and reads
+	// intervals with reversed brackets as open, so `]0,1]` matches `(0,1]`
+	SetNotation bool `json:"setNotation"`
+}
+
+// DefaultNormalization is used for problems that don't configure their own
+var DefaultNormalization = NormalizationOptions{
+	CaseInsensitive:       false,
+	IgnoreWhitespace:      true,
+	TreatDegreesAsRadians: false,
+	StripVariablePrefix:   false,
+	StripMathDelimiters:   false,
+	SetNotation:           false,
+}
+
+// UnmarshalJSON starts from the defaults, so options can be given partially
+func (o *NormalizationOptions) UnmarshalJSON(data []byte) error {
+	type plain NormalizationOptions
+	opts := plain(DefaultNormalization)
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return err
+	}
+	*o = NormalizationOptions(opts)
+	return nil
+}
+
+var degreeMarkers = []string{"^{\\circ}", "^\\circ", "\\degree", "°"}
+
+// variablePrefix matches a leading `=`, optionally after a variable: a letter or command (e.g. `\theta`),
+// possibly with a subscript (e.g. `x_1`, `x_{n}`)
+var variablePrefix = regexp.MustCompile(`^(?:(?:[A-Za-z]|\\[A-Za-z]+)(?:_(?:\{[^{}]*\}|[A-Za-z0-9]))?)?\s*=`)
+
+// mathDelimiters are the pairs of delimiters that put LaTeX into math mode. Display-math `$$` comes before
+// `$`, so it's stripped as a pair rather than one `$` at a time
+var mathDelimiters = []struct{ open, close string }{
+	{"$$", "$$"},
+	{"$", "$"},
+	{`\(`, `\)`},
+	{`\[`, `\]`},
+}
+
+// stripMathDelimiters removes a pair of math-mode delimiters wrapping the whole answer. Answers with unbalanced
+// delimiters, or more than one delimited piece (e.g. `$a$ and $b$`), are left as they are
+func stripMathDelimiters(answer string) string {
+	for _, d := range mathDelimiters {
+		if len(answer) < len(d.open)+len(d.close) || !strings.HasPrefix(answer, d.open) || !strings.HasSuffix(answer, d.close) {
+			continue
+		}
+		inner := answer[len(d.open) : len(answer)-len(d.close)]
+		// An escaped closer (e.g. the `\$` in `$5\$`) isn't a delimiter
+		if strings.Contains(inner, d.open) || strings.Contains(inner, d.close) || strings.HasSuffix(inner, `\`) {
+			return answer
+		}
+		return strings.TrimSpace(inner)
+	}
+	return answer
+}
+
+// sizedBracket matches a bracket sized with `\left` or `\right`, which doesn't change what it means
+var sizedBracket = regexp.MustCompile(`\\(?:left|right)([^A-Za-z]|$)`)
+
+// braceAliases are the other ways of writing a set's braces
+var braceAliases = strings.NewReplacer(`\lbrace`, `\{`, `\rbrace`, `\}`)
+
+// canonicalSetNotation writes a set with its members sorted and deduplicated (sets of sets included), and an
+// interval with round brackets for its open ends. Anything else is left as it is
+func canonicalSetNotation(answer string) string {
+	answer = sizedBracket.ReplaceAllString(answer, "$1")
+	return canonicalSet(braceAliases.Replace(answer))
+}
+
+// canonicalSet canonicalizes a (possible) set or interval, with its brackets already unsized
+func canonicalSet(answer string) string {
+	answer = strings.TrimSpace(answer)
+	if len(answer) >= 4 && strings.HasPrefix(answer, `\{`) && strings.HasSuffix(answer, `\}`) {
+		members, ok := splitTopLevel(answer[2 : len(answer)-2])
+		if ok {
+			for i, member := range members {
+				members[i] = canonicalSet(member)
+			}
+			sort.Strings(members)
+			unique := make([]string, 0, len(members))
+			for i, member := range members {
+				if i == 0 || member != members[i-1] {
+					unique = append(unique, member)
+				}
+			}
+			if len(unique) == 1 && unique[0] == "" {
+				unique = nil
+			}
+			return `\{` + strings.Join(unique, ",") + `\}`
+		}
+	}
+
+	if len(answer) >= 2 {
+		open, close := answer[0], answer[len(answer)-1]
+		if strings.IndexByte("([]", open) >= 0 && strings.IndexByte(")][", close) >= 0 {
+			if bounds, ok := splitTopLevel(answer[1 : len(answer)-1]); ok && len(bounds) == 2 {
+				if open == ']' {
+					open = '('
+				}
+				if close == '[' {
+					close = ')'
+				}
+				return string(open) + bounds[0] + "," + bounds[1] + string(close)
+			}
+		}
+	}
+	return answer
+}
+
+// splitTopLevel splits a list on the commas that aren't nested in brackets (or escaped, like the thin space
+// `\,`), trimming each item. It fails if the brackets don't balance, e.g. when the list's own brackets closed
+// early as in `\{1\} \cup \{2\}`
+func splitTopLevel(list string) ([]string, bool) {
+	items := make([]string, 0)
+	depth := 0
+	start := 0
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case c == '\\':
+			if i+1 < len(list) && (list[i+1] == '{' || list[i+1] == '}') {
+				continue
+			}
+			// The command's name can't be a bracket or a separator
+			i++
+		case strings.IndexByte("{([", c) >= 0:
+			depth++
+		case strings.IndexByte("})]", c) >= 0:
+			depth--
+			if depth < 0 {
+				return nil, false
+			}
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[start:i]))
+			start = i + 1
+		}
+	}
+	if depth != 0 {
+		return nil, false
+	}
+	return append(items, strings.TrimSpace(list[start:])), true
+}
+
+// normalizeAnswer puts an answer into a canonical form according to the options
+func normalizeAnswer(answer string, opts NormalizationOptions) string {
+	answer = strings.TrimSpace(answer)
+	if opts.StripMathDelimiters {
+		answer = stripMathDelimiters(answer)
+	}
+	if opts.StripVariablePrefix {
+		// `==` isn't a prefix, e.g. in `x == y`
+		if prefix := variablePrefix.FindString(answer); prefix != "" && !strings.HasPrefix(answer[len(prefix):], "=") {
+			answer = strings.TrimSpace(answer[len(prefix):])
+		}
+	}
+	if opts.TreatDegreesAsRadians {
+		for _, marker := range degreeMarkers {
+			answer = strings.ReplaceAll(answer, marker, "")
+		}
+	}
+	if opts.IgnoreWhitespace {
+		answer = removeWhitespace(answer)
+	}
+	if opts.CaseInsensitive {
+		answer = strings.ToLower(answer)
+	}
+	if opts.SetNotation {
+		answer = canonicalSetNotation(answer)
+	}
+	return answer
+}
+
+// judgeAnswer normalizes both answers, reporting whether they match along with their normalized forms
+func judgeAnswer(expected string, submitted string, opts NormalizationOptions) (bool, string, string) {
+	normalizedExpected := normalizeAnswer(expected, opts)
+	normalizedSubmitted := normalizeAnswer(submitted, opts)
+	return normalizedExpected == normalizedSubmitted, normalizedExpected, normalizedSubmitted
+}
+
+// judgeHandler is a dry run of judging an answer, so problem authors can check their expected answers
+// against the normalizer without running a game
+func judgeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	type judgeRequest struct {
+		Expected  string `json:"expected"`
+		Submitted string `json:"submitted"`
+		// Normalization defaults to the options used for problems that don't set their own
+		Normalization *NormalizationOptions `json:"normalization"`
+	}
+	var req judgeRequest
+	if err := decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(req.Expected) > config.MaxAnswerLength || len(req.Submitted) > config.MaxAnswerLength {
+		http.Error(w, fmt.Sprintf("answers can be at most %d characters long", config.MaxAnswerLength), http.StatusBadRequest)
+		return
+	}
+	opts := DefaultNormalization
+	if req.Normalization != nil {
+		opts = *req.Normalization
+	}
+
+	type response struct {
+		Correct             bool   `json:"correct"`
+		NormalizedExpected  string `json:"normalizedExpected"`
+		NormalizedSubmitted string `json:"normalizedSubmitted"`
+	}
+	var resp response
+	resp.Correct, resp.NormalizedExpected, resp.NormalizedSubmitted = judgeAnswer(req.Expected, req.Submitted, opts)
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// removeWhitespace strips whitespace from LaTeX, keeping a single space where one separates
+// a command from a following letter (e.g. `\alpha b`, which isn't `\alphab`)
+func removeWhitespace(latex string) string {
+	var b strings.Builder
+	inCommand := false
+	pendingSpace := false
+	for _, r := range latex {
+		if unicode.IsSpace(r) {
+			pendingSpace = pendingSpace || inCommand
+			inCommand = false
+			continue
+		}
+		if pendingSpace && unicode.IsLetter(r) {
+			b.WriteRune(' ')
+		}
+		pendingSpace = false
+
+		if r == '\\' {
+			inCommand = true
+		} else if !unicode.IsLetter(r) {
+			inCommand = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
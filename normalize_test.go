@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckAnswer_CaseInsensitive(t *testing.T) {
+	problem := Problem{Answer: "X^2"}
+	if problem.CheckAnswer("x^2") {
+		t.Error("expected answers to be case sensitive by default")
+	}
+
+	problem.Normalization = &NormalizationOptions{CaseInsensitive: true}
+	if !problem.CheckAnswer("x^2") {
+		t.Error("expected `x^2` to match `X^2` when case insensitive")
+	}
+}
+
+func TestCheckAnswer_IgnoreWhitespace(t *testing.T) {
+	problem := Problem{Answer: "\\frac{a}{b} + c"}
+	if !problem.CheckAnswer("\\frac{a} {b}+c") {
+		t.Error("expected spacing differences to be ignored by default")
+	}
+
+	// A space ending a command is significant
+	problem = Problem{Answer: "\\alpha b"}
+	if problem.CheckAnswer("\\alphab") {
+		t.Error("expected `\\alphab` not to match `\\alpha b`")
+	}
+	if !problem.CheckAnswer("\\alpha   b") {
+		t.Error("expected `\\alpha   b` to match `\\alpha b`")
+	}
+
+	problem = Problem{Answer: "a + b", Normalization: &NormalizationOptions{IgnoreWhitespace: false}}
+	if problem.CheckAnswer("a+b") {
+		t.Error("expected spacing to matter when whitespace isn't ignored")
+	}
+	if !problem.CheckAnswer("  a + b ") {
+		t.Error("expected surrounding whitespace to always be trimmed")
+	}
+}
+
+func TestCheckAnswer_TreatDegreesAsRadians(t *testing.T) {
+	problem := Problem{Answer: "30", Normalization: &NormalizationOptions{TreatDegreesAsRadians: true}}
+	if !problem.CheckAnswer("30^\\circ") || !problem.CheckAnswer("30^{\\circ}") {
+		t.Error("expected degree markers to be dropped")
+	}
+}
+
+func TestCheckAnswer_StripVariablePrefix(t *testing.T) {
+	problem := Problem{Answer: "\\frac12"}
+	if problem.CheckAnswer("x=\\frac12") {
+		t.Error("expected the variable prefix to matter by default")
+	}
+
+	problem.Normalization = &NormalizationOptions{StripVariablePrefix: true, IgnoreWhitespace: true}
+	for _, answer := range []string{"x=\\frac12", "x = \\frac12", "= \\frac12", "\\theta = \\frac12", "x_{1} = \\frac12"} {
+		if !problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to match `\\frac12`", answer)
+		}
+	}
+	if problem.CheckAnswer("x == \\frac12") {
+		t.Error("expected `==` not to be treated as a prefix")
+	}
+
+	// The expected answer is stripped too
+	problem = Problem{Answer: "y = mx + c", Normalization: &NormalizationOptions{StripVariablePrefix: true}}
+	if !problem.CheckAnswer("mx + c") || !problem.CheckAnswer("y = mx + c") {
+		t.Error("expected answers to match with or without the prefix")
+	}
+}
+
+func TestCheckAnswer_StripMathDelimiters(t *testing.T) {
+	problem := Problem{Answer: "\\frac12"}
+	if problem.CheckAnswer("$\\frac12$") {
+		t.Error("expected math delimiters to matter by default")
+	}
+
+	problem.Normalization = &NormalizationOptions{StripMathDelimiters: true, IgnoreWhitespace: true}
+	for _, answer := range []string{"\\frac12", "$\\frac12$", "$$\\frac12$$", "\\(\\frac12\\)", "\\[ \\frac12 \\]", " $ \\frac12 $ "} {
+		if !problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to match `\\frac12`", answer)
+		}
+	}
+
+	// Unbalanced or partial delimiters are left alone, rather than half-stripped
+	for _, answer := range []string{"$\\frac12", "\\frac12$", "\\(\\frac12$", "$", "$$", "$\\frac12\\$"} {
+		if problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` not to match `\\frac12`", answer)
+		}
+	}
+	if stripped := stripMathDelimiters("$a$ + $b$"); stripped != "$a$ + $b$" {
+		t.Errorf("expected separately delimited pieces to be left alone, got `%s`", stripped)
+	}
+
+	// The expected answer is stripped too
+	problem = Problem{Answer: "$x^2$", Normalization: &NormalizationOptions{StripMathDelimiters: true}}
+	if !problem.CheckAnswer("x^2") {
+		t.Error("expected a delimited expected answer to match its undelimited form")
+	}
+}
+
+func TestCheckAnswer_SetNotation(t *testing.T) {
+	problem := Problem{Answer: "\\{1, 2, 3\\}"}
+	if problem.CheckAnswer("\\{3,2,1\\}") {
+		t.Error("expected the order of a set's members to matter by default")
+	}
+
+	problem.Normalization = &NormalizationOptions{SetNotation: true, IgnoreWhitespace: true}
+	for _, answer := range []string{"\\{1,2,3\\}", "\\{3,2,1\\}", "\\{2, 3, 1, 2\\}", "\\left\\{3,1,2\\right\\}", "\\lbrace 2,1,3\\rbrace"} {
+		if !problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to match `\\{1, 2, 3\\}`", answer)
+		}
+	}
+	for _, answer := range []string{"\\{1,2\\}", "\\{1,2,4\\}", "\\{1\\} \\cup \\{2,3\\}", "(1,2,3)"} {
+		if problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` not to match `\\{1, 2, 3\\}`", answer)
+		}
+	}
+
+	// Members are compared as sets too, and commas inside them don't split them
+	problem.Answer = "\\{\\{1,2\\}, \\frac{1}{2}, (0,1]\\}"
+	if !problem.CheckAnswer("\\{(0, 1], \\{2, 1\\}, \\frac{1}{2}\\}") {
+		t.Error("expected nested sets to be compared ignoring order")
+	}
+}
+
+func TestCheckAnswer_IntervalNotation(t *testing.T) {
+	problem := Problem{Answer: "(0, 1]", Normalization: &NormalizationOptions{SetNotation: true, IgnoreWhitespace: true}}
+	for _, answer := range []string{"(0,1]", "]0, 1]", "\\left(0,1\\right]", "\\left]0,1\\right]"} {
+		if !problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to match `(0, 1]`", answer)
+		}
+	}
+	// Open and closed ends still differ, as do the bounds' order
+	for _, answer := range []string{"[0,1]", "(0,1)", "[0,1[", "(1,0]", "\\left(0,1\\right)"} {
+		if problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` not to match `(0, 1]`", answer)
+		}
+	}
+
+	problem.Answer = "[-\\infty, 2["
+	if !problem.CheckAnswer("[-\\infty,2)") || problem.CheckAnswer("[-\\infty,2]") {
+		t.Error("expected a reversed closing bracket to read as an open end")
+	}
+	// `\\left` is only dropped as a sizing command, not from the start of other commands
+	if normalized := canonicalSetNotation("\\leftarrow"); normalized != "\\leftarrow" {
+		t.Errorf("expected `\\leftarrow` to be left alone, got `%s`", normalized)
+	}
+}
+
+func TestCheckAnswer_AcceptableAnswers(t *testing.T) {
+	problem := Problem{Answer: "\\sqrt{2}", AcceptableAnswers: []string{"2^{1/2}", "\\sqrt2"}}
+	for _, answer := range []string{"\\sqrt{2}", "2^{1/2}", "\\sqrt2", " 2^{1/2} "} {
+		if !problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to be accepted", answer)
+		}
+	}
+	for _, answer := range []string{"2^{1/3}", "\\sqrt{3}", ""} {
+		if problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to be rejected", answer)
+		}
+	}
+
+	// Acceptable answers work without Answer too
+	problem = Problem{AcceptableAnswers: []string{"a", "b"}}
+	if !problem.CheckAnswer("b") || problem.CheckAnswer("c") {
+		t.Error("expected only the acceptable answers to be accepted")
+	}
+	if stripped := problem.withoutAnswer(); stripped.AcceptableAnswers != nil {
+		t.Errorf("expected acceptable answers to be hidden from players, got %v", stripped.AcceptableAnswers)
+	}
+}
+
+func TestProblems_SetNormalization(t *testing.T) {
+	var problems Problems
+	data := `{
+		"normalization": {"caseInsensitive": true},
+		"problems": [
+			{"title": "Inherits", "latex": "X", "answer": "X"},
+			{"title": "Overrides", "latex": "X", "answer": "X", "normalization": {"caseInsensitive": false}}
+		]
+	}`
+	if err := json.Unmarshal([]byte(data), &problems); err != nil {
+		t.Fatal(err)
+	}
+	problems.applyNormalization()
+
+	if !problems.Problems[0].CheckAnswer("x") {
+		t.Error("expected the set's case insensitivity to apply")
+	}
+	if problems.Problems[1].CheckAnswer("x") {
+		t.Error("expected the problem's own options to take precedence")
+	}
+	// Options that aren't given keep their defaults
+	if !problems.Normalization.IgnoreWhitespace {
+		t.Error("expected ignoreWhitespace to default to true")
+	}
+}
+
+func TestJudgeHandler(t *testing.T) {
+	tests := []struct {
+		name                string
+		body                string
+		correct             bool
+		normalizedExpected  string
+		normalizedSubmitted string
+	}{
+		{
+			"default options",
+			`{"expected": "\\frac{a}{b} + c", "submitted": " \\frac{a} {b}+c"}`,
+			true, "\\frac{a}{b}+c", "\\frac{a}{b}+c",
+		},
+		{
+			"case insensitive",
+			`{"expected": "X^2", "submitted": "x^2", "normalization": {"caseInsensitive": true}}`,
+			true, "x^2", "x^2",
+		},
+		{
+			"case sensitive",
+			`{"expected": "X^2", "submitted": "x^2"}`,
+			false, "X^2", "x^2",
+		},
+		{
+			"degrees",
+			`{"expected": "30", "submitted": "30^\\circ", "normalization": {"treatDegreesAsRadians": true}}`,
+			true, "30", "30",
+		},
+		{
+			"whitespace kept",
+			`{"expected": "a + b", "submitted": "a+b", "normalization": {"ignoreWhitespace": false}}`,
+			false, "a + b", "a+b",
+		},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/latex/judge", strings.NewReader(test.body))
+		rec := httptest.NewRecorder()
+		judgeHandler(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", test.name, rec.Code)
+			continue
+		}
+
+		var resp struct {
+			Correct             bool   `json:"correct"`
+			NormalizedExpected  string `json:"normalizedExpected"`
+			NormalizedSubmitted string `json:"normalizedSubmitted"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Correct != test.correct || resp.NormalizedExpected != test.normalizedExpected ||
+			resp.NormalizedSubmitted != test.normalizedSubmitted {
+			t.Errorf("%s: expected (%v, %q, %q), got %+v", test.name, test.correct,
+				test.normalizedExpected, test.normalizedSubmitted, resp)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NumericTolerance lets a problem with a numeric answer accept any number close enough to it, so `0.333`
+// matches `1/3`. A submission is correct if it's within either tolerance; with both left at 0 it must be the
+// same number, however it's written (e.g. `0.5` matches `\frac{1}{2}`)
+type NumericTolerance struct {
+	// Absolute is how far the submission can be from the answer
+	Absolute float64 `json:"absolute,omitempty"`
+	// Relative is how far the submission can be from the answer, as a fraction of the answer (e.g. 0.01 for 1%)
+	Relative float64 `json:"relative,omitempty"`
+}
+
+// latexFraction matches a fraction written in LaTeX, e.g. `\frac{1}{3}` or `-\dfrac{2}{5}`
+var latexFraction = regexp.MustCompile(`^([+-]?)\\[dt]?frac\{([^{}]+)\}\{([^{}]+)\}$`)
+
+// parseNumber reads an answer as a number: a decimal, or a fraction written as `a/b` or `\frac{a}{b}`. It
+// fails for anything else, including infinities and division by zero
+func parseNumber(answer string) (float64, bool) {
+	answer = strings.TrimSpace(answer)
+	if match := latexFraction.FindStringSubmatch(answer); match != nil {
+		value, ok := parseQuotient(match[2], match[3])
+		if match[1] == "-" {
+			value = -value
+		}
+		return value, ok
+	}
+	if numerator, denominator, found := strings.Cut(answer, "/"); found {
+		return parseQuotient(numerator, denominator)
+	}
+	return parseDecimal(answer)
+}
+
+// parseQuotient divides two decimals
+func parseQuotient(numerator string, denominator string) (float64, bool) {
+	n, ok := parseDecimal(numerator)
+	if !ok {
+		return 0, false
+	}
+	d, ok := parseDecimal(denominator)
+	if !ok || d == 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// parseDecimal reads a finite decimal number
+func parseDecimal(s string) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, false
+	}
+	return value, true
+}
+
+// matches reports whether the submission is a number within tolerance of the expected answer. Answers that
+// aren't numbers never match
+func (t NumericTolerance) matches(expected string, submitted string) bool {
+	want, ok := parseNumber(expected)
+	if !ok {
+		return false
+	}
+	got, ok := parseNumber(submitted)
+	if !ok {
+		return false
+	}
+	difference := math.Abs(got - want)
+	return difference <= t.Absolute || difference <= t.Relative*math.Abs(want)
+}
+
+// validateTolerance checks the problem's tolerance is usable: it isn't negative, and (unless they're filled in
+// per player) at least one of its answers is a number. Other answers are still matched as written
+func validateTolerance(i int, p Problem) []ProblemError {
+	errs := make([]ProblemError, 0)
+	if p.Tolerance.Absolute < 0 || p.Tolerance.Relative < 0 {
+		errs = append(errs, ProblemError{i, "tolerance", "can't be negative"})
+	}
+	if p.isTemplate() {
+		return errs
+	}
+	opts := DefaultNormalization
+	if p.Normalization != nil {
+		opts = *p.Normalization
+	}
+	for _, answer := range append([]string{p.Answer}, p.AcceptableAnswers...) {
+		if _, ok := parseNumber(normalizeAnswer(answer, opts)); ok {
+			return errs
+		}
+	}
+	return append(errs, ProblemError{i, "tolerance", "needs an answer that's a number"})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckAnswer_NumericTolerance(t *testing.T) {
+	problem := Problem{Answer: "\\frac{1}{3}"}
+	if problem.CheckAnswer("0.333") {
+		t.Error("expected answers to be compared as written without a tolerance")
+	}
+
+	problem.Tolerance = &NumericTolerance{Absolute: 0.001}
+	for _, answer := range []string{"0.333", "0.3334", "1/3", "2 / 6", "\\frac{1}{3}"} {
+		if !problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` to be within 0.001 of 1/3", answer)
+		}
+	}
+	for _, answer := range []string{"0.33", "0.34", "-0.333", "1/0"} {
+		if problem.CheckAnswer(answer) {
+			t.Errorf("expected `%s` not to be within 0.001 of 1/3", answer)
+		}
+	}
+
+	// Answers are normalized before they're read as numbers
+	problem.Normalization = &NormalizationOptions{StripMathDelimiters: true, IgnoreWhitespace: true}
+	if !problem.CheckAnswer("$0.333$") {
+		t.Error("expected `$0.333$` to be within 0.001 of 1/3")
+	}
+
+	problem = Problem{Answer: "200", Tolerance: &NumericTolerance{Relative: 0.01}}
+	if !problem.CheckAnswer("198") || !problem.CheckAnswer("201.5") {
+		t.Error("expected answers within 1% of 200 to match")
+	}
+	if problem.CheckAnswer("197.9") {
+		t.Error("expected 197.9 not to be within 1% of 200")
+	}
+
+	// With no tolerance given, the number must be the same however it's written
+	problem = Problem{Answer: "0.5", Tolerance: &NumericTolerance{}}
+	if !problem.CheckAnswer("\\frac{1}{2}") || !problem.CheckAnswer("1/2") || problem.CheckAnswer("0.50001") {
+		t.Error("expected only exact numeric matches with a zero tolerance")
+	}
+}
+
+func TestCheckAnswer_NumericToleranceFallsBack(t *testing.T) {
+	problem := Problem{Answer: "0.5", AcceptableAnswers: []string{"\\text{half}"}, Tolerance: &NumericTolerance{Absolute: 0.1}}
+	// Answers that aren't numbers are compared as written
+	if !problem.CheckAnswer("\\text{half}") {
+		t.Error("expected a non-numeric acceptable answer to still match as written")
+	}
+	if problem.CheckAnswer("\\text{quarter}") || problem.CheckAnswer("x") {
+		t.Error("expected non-numeric submissions that don't match as written to be wrong")
+	}
+}
+
+func TestValidateProblems_Tolerance(t *testing.T) {
+	problems := []Problem{
+		{Title: "Fine", Description: "d", Latex: "x", Answer: "1/3", Tolerance: &NumericTolerance{Absolute: 0.01}},
+		{Title: "Negative", Description: "d", Latex: "x", Answer: "0.5", Tolerance: &NumericTolerance{Relative: -1}},
+		{Title: "Not a number", Description: "d", Latex: "x", Answer: "x^2", Tolerance: &NumericTolerance{Absolute: 0.01}},
+	}
+	expected := []ProblemError{
+		{1, "tolerance", "can't be negative"},
+		{2, "tolerance", "needs an answer that's a number"},
+	}
+	if errs := validateProblems(problems); !reflect.DeepEqual(errs, expected) {
+		t.Errorf("expected %v, got %v", expected, errs)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,11 +18,18 @@ type Verifier interface {
 	VerifyOTP(otp string) bool
 }
 
-type RetentionMap map[string]OTP
+// newOTPKey generates OTP keys; random (v4) UUIDs are drawn from crypto/rand
+var newOTPKey = uuid.NewString
+
+// RetentionMap holds the OTPs that can currently be used; it's safe for concurrent use
+type RetentionMap struct {
+	sync.Mutex
+	otps map[string]OTP
+}
 
 // NewRetentionMap will create a new retentionmap and start the retention given the set period
-func NewRetentionMap(ctx context.Context, retentionPeriod time.Duration) RetentionMap {
-	rm := make(RetentionMap)
+func NewRetentionMap(ctx context.Context, retentionPeriod time.Duration) *RetentionMap {
+	rm := &RetentionMap{otps: make(map[string]OTP)}
 
 	go rm.Retention(ctx, retentionPeriod)
 
@@ -29,40 +37,80 @@ func NewRetentionMap(ctx context.Context, retentionPeriod time.Duration) Retenti
 }
 
 // NewOTP creates and adds a new otp to the map
-func (rm RetentionMap) NewOTP() OTP {
+func (rm *RetentionMap) NewOTP() OTP {
+	return rm.newOTP(func(string) bool { return false })
+}
+
+// newOTP creates and adds a new otp to the map, whose key is neither in the map nor taken
+func (rm *RetentionMap) newOTP(taken func(key string) bool) OTP {
+	rm.Lock()
+	defer rm.Unlock()
+
+	key := newOTPKey()
+	for {
+		if _, exists := rm.otps[key]; !exists && !taken(key) {
+			break
+		}
+		// A collision is vanishingly unlikely, but would let one user connect as another
+		key = newOTPKey()
+	}
 	o := OTP{
-		Key:     uuid.NewString(),
+		Key:     key,
 		Created: time.Now(),
 	}
 
-	rm[o.Key] = o
+	rm.otps[o.Key] = o
 	return o
 }
 
 // VerifyOTP will make sure a OTP exists and return true if so
 // It will also delete the key so it can't be reused
-func (rm RetentionMap) VerifyOTP(otp string) bool {
+func (rm *RetentionMap) VerifyOTP(otp string) bool {
+	rm.Lock()
+	defer rm.Unlock()
+
 	// Verify OTP is existing
-	if _, ok := rm[otp]; !ok {
+	if _, ok := rm.otps[otp]; !ok {
 		// otp does not exist
 		return false
 	}
-	delete(rm, otp)
+	delete(rm.otps, otp)
 	return true
 }
 
+// lookup returns the OTP if it can still be used
+func (rm *RetentionMap) lookup(otp string) (OTP, bool) {
+	rm.Lock()
+	defer rm.Unlock()
+
+	o, ok := rm.otps[otp]
+	return o, ok
+}
+
+// revoke removes the OTP so it can't be used
+func (rm *RetentionMap) revoke(otp string) {
+	rm.Lock()
+	defer rm.Unlock()
+
+	delete(rm.otps, otp)
+}
+
 // Retention will make sure old OTPs are removed; this is blocking, so run as a Goroutine
-func (rm RetentionMap) Retention(ctx context.Context, retentionPeriod time.Duration) {
+// It returns once the context is cancelled, after which the map keeps working but nothing expires
+func (rm *RetentionMap) Retention(ctx context.Context, retentionPeriod time.Duration) {
 	ticker := time.NewTicker(400 * time.Millisecond)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			for _, otp := range rm {
+			rm.Lock()
+			for _, otp := range rm.otps {
 				// Add Retention to Created and check if it is expired
 				if otp.Created.Add(retentionPeriod).Before(time.Now()) {
-					delete(rm, otp.Key)
+					delete(rm.otps, otp.Key)
 				}
 			}
+			rm.Unlock()
 		case <-ctx.Done():
 			return
 		}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -41,12 +43,116 @@ func TestOTP_Retention(t *testing.T) {
 	otp := rm.NewOTP()
 
 	// Make sure that only 1 password is still left and it matches the latest
-	if len(rm) != 1 {
+	rm.Lock()
+	defer rm.Unlock()
+	if len(rm.otps) != 1 {
 		t.Error("Failed to clean up")
 	}
 
-	if rm[otp.Key] != otp {
+	if rm.otps[otp.Key] != otp {
 		t.Error("The key should still be in place")
 	}
 	cancel()
 }
+
+func TestRetentionMap_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	before := runtime.NumGoroutine()
+	rm := NewRetentionMap(ctx, 1*time.Second)
+	if runtime.NumGoroutine() <= before {
+		t.Fatal("expected the retention goroutine to be running")
+	}
+
+	cancel()
+
+	// Give the goroutine a chance to observe the cancellation
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if runtime.NumGoroutine() > before {
+		t.Error("retention goroutine is still running after the context was cancelled")
+	}
+
+	// The map is still usable, OTPs just no longer expire
+	otp := rm.NewOTP()
+	if ok := rm.VerifyOTP(otp.Key); !ok {
+		t.Error("failed to verify otp key after cancellation")
+	}
+	if ok := rm.VerifyOTP(otp.Key); ok {
+		t.Error("Reusing a OTP should not succeed after cancellation")
+	}
+}
+
+func TestRetentionMap_NoDuplicates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rm := NewRetentionMap(ctx, time.Minute)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		otp := rm.NewOTP()
+		if seen[otp.Key] {
+			t.Fatalf("OTP %s was generated twice", otp.Key)
+		}
+		seen[otp.Key] = true
+	}
+}
+
+func TestRetentionMap_Collision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lobby := NewLobby(ctx, "test", "test-lobby")
+
+	// The generator repeats itself, so every key after the first collides at least once
+	keys := []string{"a", "a", "b", "a", "b", "c"}
+	previous := newOTPKey
+	newOTPKey = func() string {
+		key := keys[0]
+		keys = keys[1:]
+		return key
+	}
+	defer func() { newOTPKey = previous }()
+
+	first := lobby.issueOTP("alice")
+	second := lobby.issueOTP("bob")
+	third := lobby.issueOTP("carol")
+	if first.Key != "a" || second.Key != "b" || third.Key != "c" {
+		t.Errorf("expected the keys a, b, c, got %s, %s, %s", first.Key, second.Key, third.Key)
+	}
+	if lobby.otpUsername("a") != "alice" {
+		t.Errorf("expected a collision not to reassign alice's OTP, got %s", lobby.otpUsername("a"))
+	}
+}
+
+func TestRetentionMap_ConcurrentNewOTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lobby := NewLobby(ctx, "test", "test-lobby")
+
+	// Run with -race to check for unsafe access
+	var wg sync.WaitGroup
+	keys := make(chan string, 1000)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				otp := lobby.issueOTP("alice")
+				keys <- otp.Key
+				lobby.otps.VerifyOTP(otp.Key)
+			}
+		}()
+	}
+	wg.Wait()
+	close(keys)
+
+	seen := make(map[string]bool)
+	for key := range keys {
+		if seen[key] {
+			t.Fatalf("OTP %s was generated twice", key)
+		}
+		seen[key] = true
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PayloadError is returned when an event's payload can't be decoded; the client is told what was wrong with it
+type PayloadError struct {
+	Event   string
+	Problem string
+}
+
+func (e *PayloadError) Error() string {
+	return fmt.Sprintf("bad %s payload: %s", e.Event, e.Problem)
+}
+
+// requiredFielder is implemented by payloads with fields that can't be left out
+type requiredFielder interface {
+	requiredFields() []string
+}
+
+// decode unmarshals the event's payload, describing what's wrong with it if it's missing, malformed,
+// has a field of the wrong type, or leaves out a required field
+func decode[T any](event Event) (T, error) {
+	var payload T
+	return decodeOnto(event, payload)
+}
+
+// decodeOnto is decode, except the fields the payload leaves out keep their values from payload
+func decodeOnto[T any](event Event, payload T) (T, error) {
+	if len(bytes.TrimSpace(event.Payload)) == 0 || bytes.Equal(bytes.TrimSpace(event.Payload), []byte("null")) {
+		return payload, &PayloadError{event.Type, "it's missing"}
+	}
+
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return payload, &PayloadError{event.Type, fmt.Sprintf("%s must be a %s (got %s)", typeErr.Field, typeErr.Type, typeErr.Value)}
+		}
+		return payload, &PayloadError{event.Type, fmt.Sprintf("it isn't valid: %v", err)}
+	}
+
+	if required, ok := any(payload).(requiredFielder); ok {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(event.Payload, &fields); err != nil {
+			return payload, &PayloadError{event.Type, "it must be an object"}
+		}
+		for _, field := range required.requiredFields() {
+			if _, ok := fields[field]; !ok {
+				return payload, &PayloadError{event.Type, fmt.Sprintf("%s is missing", field)}
+			}
+		}
+	}
+	return payload, nil
+}
+
+func (AnswerEvent) requiredFields() []string            { return []string{"answer"} }
+func (SendMessageEvent) requiredFields() []string       { return []string{"message"} }
+func (SetChatFilterEvent) requiredFields() []string     { return []string{"enabled"} }
+func (TransferOwnershipEvent) requiredFields() []string { return []string{"name"} }
+func (SetReadyEvent) requiredFields() []string          { return []string{"ready"} }
+func (ChangeNameEvent) requiredFields() []string        { return []string{"name"} }
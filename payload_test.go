@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		problem string
+	}{
+		{"valid", `{"answer": "x^2"}`, ""},
+		{"missing payload", ``, "it's missing"},
+		{"null payload", `null`, "it's missing"},
+		{"malformed", `{"answer": `, "it isn't valid"},
+		{"wrong type", `{"answer": 5}`, "answer must be a string (got number)"},
+		{"missing field", `{"response": "x^2"}`, "answer is missing"},
+	}
+	for _, test := range tests {
+		answer, err := decode[AnswerEvent](Event{EventGiveAnswer, json.RawMessage(test.payload)})
+		if test.problem == "" {
+			if err != nil || answer.Answer != "x^2" {
+				t.Errorf("%s: expected the answer to be decoded, got %+v (%v)", test.name, answer, err)
+			}
+			continue
+		}
+		var payloadErr *PayloadError
+		if !errors.As(err, &payloadErr) || !strings.Contains(payloadErr.Problem, test.problem) {
+			t.Errorf("%s: expected an error saying %q, got %v", test.name, test.problem, err)
+		}
+	}
+}
+
+func TestRouteEvent_MalformedPayloadSendsError(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "abc", Answer: "abc"}})
+	alice := addTestClient(lobby, "alice")
+	lobby.startGame(time.Now())
+
+	err := alice.manager.routeEvent(Event{EventGiveAnswer, json.RawMessage(`{"answer": ["abc"]}`)}, alice)
+	if err == nil {
+		t.Fatal("expected the malformed answer to be rejected")
+	}
+	events := drainEvents(alice)
+	if len(events) != 1 || events[0].Type != EventError {
+		t.Fatalf("expected an error event, got %v", events)
+	}
+	var errorEvent ErrorEvent
+	json.Unmarshal(events[0].Payload, &errorEvent)
+	if errorEvent.Message != "bad give_answer payload: answer must be a string (got array)" {
+		t.Errorf("expected a descriptive error, got %q", errorEvent.Message)
+	}
+	if user := lobby.userMapping["alice"]; user.totalAnswers != 0 {
+		t.Errorf("expected the malformed answer not to count, got %+v", user)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var (
+	practiceSet *Problems
+	// practiceLock guards practiceSet, which is loaded the first time anyone practices
+	practiceLock sync.Mutex
+	// practiceFile is where practice problems are loaded from, apart from the games' problems (empty disables
+	// practice)
+	practiceFile = "practice.json"
+)
+
+// practicing reports whether players can practice in the lobby right now: before the game starts,
+// if the lobby allows it
+func (lobby *Lobby) practicing() bool {
+	lobby.RLock()
+	defer lobby.RUnlock()
+
+	return lobby.practiceOpen()
+}
+
+// practiceOpen is practicing, for when the lobby's lock is already held
+// @dev Requires the lobby's lock to be held
+func (lobby *Lobby) practiceOpen() bool {
+	return lobby.allowPractice && lobby.gameState == WaitingForPlayers
+}
+
+// getPracticeSet returns the practice problems, loading them the first time they're needed
+func getPracticeSet() *Problems {
+	practiceLock.Lock()
+	defer practiceLock.Unlock()
+
+	if practiceSet == nil && practiceFile != "" {
+		loaded, err := LoadProblems(practiceFile)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		practiceSet = loaded
+	}
+	return practiceSet
+}
+
+// practiceProblems is the pool the lobby's practice problems are drawn from. Templates are left out, since
+// practice doesn't keep track of players' variants, as is anything the lobby's game could serve
+func (lobby *Lobby) practiceProblems() []Problem {
+	practice := getPracticeSet()
+	if practice == nil {
+		return nil
+	}
+	lobby.RLock()
+	problems := lobby.getLobbyProblems()
+	lobby.RUnlock()
+	inGame := make(map[string]bool)
+	for _, problem := range problems {
+		inGame[problem.Latex] = true
+	}
+	pool := make([]Problem, 0, len(practice.Problems))
+	for _, problem := range practice.Problems {
+		if !problem.isTemplate() && !inGame[problem.Latex] {
+			pool = append(pool, problem)
+		}
+	}
+	return pool
+}
+
+// sendPracticeProblem sends the client their current practice problem
+func (client *Client) sendPracticeProblem() error {
+	pool := client.lobby.practiceProblems()
+	if len(pool) == 0 {
+		return client.sendError("there are no practice problems")
+	}
+	client.lobby.RLock()
+	problem := pool[client.lobby.userMapping[client.name].practiceNumber%len(pool)]
+	client.lobby.RUnlock()
+
+	data, err := json.Marshal(NewProblemEvent{Problem: problem.withoutAnswer(), Practice: true})
+	if err != nil {
+		return fmt.Errorf("failed to marshal practice problem: %v", err)
+	}
+	client.egress <- Event{EventNewProblem, data}
+	return nil
+}
+
+// givePracticeAnswer checks an answer to the client's practice problem, moving them on to the next one
+// if it's right. Practice never scores
+func (client *Client) givePracticeAnswer(event Event) error {
+	answerevent, err := decode[AnswerEvent](event)
+	if err != nil {
+		return err
+	}
+	if len(answerevent.Answer) > config.MaxAnswerLength {
+		return client.sendError(fmt.Sprintf("answers can be at most %d characters long", config.MaxAnswerLength))
+	}
+	pool := client.lobby.practiceProblems()
+	if len(pool) == 0 {
+		return client.sendError("there are no practice problems")
+	}
+
+	lobby := client.lobby
+	lobby.RLock()
+	practiceNumber := lobby.userMapping[client.name].practiceNumber
+	lobby.RUnlock()
+	if !pool[practiceNumber%len(pool)].CheckAnswer(answerevent.Answer) {
+		client.egress <- Event{EventWrongAnswer, nil}
+		return nil
+	}
+
+	lobby.Lock()
+	if !lobby.practiceOpen() {
+		// The game started while the answer was being checked
+		lobby.Unlock()
+		return client.sendError("practice is over, the game has started")
+	}
+	user := lobby.userMapping[client.name]
+	if user.practiceNumber != practiceNumber {
+		// e.g. a double click; the first answer already moved them on
+		lobby.Unlock()
+		return nil
+	}
+	user.practiceNumber++
+	lobby.userMapping[client.name] = user
+	lobby.Unlock()
+	return client.sendPracticeProblem()
+}
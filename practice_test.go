@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// usePracticeFile points practice at a practice problem set with the given contents for the test
+func usePracticeFile(t *testing.T, contents string) {
+	path := filepath.Join(t.TempDir(), "practice.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previousFile, previousSet := practiceFile, practiceSet
+	practiceFile = path
+	// The file is loaded the next time anyone practices
+	practiceSet = nil
+	t.Cleanup(func() {
+		practiceFile = previousFile
+		practiceSet = previousSet
+	})
+}
+
+// practiceProblem returns the practice problem sent to the client, failing if none was sent
+func practiceProblem(t *testing.T, c *Client) Problem {
+	t.Helper()
+	for _, e := range drainEvents(c) {
+		if e.Type == EventNewProblem {
+			var problem NewProblemEvent
+			if err := json.Unmarshal(e.Payload, &problem); err != nil {
+				t.Fatal(err)
+			}
+			if !problem.Practice {
+				t.Error("expected the problem to be marked as practice")
+			}
+			return problem.Problem
+		}
+	}
+	t.Fatal("expected a practice problem")
+	return Problem{}
+}
+
+func TestPractice_WaitingRoom(t *testing.T) {
+	usePracticeFile(t, `{"problems": [
+		{"title": "Practice One", "description": "d", "latex": "x", "answer": "x"},
+		{"title": "Practice Two", "description": "d", "latex": "y", "answer": "y"}
+	]}`)
+	lobby := newTestLobby(t, []Problem{{Title: "Real", Latex: "abc", Answer: "abc"}})
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+
+	// Practice has to be turned on for the lobby
+	if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err == nil {
+		t.Error("expected practice to be unavailable by default")
+	}
+	lobby.allowPractice = true
+
+	if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err != nil {
+		t.Fatal(err)
+	}
+	if problem := practiceProblem(t, alice); problem.Title != "Practice One" || problem.Answer != "" {
+		t.Errorf("expected the first practice problem without its answer, got %+v", problem)
+	}
+
+	if err := giveAnswer(t, alice, "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if events := drainEvents(alice); countEvents(events, EventWrongAnswer) != 1 {
+		t.Errorf("expected a wrong practice answer to be flagged, got %v", events)
+	}
+	if err := giveAnswer(t, alice, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if problem := practiceProblem(t, alice); problem.Title != "Practice Two" {
+		t.Errorf("expected a right answer to move on to the next practice problem, got %s", problem.Title)
+	}
+
+	// Practice never touches the scoreboard
+	user := lobby.userMapping["alice"]
+	if user.score != 0 || user.answered != 0 || user.totalAnswers != 0 {
+		t.Errorf("expected practice not to score, got %+v", user)
+	}
+	if events := drainEvents(bob); countEvents(events, EventNewScoreUpdate) != 0 {
+		t.Error("expected practice answers not to be broadcast")
+	}
+}
+
+func TestPractice_ResetAtGameStart(t *testing.T) {
+	usePracticeFile(t, `{"problems": [
+		{"title": "Practice One", "description": "d", "latex": "x", "answer": "x"},
+		{"title": "Practice Two", "description": "d", "latex": "y", "answer": "y"}
+	]}`)
+	lobby := newTestLobby(t, []Problem{{Title: "Real", Latex: "abc", Answer: "abc"}})
+	lobby.allowPractice = true
+	alice := addTestClient(lobby, "alice")
+	giveAnswer(t, alice, "x")
+	if lobby.userMapping["alice"].practiceNumber != 1 {
+		t.Fatal("expected alice to have made progress in practice")
+	}
+
+	lobby.startGame(time.Now())
+	if user := lobby.userMapping["alice"]; user.practiceNumber != 0 || user.questionNumber != 0 {
+		t.Errorf("expected practice progress to be cleared when the game starts, got %+v", user)
+	}
+
+	// Answers now go to the real game
+	if err := giveAnswer(t, alice, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if lobby.userMapping["alice"].score == 0 {
+		t.Error("expected the real answer to score")
+	}
+}
+
+func TestPractice_KeepsGameProblemsSecret(t *testing.T) {
+	usePracticeFile(t, `{"problems": [
+		{"title": "Practice", "description": "d", "latex": "x", "answer": "x"},
+		{"title": "Also in the game", "description": "d", "latex": "abc", "answer": "abc"}
+	]}`)
+	lobby := newTestLobby(t, []Problem{{Title: "Real", Latex: "abc", Answer: "abc"}})
+	lobby.allowPractice = true
+	alice := addTestClient(lobby, "alice")
+
+	// The game's problem is never served, however far alice gets
+	for i := 0; i < 3; i++ {
+		if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err != nil {
+			t.Fatal(err)
+		}
+		if problem := practiceProblem(t, alice); problem.Latex == "abc" {
+			t.Fatal("expected practice not to give away the game's problems")
+		}
+		giveAnswer(t, alice, "x")
+	}
+}
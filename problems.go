@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProblemError describes what's wrong with a single problem in a set
+type ProblemError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ProblemError) Error() string {
+	return fmt.Sprintf("problem %d: %s %s", e.Index, e.Field, e.Message)
+}
+
+// ProblemValidationError is returned when a problem set fails validation, with everything wrong with it
+type ProblemValidationError struct {
+	Path   string
+	Errors []ProblemError
+}
+
+func (e *ProblemValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		problems[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid problems in %s: %s", e.Path, strings.Join(problems, "; "))
+}
+
+// validateProblems checks every problem in the set, returning all the errors found (empty if valid)
+func validateProblems(problems []Problem) []ProblemError {
+	errs := make([]ProblemError, 0)
+	if len(problems) == 0 {
+		errs = append(errs, ProblemError{-1, "problems", "must contain at least one problem"})
+	}
+	for i, p := range problems {
+		if strings.TrimSpace(p.Title) == "" {
+			errs = append(errs, ProblemError{i, "title", "must not be empty"})
+		}
+		if strings.TrimSpace(p.Description) == "" {
+			errs = append(errs, ProblemError{i, "description", "must not be empty"})
+		}
+		if strings.TrimSpace(p.Latex) == "" {
+			errs = append(errs, ProblemError{i, "latex", "must not be empty"})
+		} else if err := checkLatex(p.Latex); err != nil {
+			errs = append(errs, ProblemError{i, "latex", err.Error()})
+		}
+		if p.Answer != "" {
+			if err := checkLatex(p.Answer); err != nil {
+				errs = append(errs, ProblemError{i, "answer", err.Error()})
+			}
+		}
+		for j, answer := range p.AcceptableAnswers {
+			if err := checkLatex(answer); err != nil {
+				errs = append(errs, ProblemError{i, fmt.Sprintf("acceptableAnswers[%d]", j), err.Error()})
+			}
+		}
+		if p.Tolerance != nil {
+			errs = append(errs, validateTolerance(i, p)...)
+		}
+		if p.isTemplate() {
+			errs = append(errs, validateTemplate(i, p)...)
+		}
+	}
+	return errs
+}
+
+// checkLatex does a light syntactic check of a LaTeX string: braces and \left/\right must balance
+func checkLatex(latex string) error {
+	depth := 0
+	leftRight := 0
+	for i := 0; i < len(latex); i++ {
+		switch latex[i] {
+		case '\\':
+			rest := latex[i+1:]
+			if strings.HasPrefix(rest, "left") && !startsWithLetter(rest[len("left"):]) {
+				leftRight++
+			} else if strings.HasPrefix(rest, "right") && !startsWithLetter(rest[len("right"):]) {
+				leftRight--
+				if leftRight < 0 {
+					return fmt.Errorf("has a \\right without a matching \\left")
+				}
+			}
+			// Skip the escaped character (e.g. \{ or \\)
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("has an unmatched '}'")
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("has an unmatched '{'")
+	}
+	if leftRight != 0 {
+		return fmt.Errorf("has a \\left without a matching \\right")
+	}
+	return nil
+}
+
+func startsWithLetter(s string) bool {
+	return s != "" && (s[0] >= 'a' && s[0] <= 'z' || s[0] >= 'A' && s[0] <= 'Z')
+}
+
+// validateOrder checks that the order is a permutation of [0, n): every index exactly once
+func validateOrder(order []int, n int) error {
+	if len(order) != n {
+		return fmt.Errorf("has %d entries but there are %d problems", len(order), n)
+	}
+	seen := make([]bool, n)
+	for _, i := range order {
+		if i < 0 || i >= n {
+			return fmt.Errorf("index %d is out of range [0, %d)", i, n)
+		}
+		if seen[i] {
+			return fmt.Errorf("index %d appears more than once", i)
+		}
+		seen[i] = true
+	}
+	return nil
+}
+
+// checkCustomProblemsSize makes sure a custom problem set is within the server's limits, so one lobby can't
+// take up too much memory (or space in snapshots)
+func checkCustomProblemsSize(problems []Problem) error {
+	if len(problems) > config.MaxCustomProblems {
+		return fmt.Errorf("custom problem sets can have at most %d problems, not %d", config.MaxCustomProblems, len(problems))
+	}
+	data, err := json.Marshal(problems)
+	if err != nil {
+		return err
+	}
+	if len(data) > config.MaxCustomProblemsBytes {
+		return fmt.Errorf("custom problem sets can be at most %d bytes, not %d", config.MaxCustomProblemsBytes, len(data))
+	}
+	return nil
+}
+
+// validateCustomProblemsHandler checks a custom problem set without storing it, so owners can fix it before starting
+func (m *Manager) validateCustomProblemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req Problems
+	if err := decodeRequest(r, &req); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := checkCustomProblemsSize(req.Problems); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	type response struct {
+		Valid  bool           `json:"valid"`
+		Errors []ProblemError `json:"errors"`
+	}
+	errs := validateProblems(req.Problems)
+	resp := response{
+		Valid:  len(errs) == 0,
+		Errors: errs,
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// MetadataCount is how many problems in a set have a tag or difficulty
+type MetadataCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// ProblemMetadata lists the tags and difficulties present in a problem set, for filtering
+type ProblemMetadata struct {
+	Tags         []MetadataCount `json:"tags"`
+	Difficulties []MetadataCount `json:"difficulties"`
+}
+
+// problemMetadata counts the problems with each tag and difficulty
+func problemMetadata(problems []Problem) ProblemMetadata {
+	tags := make(map[string]int)
+	difficulties := make(map[string]int)
+	for _, p := range problems {
+		for _, tag := range p.Tags {
+			tags[tag]++
+		}
+		if p.Difficulty != "" {
+			difficulties[p.Difficulty]++
+		}
+	}
+	return ProblemMetadata{sortedCounts(tags), sortedCounts(difficulties)}
+}
+
+// sortedCounts turns the counts into a list ordered by name
+func sortedCounts(counts map[string]int) []MetadataCount {
+	sorted := make([]MetadataCount, 0, len(counts))
+	for name, count := range counts {
+		sorted = append(sorted, MetadataCount{name, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// problemsMetadataHandler returns the tags and difficulties in the default problem set, so lobbies can be
+// created with filters
+func problemsMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	loaded := GetProblems()
+	if loaded == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(loaded.metadata)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// problemHandler returns the problem at /problems/{index} in the default problem set. Its answer (and hints) are
+// only included for admins, since anyone can ask
+func problemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	loaded := GetProblems()
+	if loaded == nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	index, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/problems/"))
+	if err != nil || index < 0 || index >= len(loaded.Problems) {
+		http.Error(w, "no such problem", http.StatusNotFound)
+		return
+	}
+
+	problem := loaded.Problems[index]
+	// An empty admin token disables admin access, rather than matching requests without one
+	if config.AdminToken == "" || !isAdmin(r) {
+		problem = problem.withoutAnswer()
+	}
+	data, err := json.Marshal(problem)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// CSV_REQUIRED_COLUMNS are the columns every CSV of problems must have
+var CSV_REQUIRED_COLUMNS = []string{"title", "description", "latex"}
+
+// parseProblemsCSV reads problems from a CSV with a header row naming its columns (in any order): title,
+// description, latex, answer, difficulty and tags (separated by semicolons). The problems aren't validated
+func parseProblemsCSV(r io.Reader) ([]Problem, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the CSV is empty")
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range CSV_REQUIRED_COLUMNS {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the CSV is missing the %s column", name)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	problems := make([]Problem, 0)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		problem := Problem{
+			Title:       field(row, "title"),
+			Description: field(row, "description"),
+			Latex:       field(row, "latex"),
+			Answer:      field(row, "answer"),
+			Difficulty:  field(row, "difficulty"),
+		}
+		for _, tag := range strings.Split(field(row, "tags"), ";") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				problem.Tags = append(problem.Tags, tag)
+			}
+		}
+		problems = append(problems, problem)
+	}
+	return problems, nil
+}
+
+// importProblemsCSVHandler converts a CSV of problems (e.g. exported from a spreadsheet) into a problem set,
+// returning the set if every row is valid, or every row's errors if not
+func importProblemsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	problems, err := parseProblemsCSV(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkCustomProblemsSize(problems); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := http.StatusOK
+	var resp interface{} = Problems{Problems: problems}
+	if errs := validateProblems(problems); len(errs) > 0 {
+		status = http.StatusUnprocessableEntity
+		resp = struct {
+			Errors []ProblemError `json:"errors"`
+		}{errs}
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// playedProblems returns the problems the lobby's game is played with, in order
+func (l *Lobby) playedProblems() []Problem {
+	problems := l.getLobbyProblems()
+	played := make([]Problem, len(l.CustomOrder))
+	for i, index := range l.CustomOrder {
+		played[i] = problems[index]
+	}
+	return played
+}
+
+// lobbyProblemsHandler returns the problem set a lobby's game is played with, in the same format as problem
+// files so it can be reused. Answers (and hints) are only included once the game is over
+func (m *Manager) lobbyProblemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("l")
+	if id == "" || filepath.Base(id) != id {
+		http.Error(w, "invalid lobby id", http.StatusBadRequest)
+		return
+	}
+
+	var data []byte
+	var err error
+	if lobby, lobbyExists := m.getLobby(id); lobbyExists {
+		lobby.RLock()
+		if lobby.gameState == WaitingForPlayers {
+			lobby.RUnlock()
+			http.Error(w, "the game hasn't started yet", http.StatusConflict)
+			return
+		}
+		played := lobby.playedProblems()
+		lobby.RUnlock()
+
+		for i := range played {
+			played[i] = played[i].withoutAnswer()
+		}
+		data, err = json.Marshal(Problems{Problems: played})
+	} else {
+		// Finished games are only kept on disk
+		data, err = os.ReadFile(filepath.Join(logsDirectory, id+".problems.json"))
+		if errors.Is(err, os.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateCustomProblemsHandler(t *testing.T) {
+	manager := NewManager(context.Background())
+
+	body := `{"problems": [
+		{"title": "Fine", "description": "ok", "latex": "\\frac{1}{2}"},
+		{"title": "", "description": "no title", "latex": "x"},
+		{"title": "Braces", "description": "unbalanced", "latex": "\\frac{1}{2"},
+		{"title": "Delimiters", "description": "escaped braces", "latex": "\\left\\{ x \\right\\}"},
+		{"title": "Answer", "description": "bad answer", "latex": "x", "answer": "\\left( x"}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/lobby/custom/validate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	manager.validateCustomProblemsHandler(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Valid  bool           `json:"valid"`
+		Errors []ProblemError `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ProblemError{
+		{1, "title", "must not be empty"},
+		{2, "latex", "has an unmatched '{'"},
+		{4, "answer", "has a \\left without a matching \\right"},
+	}
+	if resp.Valid {
+		t.Error("expected the set to be invalid")
+	}
+	if len(resp.Errors) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), resp.Errors)
+	}
+	for i, e := range expected {
+		if resp.Errors[i] != e {
+			t.Errorf("expected error %v, got %v", e, resp.Errors[i])
+		}
+	}
+}
+
+func TestValidateProblems_DefaultSet(t *testing.T) {
+	problems := GetProblems()
+	if problems == nil {
+		t.Fatal("failed to load problems.json")
+	}
+	if errs := validateProblems(problems.Problems); len(errs) > 0 {
+		t.Errorf("expected the default problem set to be valid, got %v", errs)
+	}
+}
+
+func TestValidateOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		order []int
+		valid bool
+	}{
+		{"permutation", []int{2, 0, 1}, true},
+		{"duplicate", []int{0, 0, 1}, false},
+		{"out of range", []int{0, 1, 3}, false},
+		{"negative", []int{-1, 0, 1}, false},
+		{"too short", []int{0, 1}, false},
+	}
+	for _, test := range tests {
+		if err := validateOrder(test.order, 3); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid=%v, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+// useProblemsFile points the default problem set at a temporary file with the given contents
+func useProblemsFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "problems.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previousFile, previousProblems := problemsFile, problems
+	problemsFile = path
+	// The file is loaded the next time the problems are needed
+	problems = nil
+	t.Cleanup(func() {
+		problemsFile = previousFile
+		problems = previousProblems
+	})
+	return path
+}
+
+func TestReloadProblems(t *testing.T) {
+	path := useProblemsFile(t, `{"problems": [{"title": "Old", "description": "d", "latex": "x", "answer": "x"}]}`)
+	if err := ReloadProblems(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A game in progress keeps the set it started with
+	lobby := newTestLobby(t, nil)
+	lobby.useCustom = false
+	lobby.problems = GetProblems().Problems
+
+	if err := os.WriteFile(path, []byte(`{"problems": [{"title": "New", "description": "d", "latex": "y", "answer": "y"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadProblems(); err != nil {
+		t.Fatal(err)
+	}
+	if title := GetProblems().Problems[0].Title; title != "New" {
+		t.Errorf("expected new games to use the reloaded set, got %s", title)
+	}
+	if title := lobby.getLobbyProblems()[0].Title; title != "Old" {
+		t.Errorf("expected the game in progress to keep its set, got %s", title)
+	}
+}
+
+func TestReloadProblems_InvalidFile(t *testing.T) {
+	path := useProblemsFile(t, `{"problems": [{"title": "Old", "description": "d", "latex": "x", "answer": "x"}]}`)
+	if err := ReloadProblems(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contents := range []string{`{"problems": [`, `{"problems": [{"title": "", "latex": "\\frac{1"}]}`} {
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ReloadProblems(); err == nil {
+			t.Errorf("expected %s to be rejected", contents)
+		}
+		if title := GetProblems().Problems[0].Title; title != "Old" {
+			t.Errorf("expected the old set to be kept, got %s", title)
+		}
+	}
+}
+
+func getLobbyProblems(t *testing.T, manager *Manager, id string) (*httptest.ResponseRecorder, Problems) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/lobby/problems?l="+id, nil)
+	rec := httptest.NewRecorder()
+	manager.lobbyProblemsHandler(rec, req)
+
+	var problems Problems
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &problems); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, problems
+}
+
+func TestLobbyProblemsHandler(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc", Hints: []string{"a"}},
+		{Title: "Two", Latex: "def", Answer: "def"},
+		{Title: "Unplayed", Latex: "ghi", Answer: "ghi"},
+	})
+	lobby.CustomOrder = []int{1, 0}
+	manager := testManagers[lobby]
+
+	if rec, _ := getLobbyProblems(t, manager, lobby.id); rec.Code != http.StatusConflict {
+		t.Errorf("expected no problems before the game starts, got %d", rec.Code)
+	}
+
+	lobby.startGame(time.Now())
+	rec, problems := getLobbyProblems(t, manager, lobby.id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if len(problems.Problems) != 2 || problems.Problems[0].Title != "Two" || problems.Problems[1].Title != "One" {
+		t.Fatalf("expected the played problems in order, got %+v", problems.Problems)
+	}
+	for _, problem := range problems.Problems {
+		if problem.Answer != "" || len(problem.Hints) != 0 {
+			t.Errorf("expected answers to be hidden during the game, got %+v", problem)
+		}
+	}
+
+	manager.finishGame(lobby, "Game over!")
+	rec, problems = getLobbyProblems(t, manager, lobby.id)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the problems to still be available after the game, got %d", rec.Code)
+	}
+	if len(problems.Problems) != 2 || problems.Problems[0].Answer != "def" || len(problems.Problems[1].Hints) != 1 {
+		t.Errorf("expected answers to be included after the game, got %+v", problems.Problems)
+	}
+
+	if rec, _ := getLobbyProblems(t, manager, "nope"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown lobby, got %d", rec.Code)
+	}
+	if rec, _ := getLobbyProblems(t, manager, "../secrets"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a path, got %d", rec.Code)
+	}
+}
+
+// getProblemsMetadata fetches the default problem set's metadata
+func getProblemsMetadata(t *testing.T) ProblemMetadata {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	problemsMetadataHandler(rec, httptest.NewRequest(http.MethodGet, "/problems/metadata", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var metadata ProblemMetadata
+	if err := json.Unmarshal(rec.Body.Bytes(), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	return metadata
+}
+
+func TestProblemsMetadataHandler(t *testing.T) {
+	path := useProblemsFile(t, `{"problems": [
+		{"title": "A", "description": "d", "latex": "x", "tags": ["algebra", "calculus"], "difficulty": "easy"},
+		{"title": "B", "description": "d", "latex": "y", "tags": ["calculus"], "difficulty": "hard"},
+		{"title": "C", "description": "d", "latex": "z", "difficulty": "easy"},
+		{"title": "D", "description": "d", "latex": "w"}
+	]}`)
+
+	metadata := getProblemsMetadata(t)
+	expected := ProblemMetadata{
+		Tags:         []MetadataCount{{"algebra", 1}, {"calculus", 2}},
+		Difficulties: []MetadataCount{{"easy", 2}, {"hard", 1}},
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %+v, got %+v", expected, metadata)
+	}
+
+	// Reloading the problems recomputes the metadata
+	if err := os.WriteFile(path, []byte(`{"problems": [{"title": "E", "description": "d", "latex": "x", "tags": ["geometry"]}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadProblems(); err != nil {
+		t.Fatal(err)
+	}
+	metadata = getProblemsMetadata(t)
+	expected = ProblemMetadata{
+		Tags:         []MetadataCount{{"geometry", 1}},
+		Difficulties: []MetadataCount{},
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("expected %+v after reloading, got %+v", expected, metadata)
+	}
+}
+
+// getProblem fetches the default problem set's problem at the index, with the admin token if it's given
+func getProblem(t *testing.T, index string, token string) (*httptest.ResponseRecorder, Problem) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/problems/"+index, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	problemHandler(rec, req)
+	var problem Problem
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, problem
+}
+
+func TestProblemHandler(t *testing.T) {
+	useProblemsFile(t, `{"problems": [
+		{"title": "A", "description": "d", "latex": "x", "answer": "x"},
+		{"title": "B", "description": "d", "latex": "y^2", "answer": "y^2", "hints": ["square it"]}
+	]}`)
+	useAdminToken(t, "secret")
+
+	rec, problem := getProblem(t, "1", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if problem.Title != "B" || problem.Answer != "y^2" || len(problem.Hints) != 1 {
+		t.Errorf("expected admins to be sent the problem with its answer, got %+v", problem)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		rec, problem = getProblem(t, "1", token)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+		if problem.Title != "B" || problem.Answer != "" || len(problem.Hints) != 0 {
+			t.Errorf("expected the answer to be left out without the admin token, got %+v", problem)
+		}
+	}
+
+	for _, index := range []string{"2", "-1", "first"} {
+		if rec, _ := getProblem(t, index, "secret"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404 for problem %q, got %d", index, rec.Code)
+		}
+	}
+}
+
+func TestLoadProblems_ReportsEveryError(t *testing.T) {
+	path := useProblemsFile(t, `{"problems": [
+		{"title": "", "description": "d", "latex": "x"},
+		{"title": "No description", "description": "", "latex": "x"},
+		{"title": "Fine", "description": "d", "latex": "x"},
+		{"title": "Unbalanced", "description": "d", "latex": "\\frac{a}{b"},
+		{"title": "Bad answer", "description": "d", "latex": "x", "answer": "\\left( x"}
+	]}`)
+
+	_, err := LoadProblems(path)
+	var validationErr *ProblemValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ProblemValidationError, got %v", err)
+	}
+	reported := make([]string, len(validationErr.Errors))
+	for i, problemErr := range validationErr.Errors {
+		reported[i] = fmt.Sprintf("%d %s", problemErr.Index, problemErr.Field)
+	}
+	expected := []string{"0 title", "1 description", "3 latex", "4 answer"}
+	if !reflect.DeepEqual(reported, expected) {
+		t.Errorf("expected errors for %v, got %v", expected, reported)
+	}
+	for _, problemErr := range validationErr.Errors {
+		if !strings.Contains(err.Error(), problemErr.Error()) {
+			t.Errorf("expected the message to include %q, got %q", problemErr.Error(), err.Error())
+		}
+	}
+}
+
+// importProblemsCSV posts the CSV to the import endpoint
+func importProblemsCSV(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	importProblemsCSVHandler(rec, httptest.NewRequest(http.MethodPost, "/problems/import/csv", strings.NewReader(body)))
+	return rec
+}
+
+func TestImportProblemsCSVHandler(t *testing.T) {
+	rec := importProblemsCSV(t, "Title,Description,LaTeX,Answer,Difficulty,Tags\n"+
+		"Fractions,Classic.,\\frac{1}{2},,easy,algebra; fractions\n"+
+		"\"Sum, squared\",\"With a comma\",(a+b)^2,(a+b)^2,hard,\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var problems Problems
+	if err := json.Unmarshal(rec.Body.Bytes(), &problems); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Problem{
+		{Title: "Fractions", Description: "Classic.", Latex: "\\frac{1}{2}", Difficulty: "easy", Tags: []string{"algebra", "fractions"}},
+		{Title: "Sum, squared", Description: "With a comma", Latex: "(a+b)^2", Answer: "(a+b)^2", Difficulty: "hard"},
+	}
+	if !reflect.DeepEqual(problems.Problems, expected) {
+		t.Errorf("expected %+v, got %+v", expected, problems.Problems)
+	}
+}
+
+func TestImportProblemsCSVHandler_MissingColumn(t *testing.T) {
+	rec := importProblemsCSV(t, "title,latex,answer\nFractions,\\frac{1}{2},\n")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "description") {
+		t.Errorf("expected the missing description column to be reported, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestImportProblemsCSVHandler_InvalidRow(t *testing.T) {
+	rec := importProblemsCSV(t, "title,description,latex,answer\n"+
+		"Fine,ok,x,x\n"+
+		"Bad answer,oops,x,\\left( x\n")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Errors []ProblemError `json:"errors"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ProblemError{{1, "answer", "has a \\left without a matching \\right"}}
+	if !reflect.DeepEqual(resp.Errors, expected) {
+		t.Errorf("expected %v, got %v", expected, resp.Errors)
+	}
+}
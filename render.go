@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// MAX_RENDER_RESPONSE_SIZE is the largest response read from the render hook
+const MAX_RENDER_RESPONSE_SIZE = 64 << 10
+
+// renderHookRequest is what's sent to the render hook
+type renderHookRequest struct {
+	Latex string `json:"latex"`
+}
+
+// renderHookResponse is what the render hook replies with: the URL of an image (e.g. an SVG) of the LaTeX
+type renderHookResponse struct {
+	URL string `json:"url"`
+}
+
+// renderClient calls the render hook; each call has its own timeout
+var renderClient = &http.Client{}
+
+// callRenderHook asks the render hook at the URL for an image of the LaTeX, returning the image's URL
+func callRenderHook(hookURL string, timeout time.Duration, latex string) (string, error) {
+	body, err := json.Marshal(renderHookRequest{latex})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hookURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := renderClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("render hook responded with %s", resp.Status)
+	}
+	var rendered renderHookResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, MAX_RENDER_RESPONSE_SIZE)).Decode(&rendered); err != nil {
+		return "", fmt.Errorf("render hook's response isn't valid: %v", err)
+	} else if rendered.URL == "" {
+		return "", fmt.Errorf("render hook didn't give a URL")
+	}
+	return rendered.URL, nil
+}
+
+// sendAnswerResult tells the player how their answer was judged, with a preview of it from the render hook.
+// It's only sent when a render hook is configured, and is sent in the background so a slow hook doesn't hold
+// up the game; if the hook fails, the result is sent without a preview
+func (c *Client) sendAnswerResult(answer string, correct bool) {
+	if config.RenderHookURL == "" {
+		return
+	}
+	hookURL, timeout := config.RenderHookURL, config.RenderHookTimeout
+	go func() {
+		result := AnswerResultEvent{Answer: answer, Correct: correct}
+		preview, err := callRenderHook(hookURL, timeout, answer)
+		if err != nil {
+			log.Printf("Failed to render a preview of %s's answer: %v", c.name, err)
+		}
+		result.PreviewURL = preview
+
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		c.trySend(Event{EventAnswerResult, data})
+	}()
+}
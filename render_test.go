@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useRenderHook renders answer previews with the handler for the rest of the test
+func useRenderHook(t *testing.T, hook http.HandlerFunc) {
+	server := httptest.NewServer(hook)
+	t.Cleanup(server.Close)
+	previous := config
+	config.RenderHookURL = server.URL
+	config.RenderHookTimeout = time.Second
+	t.Cleanup(func() { config = previous })
+}
+
+// nextAnswerResult waits for the next answer result the client is sent, skipping any other events
+func nextAnswerResult(t *testing.T, c *Client) AnswerResultEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-c.egress:
+			if event.Type != EventAnswerResult {
+				continue
+			}
+			var result AnswerResultEvent
+			if err := json.Unmarshal(event.Payload, &result); err != nil {
+				t.Fatal(err)
+			}
+			return result
+		case <-timeout:
+			t.Fatal("expected an answer result")
+			return AnswerResultEvent{}
+		}
+	}
+}
+
+func TestAnswerResult_Preview(t *testing.T) {
+	useRenderHook(t, func(w http.ResponseWriter, r *http.Request) {
+		var req renderHookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(renderHookResponse{"https://render.example/" + req.Latex + ".svg"})
+	})
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}, {Title: "Two", Latex: "b", Answer: "b"}})
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+
+	giveAnswer(t, alice, "x")
+	expected := AnswerResultEvent{Answer: "x", Correct: false, PreviewURL: "https://render.example/x.svg"}
+	if result := nextAnswerResult(t, alice); result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+	if err := giveAnswer(t, alice, "a"); err != nil {
+		t.Fatal(err)
+	}
+	expected = AnswerResultEvent{Answer: "a", Correct: true, PreviewURL: "https://render.example/a.svg"}
+	if result := nextAnswerResult(t, alice); result != expected {
+		t.Errorf("expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestAnswerResult_FailingHook(t *testing.T) {
+	for name, hook := range map[string]http.HandlerFunc{
+		"error":  func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+		"no url": func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{}`)) },
+		"slow": func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte(`{"url": "https://render.example/late.svg"}`))
+		},
+	} {
+		useRenderHook(t, hook)
+		config.RenderHookTimeout = 100 * time.Millisecond
+		lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+		lobby.startGame(time.Now())
+		alice := addTestClient(lobby, "alice")
+
+		giveAnswer(t, alice, "x")
+		// The result is still sent, just without a preview
+		expected := AnswerResultEvent{Answer: "x", Correct: false}
+		if result := nextAnswerResult(t, alice); result != expected {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, result)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// RequestError explains why a request's body was turned away, naming the offending field where there is one
+type RequestError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"error"`
+}
+
+// errBodyTooLarge is how http.MaxBytesReader words its error for a body over config.MaxRequestBodyBytes
+const errBodyTooLarge = "http: request body too large"
+
+// errRequestTooLarge is the error for a body over config.MaxRequestBodyBytes, answered with a 413
+var errRequestTooLarge = &RequestError{Message: "request body is too large"}
+
+// limitRequestBodies turns away requests with bodies over config.MaxRequestBodyBytes, so a huge body can't
+// exhaust the server's memory. Bodies whose size isn't known upfront are cut off at the limit
+func limitRequestBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > config.MaxRequestBodyBytes {
+			writeRequestError(w, errRequestTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, config.MaxRequestBodyBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// decodeRequest decodes the request's JSON body into v, rejecting fields v doesn't have. Failures are
+// returned as a *RequestError
+func decodeRequest(r *http.Request, v any) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return explainDecodeError(err)
+	}
+	return nil
+}
+
+// explainDecodeError turns a JSON decoding error into one that makes sense to whoever sent the request
+func explainDecodeError(err error) *RequestError {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err.Error() == errBodyTooLarge:
+		return errRequestTooLarge
+	case errors.Is(err, io.EOF):
+		return &RequestError{Message: "request body is empty"}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &RequestError{Message: "request body isn't complete JSON"}
+	case errors.As(err, &syntaxErr):
+		return &RequestError{Message: fmt.Sprintf("request body isn't valid JSON (at byte %d)", syntaxErr.Offset)}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return &RequestError{Message: fmt.Sprintf("request body must be a JSON %s", jsonTypeName(typeErr.Type))}
+		}
+		return &RequestError{
+			Field:   typeErr.Field,
+			Message: fmt.Sprintf("%s must be %s, not %s", typeErr.Field, withArticle(jsonTypeName(typeErr.Type)), withArticle(typeErr.Value)),
+		}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// The decoder doesn't have an error type for unknown fields
+		field, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		if unquoteErr != nil {
+			break
+		}
+		return &RequestError{Field: field, Message: fmt.Sprintf("unknown field %s", field)}
+	}
+	return &RequestError{Message: err.Error()}
+}
+
+// jsonTypeName is what a value of the given type is called in JSON
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.String:
+		return "string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// withArticle puts "a" or "an" before the name of a JSON type
+func withArticle(name string) string {
+	if name == "bool" {
+		// The decoder's name for what the request sent
+		name = "boolean"
+	}
+	if strings.ContainsAny(name[:1], "aeiou") {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+// writeRequestError responds with a 400 (or 413, for bodies that are too large) explaining the error
+func writeRequestError(w http.ResponseWriter, err error) {
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		reqErr = &RequestError{Message: err.Error()}
+	}
+	status := http.StatusBadRequest
+	if reqErr == errRequestTooLarge {
+		status = http.StatusRequestEntityTooLarge
+	}
+	data, marshalErr := json.Marshal(reqErr)
+	if marshalErr != nil {
+		log.Println(marshalErr)
+		http.Error(w, reqErr.Message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequest_Errors(t *testing.T) {
+	manager := NewManager(context.Background())
+	tests := []struct {
+		handler  http.HandlerFunc
+		body     string
+		expected RequestError
+	}{
+		{manager.createLobbyHandler, `{"lobbyName": "a", "colour": "red"}`, RequestError{"colour", "unknown field colour"}},
+		{manager.createLobbyHandler, `{"maxPlayers": "four"}`, RequestError{"maxPlayers", "maxPlayers must be a number, not a string"}},
+		{manager.createLobbyHandler, `{"allowGuests": {}}`, RequestError{"allowGuests", "allowGuests must be a boolean, not an object"}},
+		{manager.loginHandler, `{"username": "alice", "pasword": "secret"}`, RequestError{"pasword", "unknown field pasword"}},
+		{manager.loginHandler, `{"username": ["alice"]}`, RequestError{"username", "username must be a string, not an array"}},
+		{manager.loginHandler, `{"spectator": "yes"}`, RequestError{"spectator", "spectator must be a boolean, not a string"}},
+		{manager.loginHandler, `["alice"]`, RequestError{"", "request body must be a JSON object"}},
+		{manager.lobbyStatus, `{"lobbyId": 42}`, RequestError{"lobbyId", "lobbyId must be a string, not a number"}},
+		{manager.lobbyStatus, `{"lobbyId": "a",}`, RequestError{"", "request body isn't valid JSON (at byte 17)"}},
+		{manager.lobbyStatus, `{"lobbyId": "a"`, RequestError{"", "request body isn't complete JSON"}},
+		{manager.lobbyStatus, ``, RequestError{"", "request body is empty"}},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		test.handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %d", test.body, rec.Code)
+			continue
+		}
+		var got RequestError
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("expected a JSON error for %s, got %s", test.body, rec.Body.String())
+		} else if got != test.expected {
+			t.Errorf("expected %s to be rejected with %+v, got %+v", test.body, test.expected, got)
+		}
+	}
+}
+
+func TestLimitRequestBodies(t *testing.T) {
+	previous := config
+	config.MaxRequestBodyBytes = 64
+	t.Cleanup(func() { config = previous })
+	manager := NewManager(context.Background())
+	handler := limitRequestBodies(http.HandlerFunc(manager.createLobbyHandler))
+
+	oversized := `{"lobbyName": "` + strings.Repeat("a", 100) + `"}`
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(oversized)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected an oversized body to be rejected with a 413, got %d", rec.Code)
+	}
+
+	// A body of unknown length is only caught once it's read past the limit
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(oversized))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var got RequestError
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected an oversized body of unknown length to be rejected with a 413, got %d", rec.Code)
+	} else if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got != *errRequestTooLarge {
+		t.Errorf("expected the body to be rejected for being too large, got %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"lobbyName": "a"}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected a small body to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Every endpoint that reads a body reports it being cut off the same way
+	endpoints := map[string]http.HandlerFunc{
+		"validate": manager.validateCustomProblemsHandler,
+		"judge":    judgeHandler,
+	}
+	for name, endpoint := range endpoints {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(oversized))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		limitRequestBodies(endpoint).ServeHTTP(rec, req)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected %s to reject an oversized body of unknown length with a 413, got %d", name, rec.Code)
+		}
+	}
+}
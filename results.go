@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlayerResult is a player's line in a finished game's results
+type PlayerResult struct {
+	Rank  int    `json:"rank"`
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	// QuestionsAnswered is how many problems the player answered correctly
+	QuestionsAnswered int `json:"questionsAnswered"`
+	// TimeTaken is how long (in seconds) the player played for: until they finished every problem, or the game ended
+	TimeTaken int `json:"timeTaken"`
+	// Accuracy is the fraction of the player's answers that were correct
+	Accuracy float64 `json:"accuracy"`
+}
+
+// ProblemResult is how players fared on a problem in a finished game
+type ProblemResult struct {
+	Title string `json:"title"`
+	// Attempted is how many players were done with the problem, whether or not they solved it
+	Attempted int `json:"attempted"`
+	Solved    int `json:"solved"`
+	// SolveTime is the total time (in seconds) the players who solved it took, from when they could answer it
+	SolveTime float64 `json:"solveTime"`
+}
+
+// GameResult is what's saved once a game is finished
+type GameResult struct {
+	Name string `json:"name"`
+	// Players are ranked by score, then the game's tie-break rule; players still tied share a rank
+	Players []PlayerResult `json:"players"`
+	// Problems are the problems players got through, in the order of the lobby's problems
+	Problems       []ProblemResult `json:"problems"`
+	StartTimestamp time.Time       `json:"startTimestamp"`
+	GameDuration   int             `json:"gameDuration"`
+	// Seed is the seed the game's random choices were made with, so its problem order can be verified
+	Seed int64 `json:"seed"`
+}
+
+// recordProblemResult tallies the named user being done with a problem, either by solving it or moving on from it,
+// and records how long they spent on it.
+// @dev Requires the lobby's (write) lock to be held
+func (l *Lobby) recordProblemResult(name string, index int, solved bool) {
+	user := l.userMapping[name]
+	var spent float64
+	if !user.answerableAt.IsZero() {
+		spent = time.Since(user.answerableAt).Seconds()
+	}
+
+	result := l.problemResults[index]
+	result.Title = l.getLobbyProblems()[index].Title
+	result.Attempted++
+	if solved {
+		result.Solved++
+		result.SolveTime += spent
+	}
+	l.problemResults[index] = result
+
+	user.timings = append(user.timings, ProblemTime{user.questionNumber, result.Title, spent, solved})
+	l.userMapping[name] = user
+}
+
+// ranks returns each of the standings' 1-based rank. Players with equal scores are ranked by the game's
+// tie-break rule, and players still tied share a rank
+func (l *Lobby) ranks(standings []Standing) []int {
+	ranks := make([]int, len(standings))
+	for i, standing := range standings {
+		ranks[i] = i + 1
+		if i > 0 && standings[i-1].Score == standing.Score &&
+			l.settings.tieBreak(l.userMapping[standings[i-1].Name], l.userMapping[standing.Name]) == 0 {
+			ranks[i] = ranks[i-1]
+		}
+	}
+	return ranks
+}
+
+// gameResult summarises the lobby's game, which ended at endedAt
+func (l *Lobby) gameResult(endedAt time.Time) GameResult {
+	result := GameResult{l.name, make([]PlayerResult, 0, len(l.userMapping)), l.sortedProblemResults(), *l.startTime, l.timeLimit, l.settings.Seed}
+	standings := l.standings()
+	ranks := l.ranks(standings)
+	for i, standing := range standings {
+		user := l.userMapping[standing.Name]
+		finishedAt := endedAt
+		if user.finished {
+			finishedAt = user.finishedAt
+		}
+		timeTaken := int(finishedAt.Sub(*l.startTime).Seconds())
+		result.Players = append(result.Players, PlayerResult{ranks[i], standing.Name, standing.Score, user.answered, timeTaken, standing.Accuracy})
+	}
+	return result
+}
+
+// sortedProblemResults returns the lobby's problem results, in the order of its problems
+func (l *Lobby) sortedProblemResults() []ProblemResult {
+	indices := make([]int, 0, len(l.problemResults))
+	for i := range l.problemResults {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	results := make([]ProblemResult, len(indices))
+	for j, i := range indices {
+		results[j] = l.problemResults[i]
+	}
+	return results
+}
+
+// writeResultsCSV writes the players' results as CSV, with a header row
+func writeResultsCSV(w *csv.Writer, result GameResult) error {
+	w.Write([]string{"rank", "username", "score", "questionsAnswered", "timeTaken", "accuracy"})
+	for _, player := range result.Players {
+		w.Write([]string{
+			strconv.Itoa(player.Rank),
+			player.Name,
+			strconv.Itoa(player.Score),
+			strconv.Itoa(player.QuestionsAnswered),
+			strconv.Itoa(player.TimeTaken),
+			strconv.FormatFloat(player.Accuracy, 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// resultsHandler returns a finished game's results, as JSON or (with ?format=csv) as CSV for spreadsheets
+func resultsHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("l")
+	if id == "" || filepath.Base(id) != id {
+		http.Error(w, "invalid lobby id", http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(logsDirectory, id+".result.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if format != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+
+	var result GameResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+id+".csv\"")
+	w.WriteHeader(http.StatusOK)
+	if err := writeResultsCSV(csv.NewWriter(w), result); err != nil {
+		log.Println(err)
+	}
+}
+
+// RESULTS_CLEANUP_INTERVAL is how often results past the retention period are looked for
+const RESULTS_CLEANUP_INTERVAL = time.Hour
+
+// removeStaleResults deletes the results (and problems and sessions) of games saved before the cutoff, returning how many
+// games were removed
+func removeStaleResults(cutoff time.Time) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(logsDirectory, "*.result.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Println(err)
+			continue
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ".result.json")
+		for _, saved := range []string{filepath.Join(logsDirectory, id+".problems.json"), filepath.Join(sessionsDirectory, id+".session.json")} {
+			if err := os.Remove(saved); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Println(err)
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// cleanupResults removes results past the retention period, invalidating the cached history if any were
+func (m *Manager) cleanupResults(retention time.Duration) (int, error) {
+	removed, err := removeStaleResults(time.Now().Add(-retention))
+	if removed > 0 {
+		log.Printf("Removed %d results older than %v", removed, retention)
+		m.history.Lock()
+		m.history.scannedAt = time.Time{}
+		m.history.Unlock()
+	}
+	return removed, err
+}
+
+// startResultsCleanup periodically removes results past the retention period, until the manager is shut down
+func (m *Manager) startResultsCleanup(retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(RESULTS_CLEANUP_INTERVAL)
+		defer ticker.Stop()
+		for {
+			if _, err := m.cleanupResults(retention); err != nil {
+				log.Printf("Failed to clean up results: %v", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func getResults(t *testing.T, id string, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/results?l="+id+"&format="+format, nil)
+	rec := httptest.NewRecorder()
+	resultsHandler(rec, req)
+	return rec
+}
+
+func TestResultsHandler_CSV(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "abc", Answer: "abc"},
+		{Title: "Two", Latex: "def", Answer: "def"},
+	})
+	startTime := time.Now().Add(-time.Minute)
+	lobby.startGame(startTime)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	addTestClient(lobby, "carol")
+	giveAnswer(t, alice, "abc")
+	giveAnswer(t, alice, "def")
+	giveAnswer(t, bob, "abc")
+
+	testManagers[lobby].finishGame(lobby, "Game over!")
+
+	rec := getResults(t, lobby.id, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var result GameResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Problems) != 2 || result.Problems[0].Title != "One" || result.Problems[0].Solved != 2 ||
+		result.Problems[1].Title != "Two" || result.Problems[1].Attempted != 1 {
+		t.Errorf("expected both problems' outcomes to be saved, got %+v", result.Problems)
+	}
+
+	rec = getResults(t, lobby.id, "csv")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected CSV, got %d (%s)", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := []string{"rank", "username", "score", "questionsAnswered", "timeTaken", "accuracy"}
+	if len(rows) != len(result.Players)+1 {
+		t.Fatalf("expected a header and %d rows, got %v", len(result.Players), rows)
+	}
+	for i, column := range header {
+		if rows[0][i] != column {
+			t.Errorf("expected column %d to be %s, got %s", i, column, rows[0][i])
+		}
+	}
+	for i, player := range result.Players {
+		expected := []string{
+			strconv.Itoa(player.Rank), player.Name, strconv.Itoa(player.Score),
+			strconv.Itoa(player.QuestionsAnswered), strconv.Itoa(player.TimeTaken),
+			strconv.FormatFloat(player.Accuracy, 'f', 2, 64),
+		}
+		for j := range expected {
+			if rows[i+1][j] != expected[j] {
+				t.Errorf("row %d: expected %v, got %v", i+1, expected, rows[i+1])
+				break
+			}
+		}
+	}
+
+	if first := result.Players[0]; first.Name != "alice" || first.Rank != 1 || first.QuestionsAnswered != 2 {
+		t.Errorf("expected alice to be first having answered 2 questions, got %+v", first)
+	}
+	if last := result.Players[2]; last.Name != "carol" || last.Rank != 3 || last.TimeTaken < 60 {
+		t.Errorf("expected carol to be last, having played the whole game, got %+v", last)
+	}
+}
+
+func TestResultsHandler_BadRequests(t *testing.T) {
+	useTempLogsDirectory(t)
+	tests := []struct {
+		id     string
+		format string
+		status int
+	}{
+		{"missing", "", http.StatusNotFound},
+		{"../secrets", "", http.StatusBadRequest},
+		{"", "", http.StatusBadRequest},
+		{"missing", "xml", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if rec := getResults(t, test.id, test.format); rec.Code != test.status {
+			t.Errorf("%q (%q): expected %d, got %d", test.id, test.format, test.status, rec.Code)
+		}
+	}
+}
+
+// playSeededGame plays through a game with the given seed (0 for a random one), returning the seed recorded
+// in its results and the problems each player was served
+func playSeededGame(t *testing.T, seed int64) (int64, map[string][]int) {
+	t.Helper()
+	problems := make([]Problem, 8)
+	for i := range problems {
+		answer := string(rune('a' + i))
+		problems[i] = Problem{Title: answer, Description: "d", Latex: answer, Answer: answer}
+	}
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	lobby.owner = &owner.name
+	players := []*Client{owner, addTestClient(lobby, "alice"), addTestClient(lobby, "bob")}
+
+	err := requestStartGame(t, owner, RequestStartGameEvent{
+		OrderIsRandom:     true,
+		UseCustomProblems: true,
+		CustomProblems:    Problems{Problems: problems},
+		GameSettings:      GameSettings{NumProblems: 6, WeightedSelection: true, Seed: seed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Everyone answers in turn
+	for round := 0; round < 3; round++ {
+		for _, c := range players {
+			giveAnswer(t, c, problems[c.problemIndex()].Answer)
+		}
+	}
+
+	orders := make(map[string][]int)
+	for _, c := range players {
+		orders[c.name] = lobby.userMapping[c.name].order
+	}
+	return lobby.gameResult(time.Now()).Seed, orders
+}
+
+func TestGameResult_SeedReproducesOrders(t *testing.T) {
+	seed, orders := playSeededGame(t, 0)
+	if seed == 0 {
+		t.Fatal("expected a seed to be picked and recorded")
+	}
+	if len(orders["alice"]) != 4 {
+		t.Fatalf("expected alice to have been served 4 problems, got %v", orders["alice"])
+	}
+	replayedSeed, replayed := playSeededGame(t, seed)
+	if replayedSeed != seed {
+		t.Errorf("expected the replay to record seed %d, got %d", seed, replayedSeed)
+	}
+	if !reflect.DeepEqual(orders, replayed) {
+		t.Errorf("expected the seed to reproduce the orders %v, got %v", orders, replayed)
+	}
+}
+
+func TestGameResult_TieBreak(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	startTime := time.Now().Add(-time.Hour)
+	lobby.startGame(startTime)
+	lobby.userMapping["alice"] = User{score: 5, finished: true, finishedAt: startTime.Add(3 * time.Minute), answered: 3, totalAnswers: 6}
+	lobby.userMapping["bob"] = User{score: 5, finished: true, finishedAt: startTime.Add(2 * time.Minute), answered: 3, totalAnswers: 4}
+	lobby.userMapping["carol"] = User{score: 5, answered: 3, totalAnswers: 3}
+	lobby.userMapping["dave"] = User{score: 7, answered: 4, totalAnswers: 9}
+
+	for _, test := range []struct {
+		tieBreak string
+		names    []string
+		ranks    []int
+	}{
+		{"", []string{"dave", "alice", "bob", "carol"}, []int{1, 2, 2, 2}},
+		{TieBreakFinishTime, []string{"dave", "bob", "alice", "carol"}, []int{1, 2, 3, 4}},
+		{TieBreakAttempts, []string{"dave", "carol", "bob", "alice"}, []int{1, 2, 3, 4}},
+		{TieBreakAccuracy, []string{"dave", "carol", "bob", "alice"}, []int{1, 2, 3, 4}},
+	} {
+		lobby.settings.TieBreak = test.tieBreak
+
+		// The live scoreboard and the results are ordered the same way
+		var scoreboard []string
+		for _, standing := range lobby.standings() {
+			scoreboard = append(scoreboard, standing.Name)
+		}
+		if !reflect.DeepEqual(scoreboard, test.names) {
+			t.Errorf("expected the scoreboard %v with tie-break %q, got %v", test.names, test.tieBreak, scoreboard)
+		}
+		var names []string
+		var ranks []int
+		for _, player := range lobby.gameResult(time.Now()).Players {
+			names = append(names, player.Name)
+			ranks = append(ranks, player.Rank)
+		}
+		if !reflect.DeepEqual(names, test.names) || !reflect.DeepEqual(ranks, test.ranks) {
+			t.Errorf("expected the results %v ranked %v with tie-break %q, got %v ranked %v", test.names, test.ranks, test.tieBreak, names, ranks)
+		}
+	}
+
+	settings := GameSettings{TieBreak: "coin_toss"}
+	if err := settings.validate(); err == nil {
+		t.Error("expected an unknown tie-break to be rejected")
+	}
+}
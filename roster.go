@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Kinds of change to the roster, sent in roster diffs
+const (
+	// RosterJoin is sent when a user makes their first connection to the lobby
+	RosterJoin = "join"
+	// RosterLeave is sent when a user's last connection to the lobby closes
+	RosterLeave = "leave"
+	// RosterRename is sent when a player changes their name
+	RosterRename = "rename"
+	// RosterUpdate is sent when a user's score, readiness or spectating changes
+	RosterUpdate = "update"
+)
+
+// RosterEvent is the full roster, as of the diff numbered Seq
+type RosterEvent struct {
+	Seq     uint64       `json:"seq"`
+	Players []PlayerInfo `json:"players"`
+}
+
+// RosterDiffEvent is a single change to the roster. Diffs are numbered one after another, so clients who see
+// a gap in the numbers know they've missed one and can ask for the full roster again
+type RosterDiffEvent struct {
+	Seq    uint64 `json:"seq"`
+	Change string `json:"change"`
+	Name   string `json:"name"`
+	// OldName is the player's name before they were renamed
+	OldName string `json:"oldName,omitempty"`
+	// Player is how the user is now shown in the roster (omitted when they leave)
+	Player *PlayerInfo `json:"player,omitempty"`
+}
+
+// sendRoster sends the client the full roster, numbered with the latest diff it includes
+func (l *Lobby) sendRoster(client *Client) error {
+	l.rosterLock.Lock()
+	defer l.rosterLock.Unlock()
+
+	l.RLock()
+	viewer := client.name
+	l.RUnlock()
+	data, err := json.Marshal(RosterEvent{l.rosterSeq, l.roster(viewer)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal roster: %v", err)
+	}
+	client.trySend(Event{EventRoster, data})
+	return nil
+}
+
+// publishRosterChange tells everyone about a change to the named user's place in the roster. Changes to users
+// who aren't connected (and so aren't in the roster) aren't sent.
+// @dev Mustn't be called with the lobby's lock held
+func (l *Lobby) publishRosterChange(change string, name string, oldName string) {
+	l.rosterLock.Lock()
+	defer l.rosterLock.Unlock()
+
+	diff := RosterDiffEvent{Change: change, Name: name, OldName: oldName}
+	if change != RosterLeave {
+		l.RLock()
+		if !l.hasClient(name) {
+			l.RUnlock()
+			return
+		}
+		// Everyone's sent the same diff, so it only has the score if everyone can see it
+		player := l.playerInfo(name, "", l.gameState == InPlay && !l.settings.HideScoreboard)
+		l.RUnlock()
+		diff.Player = &player
+	}
+	l.rosterSeq++
+	diff.Seq = l.rosterSeq
+
+	data, err := json.Marshal(diff)
+	if err != nil {
+		log.Printf("failed to marshal roster diff: %v", err)
+		return
+	}
+	l.broadcast(Event{EventRosterDiff, data})
+}
+
+// RequestRosterHandler sends the client the full roster again, so they can resync after missing a diff
+func RequestRosterHandler(event Event, c *Client) error {
+	return c.lobby.sendRoster(c)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// nextRosterDiff waits for the next roster diff the client is sent, skipping any other events
+func nextRosterDiff(t *testing.T, c *Client) RosterDiffEvent {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-c.egress:
+			if event.Type != EventRosterDiff {
+				continue
+			}
+			var diff RosterDiffEvent
+			if err := json.Unmarshal(event.Payload, &diff); err != nil {
+				t.Fatal(err)
+			}
+			return diff
+		case <-timeout:
+			t.Fatal("expected a roster diff")
+			return RosterDiffEvent{}
+		}
+	}
+}
+
+func TestRoster_Diffs(t *testing.T) {
+	// Players are only treated as leaving once their grace runs out
+	previous := config
+	config.DisconnectGrace = 0
+	t.Cleanup(func() { config = previous })
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	alice := addTestClient(lobby, "alice")
+	server := newTestServer(t, testManagers[lobby])
+
+	conn := connectTestClient(t, server, lobby, "bob")
+	diff := nextRosterDiff(t, alice)
+	expected := RosterDiffEvent{Seq: 1, Change: RosterJoin, Name: "bob", Player: &PlayerInfo{Name: "bob"}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected bob's join to be sent as %+v, got %+v", expected, diff)
+	}
+
+	readyEvent, _ := json.Marshal(SetReadyEvent{true})
+	if err := SetReadyHandler(Event{EventSetReady, readyEvent}, alice); err != nil {
+		t.Fatal(err)
+	}
+	diff = nextRosterDiff(t, alice)
+	expected = RosterDiffEvent{Seq: 2, Change: RosterUpdate, Name: "alice", Player: &PlayerInfo{Name: "alice", Ready: true}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected alice getting ready to be sent as %+v, got %+v", expected, diff)
+	}
+
+	if err := changeName(t, alice, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	diff = nextRosterDiff(t, alice)
+	expected = RosterDiffEvent{Seq: 3, Change: RosterRename, Name: "carol", OldName: "alice", Player: &PlayerInfo{Name: "carol", Ready: true}}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected alice's rename to be sent as %+v, got %+v", expected, diff)
+	}
+
+	conn.Close()
+	waitUntilDisconnected(t, lobby, "bob")
+	diff = nextRosterDiff(t, alice)
+	expected = RosterDiffEvent{Seq: 4, Change: RosterLeave, Name: "bob"}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("expected bob leaving to be sent as %+v, got %+v", expected, diff)
+	}
+}
+
+func TestRoster_ScoreChanges(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}, {Title: "Two", Latex: "b", Answer: "b"}})
+	alice := addTestClient(lobby, "alice")
+	lobby.startGame(time.Now())
+
+	if err := giveAnswer(t, alice, "a"); err != nil {
+		t.Fatal(err)
+	}
+	diff := nextRosterDiff(t, alice)
+	if diff.Change != RosterUpdate || diff.Player == nil || diff.Player.Score == nil || *diff.Player.Score != 1 {
+		t.Errorf("expected alice's new score to be sent, got %+v", diff)
+	}
+}
+
+func TestRoster_Resync(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	alice := addTestClient(lobby, "alice")
+	addTestClient(lobby, "bob")
+	lobby.publishRosterChange(RosterJoin, "bob", "")
+	drainEvents(alice)
+
+	if err := RequestRosterHandler(Event{EventRequestRoster, nil}, alice); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(alice)
+	if len(events) != 1 || events[0].Type != EventRoster {
+		t.Fatalf("expected the roster to be sent, got %v", events)
+	}
+	var roster RosterEvent
+	if err := json.Unmarshal(events[0].Payload, &roster); err != nil {
+		t.Fatal(err)
+	}
+	expected := RosterEvent{Seq: 1, Players: []PlayerInfo{{Name: "alice"}, {Name: "bob"}}}
+	if !reflect.DeepEqual(roster, expected) {
+		t.Errorf("expected the roster %+v, got %+v", expected, roster)
+	}
+}
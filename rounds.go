@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// roundBaseline is where a player stood when the current round started, so their round can be scored on its own
+type roundBaseline struct {
+	Score        int `json:"score"`
+	Answered     int `json:"answered"`
+	TotalAnswers int `json:"totalAnswers"`
+}
+
+// multiRound reports whether the lobby's game is split into rounds
+func (l *Lobby) multiRound() bool {
+	return l.settings.Rounds > 1
+}
+
+// roundStart returns the question number the round (counting from 0) starts at. The game's problems are split
+// as evenly as possible between its rounds
+func (l *Lobby) roundStart(round int) int {
+	if !l.multiRound() {
+		return 0
+	}
+	return round * len(l.CustomOrder) / l.settings.Rounds
+}
+
+// waitingForRound reports whether the user has gone through the current round's problems, and is waiting for
+// the rest of the lobby to finish it.
+// @dev Requires the lobby's lock to be held
+func (l *Lobby) waitingForRound(user User) bool {
+	return l.multiRound() && !user.spectator && !user.finished && l.round < l.settings.Rounds-1 &&
+		user.questionNumber >= l.roundStart(l.round+1)
+}
+
+// roundState returns the client's user, and whether they're waiting for the rest of the lobby to finish the round
+func (c *Client) roundState() (User, bool) {
+	c.lobby.RLock()
+	defer c.lobby.RUnlock()
+
+	user := c.lobby.userMapping[c.name]
+	return user, c.lobby.waitingForRound(user)
+}
+
+// roundFinished reports whether every connected player has gone through the current round's problems
+func (l *Lobby) roundFinished() bool {
+	l.RLock()
+	defer l.RUnlock()
+
+	for client := range l.clients {
+		user := l.userMapping[client.name]
+		if !user.spectator && !user.finished && !l.waitingForRound(user) {
+			return false
+		}
+	}
+	return true
+}
+
+// beginRound records where every player stands as the current round starts.
+// @dev Requires the lobby's (write) lock to be held
+func (l *Lobby) beginRound(startedAt time.Time) {
+	l.roundStartedAt = startedAt
+	l.roundBaselines = make(map[string]roundBaseline, len(l.userMapping))
+	for name, user := range l.userMapping {
+		l.roundBaselines[name] = roundBaseline{user.score, user.answered, user.totalAnswers}
+	}
+}
+
+// startRoundTimer ends the current round once its time is up, if rounds are timed.
+// @dev Requires the lobby's (write) lock to be held
+func (m *Manager) startRoundTimer(lobby *Lobby) {
+	if lobby.roundTimer != nil {
+		lobby.roundTimer.Stop()
+		lobby.roundTimer = nil
+	}
+	if !lobby.multiRound() || lobby.settings.RoundSeconds <= 0 {
+		return
+	}
+	round := lobby.round
+	deadline := lobby.roundStartedAt.Add(time.Duration(lobby.settings.RoundSeconds) * time.Second)
+	lobby.roundTimer = time.AfterFunc(time.Until(deadline), func() {
+		m.completeRound(lobby, round)
+	})
+}
+
+// roundStandings returns what every player scored in the current round, highest first.
+// @dev Requires the lobby's lock to be held
+func (l *Lobby) roundStandings() []Standing {
+	standings := make([]Standing, 0, len(l.userMapping))
+	for name, user := range l.userMapping {
+		if user.spectator {
+			continue
+		}
+		// Players who joined part way through the round started it from nothing
+		base := l.roundBaselines[name]
+		standing := Standing{Name: name, Score: user.score - base.Score, Correct: user.answered - base.Answered}
+		if answers := user.totalAnswers - base.TotalAnswers; answers > 0 {
+			standing.Accuracy = float64(user.answered-base.Answered) / float64(answers)
+		}
+		standings = append(standings, standing)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].Name < standings[j].Name
+	})
+	return standings
+}
+
+// roundCompleteEvents builds each connected client's event for the end of the current round, showing them the
+// round's standings as they're allowed to see them.
+// @dev Requires the lobby's (write) lock to be held
+func (l *Lobby) roundCompleteEvents() (map[*Client]Event, error) {
+	standings := l.roundStandings()
+	events := make(map[*Client]Event, len(l.clients))
+	for client := range l.clients {
+		data, err := json.Marshal(RoundCompleteEvent{l.round + 1, l.settings.Rounds, l.visibleStandings(standings, client.name)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal round standings: %v", err)
+		}
+		events[client] = Event{EventRoundComplete, data}
+	}
+	return events, nil
+}
+
+// completeRound ends the given round (if it's still the current one), sending everyone its standings and then
+// starting the next round, which everyone starts from its first problem. Ending the last round ends the game
+func (m *Manager) completeRound(lobby *Lobby, round int) {
+	lobby.Lock()
+	if !lobby.inPlay() || lobby.round != round {
+		lobby.Unlock()
+		return
+	}
+	if round == lobby.settings.Rounds-1 {
+		lobby.Unlock()
+		m.finishGame(lobby, "Game over!")
+		return
+	}
+
+	events, err := lobby.roundCompleteEvents()
+	if err != nil {
+		lobby.Unlock()
+		log.Println(err)
+		return
+	}
+	lobby.round++
+	start := lobby.roundStart(lobby.round)
+	for name, user := range lobby.userMapping {
+		if user.spectator || user.finished {
+			continue
+		}
+		// Anyone who didn't get through the round in time leaves its remaining problems behind
+		if user.questionNumber < start {
+			user.questionNumber = start
+			user.attempts = 0
+			user.hintsUsed = 0
+			user.answerableAt = time.Time{}
+		}
+		user.undo = nil
+		lobby.userMapping[name] = user
+	}
+	lobby.beginRound(time.Now())
+	m.startRoundTimer(lobby)
+	lobby.Unlock()
+
+	for client, event := range events {
+		client.trySend(event)
+	}
+	m.saveSnapshot(lobby)
+
+	// Everyone gets the next round's first problem, in a fixed order so the game's seed reproduces them
+	clients := lobby.snapshotClients()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].name < clients[j].name
+	})
+	for _, client := range clients {
+		lobby.RLock()
+		user := lobby.userMapping[client.name]
+		lobby.RUnlock()
+		if !user.spectator && !user.finished {
+			client.sendClientProblem()
+		}
+	}
+}
+
+// announceFinalRound sends everyone the standings of the round the game ended in, if it has rounds
+func (l *Lobby) announceFinalRound() {
+	if !l.multiRound() {
+		return
+	}
+	l.Lock()
+	events, err := l.roundCompleteEvents()
+	l.Unlock()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for client, event := range events {
+		client.trySend(event)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// roundProblems are four one-point problems, answered with their own LaTeX
+var roundProblems = Problems{Problems: []Problem{
+	{Title: "One", Description: "1", Latex: "a", Answer: "a"},
+	{Title: "Two", Description: "2", Latex: "b", Answer: "b"},
+	{Title: "Three", Description: "3", Latex: "c", Answer: "c"},
+	{Title: "Four", Description: "4", Latex: "d", Answer: "d"},
+}}
+
+// roundComplete returns the round_complete event among the events, if there's one
+func roundComplete(t *testing.T, events []Event) (RoundCompleteEvent, bool) {
+	t.Helper()
+	for _, event := range events {
+		if event.Type == EventRoundComplete {
+			var round RoundCompleteEvent
+			if err := json.Unmarshal(event.Payload, &round); err != nil {
+				t.Fatal(err)
+			}
+			return round, true
+		}
+	}
+	return RoundCompleteEvent{}, false
+}
+
+// scores returns each player's score in the standings
+func scores(standings []Standing) map[string]int {
+	byName := make(map[string]int, len(standings))
+	for _, standing := range standings {
+		byName[standing.Name] = standing.Score
+	}
+	return byName
+}
+
+func TestRounds_TwoRoundGame(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	err := requestStartGame(t, alice, RequestStartGameEvent{
+		UseCustomProblems: true,
+		CustomProblems:    roundProblems,
+		GameSettings:      GameSettings{Rounds: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainEvents(alice)
+	drainEvents(bob)
+
+	// alice gets through the first round, and has to wait for bob
+	giveAnswer(t, alice, "a")
+	giveAnswer(t, alice, "b")
+	if err := RequestProblemHandler(Event{EventRequestProblem, nil}, alice); err == nil {
+		t.Error("expected alice not to get a problem while waiting for the round to finish")
+	}
+	if _, ok := roundComplete(t, drainEvents(alice)); ok {
+		t.Fatal("expected the round not to be over until bob's finished it")
+	}
+
+	giveAnswer(t, bob, "a")
+	drainEvents(bob)
+	SkipProblemHandler(Event{EventSkipProblem, nil}, bob)
+	for _, c := range []*Client{alice, bob} {
+		events := drainEvents(c)
+		round, ok := roundComplete(t, events)
+		if !ok {
+			t.Fatalf("expected %s to be told the first round is over, got %v", c.name, events)
+		}
+		if round.Round != 1 || round.Rounds != 2 {
+			t.Errorf("expected round 1 of 2 to be over, got %+v", round)
+		}
+		if got := scores(round.Standings); got["alice"] != 2 || got["bob"] != 1 {
+			t.Errorf("expected the first round's standings to be alice 2, bob 1, got %v", got)
+		}
+		if countEvents(events, EventNewProblem) != 1 {
+			t.Errorf("expected %s to be sent the second round's first problem, got %v", c.name, events)
+		}
+	}
+	if user := lobby.userMapping["alice"]; user.questionNumber != 2 {
+		t.Errorf("expected alice to be on the second round's first problem, got question %d", user.questionNumber)
+	}
+
+	// The second round only counts what's scored in it
+	giveAnswer(t, alice, "c")
+	giveAnswer(t, bob, "c")
+	giveAnswer(t, bob, "d")
+	giveAnswer(t, alice, "d")
+	events := drainEvents(alice)
+	round, ok := roundComplete(t, events)
+	if !ok || round.Round != 2 {
+		t.Fatalf("expected the second round's standings as the game ended, got %v", events)
+	}
+	if got := scores(round.Standings); got["alice"] != 2 || got["bob"] != 2 {
+		t.Errorf("expected the second round's standings to be alice 2, bob 2, got %v", got)
+	}
+
+	// The final standings add up every round
+	var end EndGameEvent
+	for _, event := range events {
+		if event.Type == EventEndGame {
+			if err := json.Unmarshal(event.Payload, &end); err == nil && end.Standings != nil {
+				break
+			}
+		}
+	}
+	if got := scores(end.Standings); got["alice"] != 4 || got["bob"] != 3 {
+		t.Errorf("expected the final standings to be alice 4, bob 3, got %v", got)
+	}
+	if lobby.gameState != Finished {
+		t.Errorf("expected the game to be over, got %s", lobby.gameState)
+	}
+}
+
+func TestRounds_TimedRound(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	err := requestStartGame(t, alice, RequestStartGameEvent{
+		UseCustomProblems: true,
+		CustomProblems:    roundProblems,
+		GameSettings:      GameSettings{Rounds: 2, RoundSeconds: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testManagers[lobby].startGameTimers(lobby)
+	giveAnswer(t, alice, "a")
+	drainEvents(alice)
+
+	// alice runs out of time on the first round, leaving its second problem behind
+	time.Sleep(1200 * time.Millisecond)
+	events := drainEvents(alice)
+	if round, ok := roundComplete(t, events); !ok || round.Round != 1 {
+		t.Fatalf("expected the first round to end once its time was up, got %v", events)
+	}
+	lobby.RLock()
+	defer lobby.RUnlock()
+	if user := lobby.userMapping["alice"]; user.questionNumber != 2 {
+		t.Errorf("expected alice to be moved on to the second round, got question %d", user.questionNumber)
+	}
+}
+
+func TestRounds_Validation(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	for _, settings := range []GameSettings{{Rounds: 5}, {Rounds: 3, NumProblems: 2}, {Rounds: -1}, {Rounds: 2, RoundSeconds: -1}} {
+		err := requestStartGame(t, alice, RequestStartGameEvent{
+			UseCustomProblems: true,
+			CustomProblems:    roundProblems,
+			GameSettings:      settings,
+		})
+		if err == nil {
+			t.Errorf("expected %+v to be rejected", settings)
+		}
+	}
+	if lobby.inPlay() {
+		t.Error("the game shouldn't have started")
+	}
+}
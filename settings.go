@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// GameConfigEvent is the lobby's full configuration: how it was created, plus the rules the next (or current)
+// game is played by
+type GameConfigEvent struct {
+	Duration      int  `json:"durationTime"`
+	MinPlayers    int  `json:"minPlayers"`
+	MaxPlayers    int  `json:"maxPlayers"`
+	AllowGuests   bool `json:"allowGuests"`
+	AllowPractice bool `json:"allowPractice"`
+	ChatFilter    bool `json:"chatFilter"`
+	// PasswordRequired is whether the lobby has a password (which can't be changed from here)
+	PasswordRequired bool `json:"passwordRequired"`
+	GameSettings
+}
+
+// validate checks the settings are usable, filling in the defaults for any left out
+func (s *GameSettings) validate() error {
+	if s.MaxAttempts < 0 {
+		return fmt.Errorf("maxAttempts can't be negative")
+	} else if s.PreviewSeconds < 0 {
+		return fmt.Errorf("previewSeconds can't be negative")
+	} else if s.WrongAnswerPenalty < 0 {
+		return fmt.Errorf("wrongAnswerPenalty can't be negative")
+	} else if s.WarmupSeconds < 0 {
+		return fmt.Errorf("warmupSeconds can't be negative")
+	} else if s.NumProblems < 0 {
+		return fmt.Errorf("numProblems can't be negative")
+	} else if s.Rounds < 0 || s.RoundSeconds < 0 {
+		return fmt.Errorf("rounds and roundSeconds can't be negative")
+	} else if s.HintBudget < 0 {
+		return fmt.Errorf("hintBudget can't be negative")
+	}
+	if len(s.DifficultyPenalties) > 0 {
+		penalties := make(map[string]int, len(s.DifficultyPenalties))
+		for difficulty, penalty := range s.DifficultyPenalties {
+			if penalty < 0 {
+				return fmt.Errorf("the penalty for %q problems can't be negative", difficulty)
+			}
+			penalties[strings.ToLower(difficulty)] = penalty
+		}
+		s.DifficultyPenalties = penalties
+	}
+	switch s.AdvanceMode {
+	case "":
+		s.AdvanceMode = AdvanceAuto
+	case AdvanceAuto, AdvanceManual:
+	default:
+		return fmt.Errorf("unknown advanceMode %q", s.AdvanceMode)
+	}
+	switch s.LateJoin {
+	case "":
+		s.LateJoin = LateJoinFromStart
+	case LateJoinFromStart, LateJoinCatchUp, LateJoinClosed:
+	default:
+		return fmt.Errorf("unknown lateJoin %q", s.LateJoin)
+	}
+	switch s.TieBreak {
+	case "", TieBreakFinishTime, TieBreakAttempts, TieBreakAccuracy:
+	default:
+		return fmt.Errorf("unknown tieBreak %q", s.TieBreak)
+	}
+	return nil
+}
+
+// tieBreak compares two players with equal scores by the game's tie-break rule, returning a negative number if
+// a ranks ahead of b, a positive one if b does, and 0 if they're still tied
+func (s GameSettings) tieBreak(a, b User) int {
+	switch s.TieBreak {
+	case TieBreakFinishTime:
+		// Players who haven't finished rank behind those who have
+		if a.finished && b.finished {
+			if a.finishedAt.Before(b.finishedAt) {
+				return -1
+			} else if b.finishedAt.Before(a.finishedAt) {
+				return 1
+			}
+		} else if a.finished {
+			return -1
+		} else if b.finished {
+			return 1
+		}
+	case TieBreakAttempts:
+		return a.totalAnswers - b.totalAnswers
+	case TieBreakAccuracy:
+		if a.accuracy() > b.accuracy() {
+			return -1
+		} else if a.accuracy() < b.accuracy() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// penaltyFor returns how many points a wrong answer to the problem costs
+func (s GameSettings) penaltyFor(problem Problem) int {
+	if penalty, ok := s.DifficultyPenalties[strings.ToLower(problem.Difficulty)]; ok {
+		return penalty
+	}
+	return s.WrongAnswerPenalty
+}
+
+// gameConfig returns the lobby's current configuration
+// @dev Requires the lobby's lock to be held
+func (l *Lobby) gameConfig() GameConfigEvent {
+	return GameConfigEvent{
+		Duration:         l.timeLimit,
+		MinPlayers:       l.minPlayers,
+		MaxPlayers:       l.maxPlayers,
+		AllowGuests:      l.allowGuests,
+		AllowPractice:    l.allowPractice,
+		ChatFilter:       l.chatFilter,
+		PasswordRequired: l.passwordHash != "",
+		GameSettings:     l.settings,
+	}
+}
+
+// RequestGameConfigHandler sends the client the lobby's current configuration
+func RequestGameConfigHandler(event Event, c *Client) error {
+	c.lobby.RLock()
+	data, err := json.Marshal(c.lobby.gameConfig())
+	c.lobby.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal game config: %v", err)
+	}
+	c.egress <- Event{EventGameConfig, data}
+	return nil
+}
+
+// ChangeSettingsHandler lets the owner change the lobby's configuration before the game starts, sending everyone
+// the new configuration. Only the fields given are changed.
+func ChangeSettingsHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	if !c.ownsLobby() {
+		return c.sendError("only the owner can change the settings")
+	}
+
+	lobby.Lock()
+	if lobby.gameState != WaitingForPlayers {
+		lobby.Unlock()
+		return c.sendError("the settings can't be changed once the game has started")
+	}
+	changed := lobby.gameConfig()
+	// Decoding would write into the lobby's own tags and penalties, so they're only kept if they aren't given
+	changed.Tags, changed.DifficultyPenalties = nil, nil
+	if err := json.Unmarshal(event.Payload, &changed); err != nil {
+		lobby.Unlock()
+		return fmt.Errorf("bad payload in request: %v", err)
+	}
+	if changed.Tags == nil {
+		changed.Tags = lobby.settings.Tags
+	}
+	if changed.DifficultyPenalties == nil {
+		changed.DifficultyPenalties = lobby.settings.DifficultyPenalties
+	}
+	var err error
+	if changed.Duration <= 0 {
+		err = fmt.Errorf("durationTime must be positive")
+	} else if changed.MinPlayers < 0 || changed.MaxPlayers < 0 {
+		err = fmt.Errorf("player limits can't be negative")
+	} else if changed.MaxPlayers > 0 && changed.MinPlayers > changed.MaxPlayers {
+		err = fmt.Errorf("minPlayers can't be more than maxPlayers")
+	} else {
+		err = changed.GameSettings.validate()
+	}
+	if err != nil {
+		lobby.Unlock()
+		return c.sendError(err.Error())
+	}
+	lobby.timeLimit = changed.Duration
+	lobby.minPlayers = changed.MinPlayers
+	lobby.maxPlayers = changed.MaxPlayers
+	lobby.allowGuests = changed.AllowGuests
+	lobby.allowPractice = changed.AllowPractice
+	lobby.chatFilter = changed.ChatFilter
+	lobby.settings = changed.GameSettings
+	data, err := json.Marshal(lobby.gameConfig())
+	lobby.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal game config: %v", err)
+	}
+
+	lobby.broadcast(Event{EventGameConfig, data})
+	return nil
+}
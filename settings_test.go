@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// requestGameConfig asks for the lobby's configuration as the client, returning what they're sent
+func requestGameConfig(t *testing.T, c *Client) GameConfigEvent {
+	t.Helper()
+	if err := RequestGameConfigHandler(Event{EventRequestGameConfig, nil}, c); err != nil {
+		t.Fatal(err)
+	}
+	events := drainEvents(c)
+	var gameConfig GameConfigEvent
+	if len(events) != 1 || events[0].Type != EventGameConfig {
+		t.Fatalf("expected only the game config to be sent, got %v", events)
+	} else if err := json.Unmarshal(events[0].Payload, &gameConfig); err != nil {
+		t.Fatal(err)
+	}
+	return gameConfig
+}
+
+func TestRequestGameConfigHandler(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	alice := addTestClient(lobby, "alice")
+	lobby.timeLimit = 300
+	lobby.maxPlayers = 4
+	lobby.allowGuests = true
+	lobby.settings.HideScoreboard = true
+	lobby.settings.NumProblems = 5
+
+	expected := GameConfigEvent{
+		Duration:     300,
+		MaxPlayers:   4,
+		AllowGuests:  true,
+		ChatFilter:   lobby.chatFilter,
+		GameSettings: GameSettings{HideScoreboard: true, NumProblems: 5},
+	}
+	if got := requestGameConfig(t, alice); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the config %+v, got %+v", expected, got)
+	}
+}
+
+func TestChangeSettingsHandler(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+	bob := addTestClient(lobby, "bob")
+	lobby.timeLimit = 300
+
+	change := json.RawMessage(`{"maxPlayers": 6, "hideScoreboard": true, "numProblems": 3}`)
+	if err := ChangeSettingsHandler(Event{EventChangeSettings, change}, bob); err == nil {
+		t.Error("expected only the owner to be able to change the settings")
+	}
+	drainEvents(owner)
+	drainEvents(bob)
+
+	if err := ChangeSettingsHandler(Event{EventChangeSettings, change}, owner); err != nil {
+		t.Fatal(err)
+	}
+	// Everyone is sent the new config, with the settings that weren't given left as they were
+	expected := GameConfigEvent{
+		Duration:     300,
+		MaxPlayers:   6,
+		ChatFilter:   lobby.chatFilter,
+		GameSettings: GameSettings{HideScoreboard: true, NumProblems: 3, AdvanceMode: AdvanceAuto, LateJoin: LateJoinFromStart},
+	}
+	events := drainEvents(bob)
+	var broadcast GameConfigEvent
+	if len(events) != 1 || events[0].Type != EventGameConfig {
+		t.Fatalf("expected the new config to be broadcast, got %v", events)
+	} else if err := json.Unmarshal(events[0].Payload, &broadcast); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(broadcast, expected) {
+		t.Errorf("expected the config %+v to be broadcast, got %+v", expected, broadcast)
+	}
+	drainEvents(owner)
+	if got := requestGameConfig(t, bob); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the config %+v after the change, got %+v", expected, got)
+	}
+
+	// Bad changes are rejected without changing anything
+	for _, bad := range []string{`{"minPlayers": 8}`, `{"durationTime": 0}`, `{"maxAttempts": -1}`, `{"lateJoin": "never"}`} {
+		if err := ChangeSettingsHandler(Event{EventChangeSettings, json.RawMessage(bad)}, owner); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+	drainEvents(owner)
+	if got := requestGameConfig(t, owner); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected bad changes to leave the config as %+v, got %+v", expected, got)
+	}
+
+	lobby.startGame(time.Now())
+	if err := ChangeSettingsHandler(Event{EventChangeSettings, change}, owner); err == nil {
+		t.Error("expected the settings to be fixed once the game has started")
+	}
+}
+
+func TestStartGameHandler_KeepsChangedSettings(t *testing.T) {
+	lobby := newTestLobby(t, nil)
+	owner := addTestClient(lobby, "owner")
+
+	change := json.RawMessage(`{"durationTime": 120, "hideScoreboard": true, "maxAttempts": 2, "tags": ["calculus"]}`)
+	if err := ChangeSettingsHandler(Event{EventChangeSettings, change}, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// The start only gives the problems, so the game is played with the settings the owner chose
+	start := json.RawMessage(`{"useCustomProblems": true, "customProblems": {"problems": [
+		{"title": "Derivative", "description": "d", "latex": "f'(x)", "tags": ["calculus"]},
+		{"title": "Matrix", "description": "m", "latex": "A^T", "tags": ["matrices"]}
+	]}}`)
+	if err := StartGameHandler(Event{EventStartGameOwner, start}, owner); err != nil {
+		t.Fatal(err)
+	}
+	if lobby.timeLimit != 120 {
+		t.Errorf("expected the changed time limit of 120s, got %d", lobby.timeLimit)
+	}
+	if !lobby.settings.HideScoreboard || lobby.settings.MaxAttempts != 2 {
+		t.Errorf("expected the changed settings to be kept, got %+v", lobby.settings)
+	}
+	if len(lobby.CustomOrder) != 1 {
+		t.Errorf("expected the changed tags to leave 1 problem in play, got %v", lobby.CustomOrder)
+	}
+}
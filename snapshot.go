@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// userSnapshot is a user's persisted state (undo windows aren't kept; they'd have expired anyway)
+type userSnapshot struct {
+	Password       string    `json:"password"`
+	QuestionNumber int       `json:"questionNumber"`
+	Score          int       `json:"score"`
+	Attempts       int       `json:"attempts"`
+	HintsUsed      int       `json:"hintsUsed"`
+	AnswerableAt   time.Time `json:"answerableAt"`
+	Ready          bool      `json:"ready"`
+	Spectator      bool      `json:"spectator"`
+	Guest          bool      `json:"guest"`
+	Finished       bool      `json:"finished"`
+	FinishedAt     time.Time `json:"finishedAt"`
+	Answered       int       `json:"answered"`
+	TotalAnswers   int       `json:"totalAnswers"`
+	Order          []int     `json:"order"`
+	Skipped        []int     `json:"skipped"`
+	PracticeNumber int       `json:"practiceNumber"`
+	Identity       string    `json:"identity"`
+	LateJoiner     bool      `json:"lateJoiner"`
+	// Variant is kept so a restart can't change the problem from under the player
+	Variant    *problemVariant `json:"variant"`
+	Timings    []ProblemTime   `json:"timings"`
+	TotalHints int             `json:"totalHints"`
+}
+
+// lobbySnapshot is everything needed to bring a lobby back after the server restarts
+type lobbySnapshot struct {
+	Id              string                   `json:"id"`
+	Name            string                   `json:"name"`
+	TimeLimit       int                      `json:"timeLimit"`
+	StartTime       *time.Time               `json:"startTime"`
+	Owner           *string                  `json:"owner"`
+	GameState       GameState                `json:"gameState"`
+	MinPlayers      int                      `json:"minPlayers"`
+	MaxPlayers      int                      `json:"maxPlayers"`
+	AllowGuests     bool                     `json:"allowGuests"`
+	AllowPractice   bool                     `json:"allowPractice"`
+	PasswordHash    string                   `json:"passwordHash"`
+	ChatFilter      bool                     `json:"chatFilter"`
+	Users           map[string]userSnapshot  `json:"users"`
+	UseCustom       bool                     `json:"useCustom"`
+	CustomProblems  []Problem                `json:"customProblems"`
+	CustomOrder     []int                    `json:"customOrder"`
+	Problems        []Problem                `json:"problems"`
+	Settings        GameSettings             `json:"settings"`
+	Served          map[int]int              `json:"served"`
+	ProblemResults  map[int]ProblemResult    `json:"problemResults"`
+	AnonymousLabels map[string]string        `json:"anonymousLabels"`
+	Round           int                      `json:"round"`
+	RoundStartedAt  time.Time                `json:"roundStartedAt"`
+	RoundBaselines  map[string]roundBaseline `json:"roundBaselines"`
+}
+
+// snapshotStore persists lobby snapshots to a directory
+type snapshotStore struct {
+	// directory is where snapshots are saved; snapshots are disabled if it's empty
+	directory string
+	// Saves and removals are serialised, so a snapshot taken just before a game finishes can't
+	// be written after the finished game's snapshot is removed
+	sync.Mutex
+}
+
+// snapshot captures the lobby's state
+func (l *Lobby) snapshot() lobbySnapshot {
+	l.RLock()
+	defer l.RUnlock()
+
+	snap := lobbySnapshot{
+		Id:              l.id,
+		Name:            l.name,
+		TimeLimit:       l.timeLimit,
+		StartTime:       l.startTime,
+		Owner:           l.owner,
+		GameState:       l.gameState,
+		MinPlayers:      l.minPlayers,
+		MaxPlayers:      l.maxPlayers,
+		AllowGuests:     l.allowGuests,
+		AllowPractice:   l.allowPractice,
+		PasswordHash:    l.passwordHash,
+		ChatFilter:      l.chatFilter,
+		Users:           make(map[string]userSnapshot, len(l.userMapping)),
+		UseCustom:       l.useCustom,
+		CustomProblems:  l.CustomProblems,
+		CustomOrder:     l.CustomOrder,
+		Problems:        l.problems,
+		Settings:        l.settings,
+		Served:          make(map[int]int, len(l.served)),
+		ProblemResults:  make(map[int]ProblemResult, len(l.problemResults)),
+		AnonymousLabels: make(map[string]string, len(l.anonymousLabels)),
+		Round:           l.round,
+		RoundStartedAt:  l.roundStartedAt,
+		RoundBaselines:  make(map[string]roundBaseline, len(l.roundBaselines)),
+	}
+	for name, user := range l.userMapping {
+		snap.Users[name] = userSnapshot{
+			Password:       user.password,
+			QuestionNumber: user.questionNumber,
+			Score:          user.score,
+			Attempts:       user.attempts,
+			HintsUsed:      user.hintsUsed,
+			AnswerableAt:   user.answerableAt,
+			Ready:          user.ready,
+			Spectator:      user.spectator,
+			Guest:          user.guest,
+			Finished:       user.finished,
+			FinishedAt:     user.finishedAt,
+			Answered:       user.answered,
+			TotalAnswers:   user.totalAnswers,
+			Order:          user.order,
+			Skipped:        user.skipped,
+			PracticeNumber: user.practiceNumber,
+			Identity:       user.identity,
+			LateJoiner:     user.lateJoiner,
+			Variant:        user.variant,
+			Timings:        user.timings,
+			TotalHints:     user.totalHints,
+		}
+	}
+	for i, count := range l.served {
+		snap.Served[i] = count
+	}
+	for i, result := range l.problemResults {
+		snap.ProblemResults[i] = result
+	}
+	for name, label := range l.anonymousLabels {
+		snap.AnonymousLabels[name] = label
+	}
+	for name, baseline := range l.roundBaselines {
+		snap.RoundBaselines[name] = baseline
+	}
+	return snap
+}
+
+// restoreLobby rebuilds a lobby from its snapshot; nobody is connected until they log in again
+func restoreLobby(m *Manager, snap lobbySnapshot) *Lobby {
+	l := NewLobby(m.ctx, snap.Name, snap.Id)
+	l.timeLimit = snap.TimeLimit
+	l.startTime = snap.StartTime
+	l.owner = snap.Owner
+	l.gameState = snap.GameState
+	l.minPlayers = snap.MinPlayers
+	l.maxPlayers = snap.MaxPlayers
+	l.allowGuests = snap.AllowGuests
+	l.allowPractice = snap.AllowPractice
+	l.passwordHash = snap.PasswordHash
+	l.chatFilter = snap.ChatFilter
+	l.useCustom = snap.UseCustom
+	l.CustomProblems = snap.CustomProblems
+	l.CustomOrder = snap.CustomOrder
+	l.problems = snap.Problems
+	l.settings = snap.Settings
+	for i, count := range snap.Served {
+		l.served[i] = count
+	}
+	for i, result := range snap.ProblemResults {
+		l.problemResults[i] = result
+	}
+	l.anonymousLabels = snap.AnonymousLabels
+	l.round = snap.Round
+	l.roundStartedAt = snap.RoundStartedAt
+	l.roundBaselines = snap.RoundBaselines
+	for name, user := range snap.Users {
+		l.userMapping[name] = User{
+			password:       user.Password,
+			questionNumber: user.QuestionNumber,
+			score:          user.Score,
+			attempts:       user.Attempts,
+			hintsUsed:      user.HintsUsed,
+			answerableAt:   user.AnswerableAt,
+			ready:          user.Ready,
+			spectator:      user.Spectator,
+			guest:          user.Guest,
+			finished:       user.Finished,
+			finishedAt:     user.FinishedAt,
+			answered:       user.Answered,
+			totalAnswers:   user.TotalAnswers,
+			order:          user.Order,
+			skipped:        user.Skipped,
+			practiceNumber: user.PracticeNumber,
+			identity:       user.Identity,
+			lateJoiner:     user.LateJoiner,
+			variant:        user.Variant,
+			timings:        user.Timings,
+			totalHints:     user.TotalHints,
+		}
+	}
+	return l
+}
+
+// snapshotPath is where the lobby's snapshot is saved
+func (s *snapshotStore) snapshotPath(id string) string {
+	return filepath.Join(s.directory, id+".snapshot.json")
+}
+
+// saveSnapshot persists the lobby's state, unless its game is over. The snapshot is written to a
+// temporary file first, so a crash mid-write can't leave a corrupt snapshot behind
+func (m *Manager) saveSnapshot(lobby *Lobby) {
+	if m.snapshots.directory == "" {
+		return
+	}
+	m.snapshots.Lock()
+	defer m.snapshots.Unlock()
+
+	snap := lobby.snapshot()
+	if snap.GameState == Finished {
+		return
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		log.Printf("Failed to snapshot lobby %s: %v", lobby.id, err)
+		return
+	}
+	if err := os.MkdirAll(m.snapshots.directory, os.ModePerm); err != nil {
+		log.Printf("Failed to create snapshots directory: %v", err)
+		return
+	}
+
+	tmp, err := os.CreateTemp(m.snapshots.directory, lobby.id+".*.tmp")
+	if err != nil {
+		log.Printf("Failed to snapshot lobby %s: %v", lobby.id, err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), m.snapshots.snapshotPath(lobby.id))
+	}
+	if err != nil {
+		log.Printf("Failed to snapshot lobby %s: %v", lobby.id, err)
+		os.Remove(tmp.Name())
+	}
+}
+
+// removeSnapshot deletes the lobby's snapshot, e.g. once its game is over
+func (m *Manager) removeSnapshot(lobby *Lobby) {
+	if m.snapshots.directory == "" {
+		return
+	}
+	m.snapshots.Lock()
+	defer m.snapshots.Unlock()
+
+	if err := os.Remove(m.snapshots.snapshotPath(lobby.id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove snapshot of lobby %s: %v", lobby.id, err)
+	}
+}
+
+// enableSnapshots saves every lobby to the directory on key events and every interval,
+// after restoring the lobbies snapshotted there (e.g. before a restart)
+func (m *Manager) enableSnapshots(directory string, interval time.Duration) error {
+	m.snapshots.directory = directory
+	if err := m.restoreSnapshots(); err != nil {
+		return err
+	}
+
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					for _, lobby := range m.allLobbies() {
+						m.saveSnapshot(lobby)
+					}
+				case <-m.ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// restoreSnapshots loads every snapshotted lobby whose game isn't over, resuming the timers of games in play
+func (m *Manager) restoreSnapshots() error {
+	entries, err := os.ReadDir(m.snapshots.directory)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".snapshot.json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(m.snapshots.directory, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var snap lobbySnapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			log.Printf("Skipping corrupt snapshot %s: %v", entry.Name(), err)
+			continue
+		}
+		if snap.GameState == Finished {
+			continue
+		}
+
+		lobby := restoreLobby(m, snap)
+		m.addLobby(lobby)
+		if lobby.inPlay() {
+			m.startGameTimers(lobby)
+		}
+		log.Printf("Restored lobby %s (%s)", lobby.id, lobby.gameState)
+	}
+	return nil
+}
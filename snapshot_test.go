@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshots_RestoreInPlayLobby(t *testing.T) {
+	dir := t.TempDir()
+	lobby := newTestLobby(t, []Problem{
+		{Title: "One", Latex: "a", Answer: "a", Hints: []string{"a letter"}},
+		{Title: "Two", Latex: "b", Answer: "b"},
+		{Title: "Three", Latex: "c", Answer: "c"},
+	})
+	manager := testManagers[lobby]
+	manager.snapshots.directory = dir
+
+	startTime := time.Unix(time.Now().Unix(), 0)
+	lobby.settings = GameSettings{MaxAttempts: 3, WeightedSelection: true}
+	lobby.startGame(startTime)
+	owner := "alice"
+	lobby.owner = &owner
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	lobby.userMapping["carol"] = User{password: "hash", spectator: true, practiceNumber: 2}
+	giveAnswer(t, alice, "wrong")
+	giveAnswer(t, alice, alice.getNewProblem().Problem.Latex)
+	giveAnswer(t, bob, "wrong")
+	manager.saveSnapshot(lobby)
+
+	// The server restarts
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	restarted := NewManager(ctx)
+	if err := restarted.enableSnapshots(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := restarted.getLobby(lobby.id)
+	if !ok {
+		t.Fatal("expected the lobby to be restored")
+	}
+	defer restored.endTimer.Stop()
+
+	if !restored.inPlay() || !restored.startTime.Equal(*lobby.startTime) || restored.timeLimit != lobby.timeLimit {
+		t.Errorf("expected the game to still be in play from %v, got %s from %v", lobby.startTime, restored.gameState, restored.startTime)
+	}
+	if !restored.isOwner("alice") || restored.name != lobby.name {
+		t.Errorf("expected alice to still own %s, got %v owning %s", lobby.name, restored.owner, restored.name)
+	}
+	if !reflect.DeepEqual(restored.CustomProblems, lobby.CustomProblems) || !reflect.DeepEqual(restored.CustomOrder, lobby.CustomOrder) {
+		t.Error("expected the problems and their order to be restored")
+	}
+	if !reflect.DeepEqual(restored.settings, lobby.settings) || !reflect.DeepEqual(restored.served, lobby.served) ||
+		!reflect.DeepEqual(restored.problemResults, lobby.problemResults) {
+		t.Error("expected the game's settings to be restored")
+	}
+	for name, user := range lobby.userMapping {
+		// Undo windows (and the last answer, kept to spot double submissions) aren't kept
+		user.undo, user.lastAnswer = nil, nil
+		restoredUser := restored.userMapping[name]
+		if !restoredUser.answerableAt.Equal(user.answerableAt) {
+			t.Errorf("expected %s's preview to end at %v, got %v", name, user.answerableAt, restoredUser.answerableAt)
+		}
+		// Times lose their location and monotonic reading when saved
+		restoredUser.answerableAt, user.answerableAt = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(restoredUser, user) {
+			t.Errorf("expected %s to be restored as %+v, got %+v", name, user, restoredUser)
+		}
+	}
+	if len(restored.clients) != 0 {
+		t.Error("expected nobody to be connected until they log in again")
+	}
+}
+
+func TestSnapshots_FinishedGamesAreRemoved(t *testing.T) {
+	dir := t.TempDir()
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	manager := testManagers[lobby]
+	manager.snapshots.directory = dir
+	lobby.startGame(time.Now())
+	addTestClient(lobby, "alice")
+
+	manager.saveSnapshot(lobby)
+	if _, err := os.Stat(manager.snapshots.snapshotPath(lobby.id)); err != nil {
+		t.Fatalf("expected a snapshot to be saved: %v", err)
+	}
+
+	manager.finishGame(lobby, "Game over!")
+	manager.saveSnapshot(lobby)
+	if _, err := os.Stat(manager.snapshots.snapshotPath(lobby.id)); !os.IsNotExist(err) {
+		t.Error("expected the snapshot of a finished game to be removed")
+	}
+
+	restarted := NewManager(context.Background())
+	if err := restarted.enableSnapshots(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := restarted.getLobby(lobby.id); ok {
+		t.Error("expected a finished game not to be restored")
+	}
+}
+
+func TestSnapshots_GuestLoginsAreSaved(t *testing.T) {
+	dir := t.TempDir()
+	lobby := newTestLobby(t, nil)
+	lobby.allowGuests = true
+	manager := testManagers[lobby]
+	manager.snapshots.directory = dir
+
+	if rec := login(t, manager, lobby.id, "guest", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the guest to log in, got %d", rec.Code)
+	}
+	restarted := NewManager(context.Background())
+	if err := restarted.enableSnapshots(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	restored, ok := restarted.getLobby(lobby.id)
+	if !ok {
+		t.Fatal("expected the lobby to be restored")
+	}
+	if user, ok := restored.userMapping["guest"]; !ok || !user.guest {
+		t.Errorf("expected the guest to be restored, got %+v", restored.userMapping)
+	}
+}
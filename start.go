@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// PrepareStartEvent is sent when a synchronized game is about to start. It starts once every player has
+// acknowledged it, or at the deadline, whichever comes first
+type PrepareStartEvent struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+// prepareStart returns the event telling players a synchronized start is pending.
+// @dev Requires the lobby's lock to be held
+func (l *Lobby) prepareStart() (Event, error) {
+	data, err := json.Marshal(PrepareStartEvent{l.startTime.Add(config.StartAckTimeout)})
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to marshal prepare start: %v", err)
+	}
+	return Event{EventPrepareStart, data}, nil
+}
+
+// awaitStartAcks asks every connected player to acknowledge the game is starting, holding back its clock and
+// first problem until they all have (or config.StartAckTimeout runs out). c is the client who started the game
+func (c *Client) awaitStartAcks() error {
+	lobby := c.lobby
+
+	lobby.Lock()
+	lobby.awaitingAcks = make(map[string]bool)
+	for client := range lobby.clients {
+		if !lobby.userMapping[client.name].spectator {
+			lobby.awaitingAcks[client.name] = true
+		}
+	}
+	prepare, err := lobby.prepareStart()
+	lobby.Unlock()
+	if err != nil {
+		return err
+	}
+	lobby.broadcast(prepare)
+
+	// The timer is only started once everyone's been asked, so the game can't start before they have
+	lobby.Lock()
+	defer lobby.Unlock()
+	timeout := config.StartAckTimeout
+	if len(lobby.awaitingAcks) == 0 {
+		timeout = 0
+	}
+	lobby.startAckTimer = time.AfterFunc(timeout, c.releaseStart)
+	return nil
+}
+
+// releaseStart starts a synchronized game's clock now, and sends the players their first problem
+func (c *Client) releaseStart() {
+	lobby := c.lobby
+
+	lobby.Lock()
+	if lobby.awaitingAcks == nil || lobby.gameState != InPlay {
+		lobby.Unlock()
+		return
+	}
+	lobby.awaitingAcks = nil
+	lobby.startAckTimer = nil
+	startTime := time.Now()
+	lobby.startTime = &startTime
+	lobby.beginRound(startTime)
+	lobby.Unlock()
+	c.manager.saveSnapshot(lobby)
+
+	if err := c.launchGame(); err != nil {
+		log.Println(err)
+	}
+}
+
+// StartAckHandler records that the player is ready for a synchronized game to start, starting it if they're
+// the last one it was waiting on
+func StartAckHandler(event Event, c *Client) error {
+	lobby := c.lobby
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	// Acks that arrive late, or twice, are harmless
+	if lobby.awaitingAcks == nil {
+		return nil
+	}
+	delete(lobby.awaitingAcks, c.name)
+	if len(lobby.awaitingAcks) == 0 && lobby.startAckTimer != nil && lobby.startAckTimer.Stop() {
+		lobby.startAckTimer.Reset(0)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// useStartAckTimeout sets how long the lobby's synchronized starts wait for the test. The start's timer is
+// stopped before the config is put back, so it can't go off while that happens
+func useStartAckTimeout(t *testing.T, lobby *Lobby, timeout time.Duration) {
+	previous := config
+	config.StartAckTimeout = timeout
+	t.Cleanup(func() {
+		lobby.stopWaiting()
+		config = previous
+	})
+}
+
+// startSynchronizedGame starts a synchronized game as the owner, checking everyone is only asked to get ready
+func startSynchronizedGame(t *testing.T, owner *Client, players ...*Client) {
+	t.Helper()
+	err := requestStartGame(t, owner, RequestStartGameEvent{GameSettings: GameSettings{SynchronizedStart: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range append([]*Client{owner}, players...) {
+		events := drainEvents(c)
+		if len(events) != 1 || events[0].Type != EventPrepareStart {
+			t.Fatalf("expected %s to only be asked to get ready, got %v", c.name, events)
+		}
+	}
+}
+
+// awaitStart waits for the game to start for the client, returning the start time they're sent and checking
+// their first problem comes with it
+func awaitStart(t *testing.T, c *Client, timeout time.Duration) time.Time {
+	t.Helper()
+	var start StartGameEvent
+	deadline := time.After(timeout)
+	for {
+		select {
+		case event := <-c.egress:
+			switch event.Type {
+			case EventStartGame:
+				if err := json.Unmarshal(event.Payload, &start); err != nil {
+					t.Fatal(err)
+				}
+			case EventNewProblem:
+				if start.StartTimestamp.IsZero() {
+					t.Fatalf("expected %s to be told the game started before their first problem", c.name)
+				}
+				return start.StartTimestamp
+			}
+		case <-deadline:
+			t.Fatalf("expected the game to start for %s", c.name)
+			return time.Time{}
+		}
+	}
+}
+
+func TestSynchronizedStart_AllAcks(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	useStartAckTimeout(t, lobby, time.Minute)
+	owner := addTestClient(lobby, "owner")
+	bob := addTestClient(lobby, "bob")
+	startSynchronizedGame(t, owner, bob)
+
+	if err := StartAckHandler(Event{EventStartAck, nil}, owner); err != nil {
+		t.Fatal(err)
+	}
+	// The clock doesn't start, and nobody can play, while bob's still loading
+	time.Sleep(50 * time.Millisecond)
+	if events := drainEvents(owner); len(events) != 0 {
+		t.Fatalf("expected the game to wait for bob, got %v", events)
+	}
+	lobby.RLock()
+	if lobby.endTimer != nil {
+		t.Error("expected the clock not to have started")
+	}
+	lobby.RUnlock()
+	if err := giveAnswer(t, owner, "a"); err == nil {
+		t.Error("expected answers to be rejected before the game starts")
+	}
+	drainEvents(owner)
+
+	acked := time.Now()
+	if err := StartAckHandler(Event{EventStartAck, nil}, bob); err != nil {
+		t.Fatal(err)
+	}
+	ownerStart := awaitStart(t, owner, time.Second)
+	bobStart := awaitStart(t, bob, time.Second)
+	if !ownerStart.Equal(bobStart) {
+		t.Errorf("expected everyone to start together, got %v and %v", ownerStart, bobStart)
+	} else if ownerStart.Before(acked) {
+		t.Errorf("expected the clock to start after the last ack at %v, got %v", acked, ownerStart)
+	}
+	if err := giveAnswer(t, bob, "a"); err != nil {
+		t.Errorf("expected answers to be accepted once the game starts, got %v", err)
+	}
+}
+
+func TestSynchronizedStart_Timeout(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{Title: "One", Latex: "a", Answer: "a"}})
+	useStartAckTimeout(t, lobby, 100*time.Millisecond)
+	owner := addTestClient(lobby, "owner")
+	bob := addTestClient(lobby, "bob")
+	started := time.Now()
+	startSynchronizedGame(t, owner, bob)
+
+	// Bob never acks, so the game starts without waiting on them any longer
+	if err := StartAckHandler(Event{EventStartAck, nil}, owner); err != nil {
+		t.Fatal(err)
+	}
+	ownerStart := awaitStart(t, owner, time.Second)
+	bobStart := awaitStart(t, bob, time.Second)
+	if !ownerStart.Equal(bobStart) {
+		t.Errorf("expected everyone to start together, got %v and %v", ownerStart, bobStart)
+	} else if ownerStart.Sub(started) < config.StartAckTimeout {
+		t.Errorf("expected the clock to start once the timeout ran out, but it started after %v", ownerStart.Sub(started))
+	}
+	lobby.RLock()
+	if lobby.endTimer == nil {
+		t.Error("expected the clock to be running")
+	}
+	lobby.RUnlock()
+}
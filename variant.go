@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// VariableRange is the whole numbers (inclusive) a problem's variable can take
+type VariableRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// problemVariant is the variant of a template problem generated for a user
+type problemVariant struct {
+	// QuestionNumber is the user's question number the variant was generated for
+	QuestionNumber int     `json:"questionNumber"`
+	Problem        Problem `json:"problem"`
+}
+
+// placeholderPattern matches a template's placeholders, e.g. [[n - 1]]
+var placeholderPattern = regexp.MustCompile(`\[\[(.*?)\]\]`)
+
+// variableNamePattern matches the names variables can have
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// isTemplate reports whether each player gets their own variant of the problem
+func (p *Problem) isTemplate() bool {
+	return len(p.Variables) > 0
+}
+
+// variant generates a variant of the template problem, giving each variable a random value and filling in the
+// placeholders in its text, answers and hints
+func (p Problem) variant(r *rand.Rand) (Problem, error) {
+	// Variables are picked in a fixed order, so the same random source generates the same variant
+	names := make([]string, 0, len(p.Variables))
+	for name := range p.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make(map[string]int, len(names))
+	for _, name := range names {
+		bounds := p.Variables[name]
+		values[name] = bounds.Min + r.Intn(bounds.Max-bounds.Min+1)
+	}
+	return p.withValues(values)
+}
+
+// withValues fills in the template problem's placeholders with the given values of its variables
+func (p Problem) withValues(values map[string]int) (Problem, error) {
+	var err error
+	fill := func(text string) string {
+		filled, fillErr := fillPlaceholders(text, values)
+		if fillErr != nil && err == nil {
+			err = fillErr
+		}
+		return filled
+	}
+
+	v := p
+	v.Variables = nil
+	v.Title = fill(p.Title)
+	v.Description = fill(p.Description)
+	v.Latex = fill(p.Latex)
+	v.Answer = fill(p.Answer)
+	v.AcceptableAnswers = nil
+	for _, answer := range p.AcceptableAnswers {
+		v.AcceptableAnswers = append(v.AcceptableAnswers, fill(answer))
+	}
+	v.Hints = nil
+	for _, hint := range p.Hints {
+		v.Hints = append(v.Hints, fill(hint))
+	}
+	return v, err
+}
+
+// fillPlaceholders replaces each placeholder in the text with the value of its expression
+func fillPlaceholders(text string, values map[string]int) (string, error) {
+	var err error
+	filled := placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		expression := placeholderPattern.FindStringSubmatch(placeholder)[1]
+		value, evalErr := evaluate(expression, values)
+		if evalErr != nil {
+			if err == nil {
+				err = fmt.Errorf("placeholder %s: %v", placeholder, evalErr)
+			}
+			return placeholder
+		}
+		return strconv.Itoa(value)
+	})
+	return filled, err
+}
+
+// validateTemplate checks the problem's variables, and that every placeholder is an expression of them
+func validateTemplate(i int, p Problem) []ProblemError {
+	errs := make([]ProblemError, 0)
+	names := make([]string, 0, len(p.Variables))
+	for name := range p.Variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make(map[string]int, len(names))
+	for _, name := range names {
+		bounds := p.Variables[name]
+		if !variableNamePattern.MatchString(name) {
+			errs = append(errs, ProblemError{i, "variables." + name, "must be named with letters, digits and underscores, starting with a letter"})
+		}
+		if bounds.Min > bounds.Max {
+			errs = append(errs, ProblemError{i, "variables." + name, "min must not be more than max"})
+		}
+		values[name] = bounds.Min
+	}
+
+	check := func(field string, text string) {
+		if _, err := fillPlaceholders(text, values); err != nil {
+			errs = append(errs, ProblemError{i, field, err.Error()})
+		}
+	}
+	check("title", p.Title)
+	check("description", p.Description)
+	check("latex", p.Latex)
+	check("answer", p.Answer)
+	for j, answer := range p.AcceptableAnswers {
+		check(fmt.Sprintf("acceptableAnswers[%d]", j), answer)
+	}
+	for j, hint := range p.Hints {
+		check(fmt.Sprintf("hints[%d]", j), hint)
+	}
+	return errs
+}
+
+// currentProblem returns the index (into the lobby's problems) of the client's current problem, and the problem
+// as the client sees it. Template problems are generated for each player the first time they're served
+func (client *Client) currentProblem() (int, Problem) {
+	index := client.problemIndex()
+	problem := client.lobby.getLobbyProblems()[index]
+	if !problem.isTemplate() {
+		return index, problem
+	}
+
+	lobby := client.lobby
+	lobby.Lock()
+	defer lobby.Unlock()
+
+	user := lobby.userMapping[client.name]
+	if user.variant != nil && user.variant.QuestionNumber == user.questionNumber {
+		return index, user.variant.Problem
+	}
+	variant, err := problem.variant(lobby.random(client.name))
+	if err != nil {
+		// Only problems that weren't validated can get here
+		log.Printf("Failed to generate a variant of %q: %v", problem.Title, err)
+	}
+	user.variant = &problemVariant{user.questionNumber, variant}
+	lobby.userMapping[client.name] = user
+	return index, variant
+}
+
+// evaluate works out an integer expression of the variables, made up of whole numbers, variables, +, -, *
+// and parentheses
+func evaluate(expression string, values map[string]int) (int, error) {
+	e := expressionParser{text: expression, values: values}
+	value, err := e.sum()
+	if err != nil {
+		return 0, err
+	}
+	e.skipSpaces()
+	if e.pos < len(e.text) {
+		return 0, fmt.Errorf("unexpected %q", e.text[e.pos:])
+	}
+	return value, nil
+}
+
+// expressionParser is a recursive descent parser for placeholders' expressions
+type expressionParser struct {
+	text   string
+	pos    int
+	values map[string]int
+}
+
+func (e *expressionParser) skipSpaces() {
+	for e.pos < len(e.text) && e.text[e.pos] == ' ' {
+		e.pos++
+	}
+}
+
+// sum parses terms separated by + or -
+func (e *expressionParser) sum() (int, error) {
+	total, err := e.product()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		e.skipSpaces()
+		if e.pos == len(e.text) || (e.text[e.pos] != '+' && e.text[e.pos] != '-') {
+			return total, nil
+		}
+		op := e.text[e.pos]
+		e.pos++
+		term, err := e.product()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			total += term
+		} else {
+			total -= term
+		}
+	}
+}
+
+// product parses factors separated by *
+func (e *expressionParser) product() (int, error) {
+	total, err := e.factor()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		e.skipSpaces()
+		if e.pos == len(e.text) || e.text[e.pos] != '*' {
+			return total, nil
+		}
+		e.pos++
+		factor, err := e.factor()
+		if err != nil {
+			return 0, err
+		}
+		total *= factor
+	}
+}
+
+// factor parses a number, a variable, a negated factor or a parenthesised sum
+func (e *expressionParser) factor() (int, error) {
+	e.skipSpaces()
+	if e.pos == len(e.text) {
+		return 0, fmt.Errorf("unexpected end of expression")
+	}
+	switch c := e.text[e.pos]; {
+	case c == '-':
+		e.pos++
+		value, err := e.factor()
+		return -value, err
+	case c == '(':
+		e.pos++
+		value, err := e.sum()
+		if err != nil {
+			return 0, err
+		}
+		e.skipSpaces()
+		if e.pos == len(e.text) || e.text[e.pos] != ')' {
+			return 0, fmt.Errorf("missing ')'")
+		}
+		e.pos++
+		return value, nil
+	case isDigit(c):
+		start := e.pos
+		for e.pos < len(e.text) && isDigit(e.text[e.pos]) {
+			e.pos++
+		}
+		return strconv.Atoi(e.text[start:e.pos])
+	case isLetter(c):
+		start := e.pos
+		for e.pos < len(e.text) && (isLetter(e.text[e.pos]) || isDigit(e.text[e.pos]) || e.text[e.pos] == '_') {
+			e.pos++
+		}
+		name := e.text[start:e.pos]
+		value, ok := e.values[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown variable %q", name)
+		}
+		return value, nil
+	default:
+		return 0, fmt.Errorf("unexpected %q", e.text[e.pos:])
+	}
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
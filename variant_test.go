@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFillPlaceholders(t *testing.T) {
+	values := map[string]int{"n": 5, "a_1": -2}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{`x^{[[n]]}`, `x^{5}`},
+		{`[[n]]x^{[[n - 1]]}`, `5x^{4}`},
+		{`[[2*(n + a_1)]]`, `6`},
+		{`[[-a_1 * -n]]`, `-10`},
+		{`\left[ x \right]`, `\left[ x \right]`},
+	}
+	for _, test := range tests {
+		filled, err := fillPlaceholders(test.text, values)
+		if err != nil {
+			t.Errorf("failed to fill %q: %v", test.text, err)
+		} else if filled != test.expected {
+			t.Errorf("expected %q to be filled in as %q, got %q", test.text, test.expected, filled)
+		}
+	}
+
+	for _, text := range []string{`[[m]]`, `[[n +]]`, `[[(n]]`, `[[n / 2]]`} {
+		if _, err := fillPlaceholders(text, values); err == nil {
+			t.Errorf("expected %q not to be filled in", text)
+		}
+	}
+}
+
+func TestValidateProblems_Templates(t *testing.T) {
+	problems := []Problem{{
+		Title:       "Differentiate",
+		Description: "Find the derivative",
+		Latex:       `x^{[[n]]}`,
+		Answer:      `[[n]]x^{[[m]]}`,
+		Variables:   map[string]VariableRange{"n": {Min: 5, Max: 2}, "2n": {Min: 1, Max: 1}},
+	}}
+	errs := validateProblems(problems)
+	fields := make([]string, len(errs))
+	for i, err := range errs {
+		fields[i] = err.Field
+	}
+	if strings.Join(fields, ",") != "variables.2n,variables.n,answer" {
+		t.Errorf("expected the bad variables and the unknown one in the answer to be reported, got %v", errs)
+	}
+}
+
+func TestGiveAnswerHandler_ProblemVariants(t *testing.T) {
+	lobby := newTestLobby(t, []Problem{{
+		Title:       "Differentiate",
+		Description: "Find the derivative",
+		Latex:       `\frac{d}{dx} x^{[[n]]}`,
+		Answer:      `[[n]]x^{[[n - 1]]}`,
+		Variables:   map[string]VariableRange{"n": {Min: 2, Max: 1000}},
+	}})
+	lobby.settings.Seed = 1
+	lobby.startGame(time.Now())
+	alice := addTestClient(lobby, "alice")
+	bob := addTestClient(lobby, "bob")
+	alice.sendClientProblem()
+	bob.sendClientProblem()
+
+	served := func(c *Client) Problem {
+		t.Helper()
+		for _, event := range drainEvents(c) {
+			if event.Type == EventNewProblem {
+				var problem NewProblemEvent
+				if err := json.Unmarshal(event.Payload, &problem); err != nil {
+					t.Fatal(err)
+				}
+				return problem.Problem
+			}
+		}
+		t.Fatalf("expected %s to be sent a problem", c.name)
+		return Problem{}
+	}
+	alicesProblem, bobsProblem := served(alice), served(bob)
+	if strings.Contains(alicesProblem.Latex, "[[") || alicesProblem.Answer != "" {
+		t.Fatalf("expected a filled in variant without its answer, got %+v", alicesProblem)
+	}
+	if alicesProblem.Latex == bobsProblem.Latex {
+		t.Fatalf("expected the players to get different variants, both got %s", alicesProblem.Latex)
+	}
+
+	// Each player's answer is worked out for their own variant
+	alicesAnswer := lobby.userMapping["alice"].variant.Problem.Answer
+	bobsAnswer := lobby.userMapping["bob"].variant.Problem.Answer
+	var n int
+	if _, err := fmt.Sscanf(alicesProblem.Latex, `\frac{d}{dx} x^{%d}`, &n); err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprintf("%dx^{%d}", n, n-1); alicesAnswer != expected {
+		t.Errorf("expected alice's answer to be %s, got %s", expected, alicesAnswer)
+	}
+
+	giveAnswer(t, bob, alicesAnswer)
+	if user := lobby.userMapping["bob"]; user.answered != 0 {
+		t.Error("expected alice's answer to be wrong for bob's variant")
+	}
+	giveAnswer(t, bob, bobsAnswer)
+	giveAnswer(t, alice, alicesAnswer)
+	for _, name := range []string{"alice", "bob"} {
+		if user := lobby.userMapping[name]; user.answered != 1 {
+			t.Errorf("expected %s's own answer to be right, got %+v", name, user)
+		}
+	}
+}
+
+func TestProblemVariants_SeedReproducesEachPlayer(t *testing.T) {
+	template := Problem{
+		Title:     "Power",
+		Latex:     `x^{[[n]]}`,
+		Answer:    `x^{[[n]]}`,
+		Variables: map[string]VariableRange{"n": {Min: 2, Max: 1000}},
+	}
+	// The same seed gives alice the same variant, whether or not bob's is generated first
+	variants := make([]string, 2)
+	for i, bobFirst := range []bool{false, true} {
+		lobby := newTestLobby(t, []Problem{template})
+		lobby.settings.Seed = 1
+		lobby.startGame(time.Now())
+		alice := addTestClient(lobby, "alice")
+		bob := addTestClient(lobby, "bob")
+		if bobFirst {
+			bob.currentProblem()
+		}
+		_, problem := alice.currentProblem()
+		variants[i] = problem.Latex
+	}
+	if variants[0] != variants[1] {
+		t.Errorf("expected alice to get the same variant either way, got %s and %s", variants[0], variants[1])
+	}
+}